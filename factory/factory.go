@@ -0,0 +1,63 @@
+package factory
+
+import (
+	"github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/local"
+	"github.com/buildpacks/imgutil/remote"
+)
+
+type ImageOption func(*options)
+
+type options struct {
+	fromDaemon   bool
+	dockerClient client.CommonAPIClient
+	localOps     []local.ImageOption
+	keychain     authn.Keychain
+	remoteOps    []remote.ImageOption
+	sources      int
+}
+
+// FromDaemon creates a local image, stored in the daemon of dockerClient, which must not be nil.
+func FromDaemon(dockerClient client.CommonAPIClient, ops ...local.ImageOption) ImageOption {
+	return func(o *options) {
+		o.fromDaemon = true
+		o.dockerClient = dockerClient
+		o.localOps = ops
+		o.sources++
+	}
+}
+
+// FromRegistry creates a remote image, stored in a registry and authenticated with keychain.
+func FromRegistry(keychain authn.Keychain, ops ...remote.ImageOption) ImageOption {
+	return func(o *options) {
+		o.keychain = keychain
+		o.remoteOps = ops
+		o.sources++
+	}
+}
+
+// NewImage creates a local or remote image depending on whether FromDaemon or FromRegistry is given.
+// Exactly one of them must be provided.
+func NewImage(repoName string, opts ...ImageOption) (imgutil.Image, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	switch {
+	case o.sources == 0:
+		return nil, errors.New("an image source must be specified with FromDaemon or FromRegistry")
+	case o.sources > 1:
+		return nil, errors.New("only one image source may be specified")
+	case o.fromDaemon && o.dockerClient == nil:
+		return nil, errors.New("FromDaemon requires a docker client")
+	case o.fromDaemon:
+		return local.NewImage(repoName, o.dockerClient, o.localOps...)
+	default:
+		return remote.NewImage(repoName, o.keychain, o.remoteOps...)
+	}
+}
@@ -0,0 +1,67 @@
+package factory_test
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/factory"
+	"github.com/buildpacks/imgutil/local"
+	"github.com/buildpacks/imgutil/remote"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestFactory(t *testing.T) {
+	spec.Run(t, "Factory", testFactory, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testFactory(t *testing.T, when spec.G, it spec.S) {
+	when("#NewImage", func() {
+		when("FromRegistry is given", func() {
+			it("returns a remote image", func() {
+				img, err := factory.NewImage("some-registry/some-repo", factory.FromRegistry(authn.DefaultKeychain))
+				h.AssertNil(t, err)
+
+				_, ok := img.(*remote.Image)
+				h.AssertEq(t, ok, true)
+			})
+		})
+
+		when("FromDaemon is given", func() {
+			it("returns a local image", func() {
+				img, err := factory.NewImage("some-repo", factory.FromDaemon(h.DockerCli(t)))
+				h.AssertNil(t, err)
+
+				_, ok := img.(*local.Image)
+				h.AssertEq(t, ok, true)
+			})
+		})
+
+		when("FromDaemon is given a nil client", func() {
+			it("returns an error", func() {
+				_, err := factory.NewImage("some-repo", factory.FromDaemon(nil))
+				h.AssertError(t, err, "FromDaemon requires a docker client")
+			})
+		})
+
+		when("no source is given", func() {
+			it("returns an error", func() {
+				_, err := factory.NewImage("some-repo")
+				h.AssertError(t, err, "an image source must be specified with FromDaemon or FromRegistry")
+			})
+		})
+
+		when("both sources are given", func() {
+			it("returns an error", func() {
+				_, err := factory.NewImage(
+					"some-repo",
+					factory.FromDaemon(h.DockerCli(t)),
+					factory.FromRegistry(authn.DefaultKeychain),
+				)
+				h.AssertError(t, err, "only one image source may be specified")
+			})
+		})
+	})
+}
@@ -4,15 +4,19 @@ import (
 	"archive/tar"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/v1util"
 	"github.com/pkg/errors"
 
 	"github.com/buildpacks/imgutil"
@@ -42,6 +46,8 @@ type Image struct {
 	layersMap     map[string]string
 	prevLayersMap map[string]string
 	reusedLayers  []string
+	diffIDs       []string
+	layerHistory  []v1.History
 	labels        map[string]string
 	env           map[string]string
 	topLayerSha   string
@@ -56,7 +62,17 @@ type Image struct {
 	createdAt     time.Time
 	layerDir      string
 	workingDir    string
+	stopSignal    string
+	onBuild       []string
+	shell         []string
+	user          string
+	exposedPorts  map[string]struct{}
+	healthcheck   *imgutil.HealthConfig
+	volumes       map[string]struct{}
+	annotations   map[string]string
+	squashed      bool
 	savedNames    map[string]bool
+	tempLayers    []string
 }
 
 func (i *Image) CreatedAt() (time.Time, error) {
@@ -75,6 +91,14 @@ func (i *Image) Labels() (map[string]string, error) {
 	return copiedLabels, nil
 }
 
+func (i *Image) Annotations() (map[string]string, error) {
+	copiedAnnotations := make(map[string]string)
+	for k, v := range i.annotations {
+		copiedAnnotations[k] = v
+	}
+	return copiedAnnotations, nil
+}
+
 func (i *Image) OS() (string, error) {
 	return i.os, nil
 }
@@ -91,19 +115,85 @@ func (i *Image) Rename(name string) {
 	i.name = name
 }
 
+func (i *Image) Clone(newName string) (imgutil.Image, error) {
+	clone := *i
+	clone.name = newName
+	clone.layers = append([]string(nil), i.layers...)
+	clone.reusedLayers = append([]string(nil), i.reusedLayers...)
+	clone.diffIDs = append([]string(nil), i.diffIDs...)
+	clone.entryPoint = append([]string(nil), i.entryPoint...)
+	clone.cmd = append([]string(nil), i.cmd...)
+	clone.onBuild = append([]string(nil), i.onBuild...)
+	clone.shell = append([]string(nil), i.shell...)
+	clone.layersMap = copyStringMap(i.layersMap)
+	clone.prevLayersMap = copyStringMap(i.prevLayersMap)
+	clone.layerHistory = append([]v1.History(nil), i.layerHistory...)
+	clone.labels = copyStringMap(i.labels)
+	clone.env = copyStringMap(i.env)
+	clone.annotations = copyStringMap(i.annotations)
+	clone.exposedPorts = copySet(i.exposedPorts)
+	clone.volumes = copySet(i.volumes)
+	if i.healthcheck != nil {
+		healthcheck := *i.healthcheck
+		healthcheck.Test = append([]string(nil), i.healthcheck.Test...)
+		clone.healthcheck = &healthcheck
+	}
+	clone.savedNames = map[string]bool{}
+	clone.tempLayers = nil
+	return &clone, nil
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func copySet(m map[string]struct{}) map[string]struct{} {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]struct{}, len(m))
+	for k := range m {
+		copied[k] = struct{}{}
+	}
+	return copied
+}
+
 func (i *Image) Name() string {
 	return i.name
 }
 
+func (i *Image) Registry() string {
+	return imgutil.RegistryFromName(i.name, name.WeakValidation)
+}
+
+func (i *Image) Repository() string {
+	return imgutil.RepositoryFromName(i.name, name.WeakValidation)
+}
+
+func (i *Image) Tag() (string, error) {
+	return imgutil.TagFromName(i.name, name.WeakValidation)
+}
+
 func (i *Image) Identifier() (imgutil.Identifier, error) {
 	return i.identifier, nil
 }
 
-func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
+func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image, opts ...imgutil.RebaseOption) error {
 	i.base = newBase.Name()
 	return nil
 }
 
+func (i *Image) RebasePlan(baseTopLayer string, newBase imgutil.Image, opts ...imgutil.RebaseOption) (*imgutil.RebaseResult, error) {
+	return imgutil.PlanRebase(i, baseTopLayer, newBase, opts...)
+}
+
 func (i *Image) SetLabel(k string, v string) error {
 	if i.labels == nil {
 		i.labels = map[string]string{}
@@ -112,6 +202,32 @@ func (i *Image) SetLabel(k string, v string) error {
 	return nil
 }
 
+func (i *Image) SetLabels(labels map[string]string) error {
+	if i.labels == nil {
+		i.labels = map[string]string{}
+	}
+	for k, v := range labels {
+		i.labels[k] = v
+	}
+	return nil
+}
+
+func (i *Image) SetLabelsFromFile(path string) error {
+	labels, err := imgutil.ReadLabelsFile(path)
+	if err != nil {
+		return err
+	}
+	return i.SetLabels(labels)
+}
+
+func (i *Image) SetAnnotation(k string, v string) error {
+	if i.annotations == nil {
+		i.annotations = map[string]string{}
+	}
+	i.annotations[k] = v
+	return nil
+}
+
 func (i *Image) RemoveLabel(key string) error {
 	delete(i.labels, key)
 	return nil
@@ -122,6 +238,11 @@ func (i *Image) SetEnv(k string, v string) error {
 	return nil
 }
 
+func (i *Image) RemoveEnv(k string) error {
+	delete(i.env, k)
+	return nil
+}
+
 func (i *Image) SetOS(o string) error {
 	i.os = o
 	return nil
@@ -142,6 +263,97 @@ func (i *Image) SetWorkingDir(dir string) error {
 	return nil
 }
 
+func (i *Image) WorkingDir() (string, error) {
+	return i.workingDir, nil
+}
+
+func (i *Image) SetStopSignal(sig string) error {
+	i.stopSignal = sig
+	return nil
+}
+
+func (i *Image) StopSignal() (string, error) {
+	return i.stopSignal, nil
+}
+
+func (i *Image) SetOnBuild(triggers ...string) error {
+	i.onBuild = triggers
+	return nil
+}
+
+func (i *Image) OnBuild() ([]string, error) {
+	return i.onBuild, nil
+}
+
+func (i *Image) SetShell(shell ...string) error {
+	i.shell = shell
+	return nil
+}
+
+func (i *Image) Shell() ([]string, error) {
+	return i.shell, nil
+}
+
+func (i *Image) SetUser(user string) error {
+	i.user = user
+	return nil
+}
+
+func (i *Image) User() (string, error) {
+	return i.user, nil
+}
+
+func (i *Image) ExposedPorts() (map[string]struct{}, error) {
+	ports := make(map[string]struct{}, len(i.exposedPorts))
+	for port := range i.exposedPorts {
+		ports[port] = struct{}{}
+	}
+	return ports, nil
+}
+
+func (i *Image) SetExposedPort(port string) error {
+	if i.exposedPorts == nil {
+		i.exposedPorts = map[string]struct{}{}
+	}
+	i.exposedPorts[port] = struct{}{}
+	return nil
+}
+
+func (i *Image) RemoveExposedPort(port string) error {
+	delete(i.exposedPorts, port)
+	return nil
+}
+
+func (i *Image) Volumes() (map[string]struct{}, error) {
+	volumes := make(map[string]struct{}, len(i.volumes))
+	for path := range i.volumes {
+		volumes[path] = struct{}{}
+	}
+	return volumes, nil
+}
+
+func (i *Image) SetVolume(path string) error {
+	if i.volumes == nil {
+		i.volumes = map[string]struct{}{}
+	}
+	i.volumes[path] = struct{}{}
+	return nil
+}
+
+func (i *Image) RemoveVolume(path string) error {
+	delete(i.volumes, path)
+	return nil
+}
+
+func (i *Image) Healthcheck() (*imgutil.HealthConfig, error) {
+	return i.healthcheck, nil
+}
+
+func (i *Image) SetHealthcheck(hc *imgutil.HealthConfig) error {
+	i.healthcheck = hc
+	return nil
+}
+
 func (i *Image) SetEntrypoint(v ...string) error {
 	i.entryPoint = v
 	return nil
@@ -152,28 +364,158 @@ func (i *Image) SetCmd(v ...string) error {
 	return nil
 }
 
+func (i *Image) ClearEntrypoint() error {
+	i.entryPoint = nil
+	return nil
+}
+
+func (i *Image) ClearCmd() error {
+	i.cmd = nil
+	return nil
+}
+
 func (i *Image) Env(k string) (string, error) {
 	return i.env[k], nil
 }
 
+func (i *Image) EnvVars() ([]string, error) {
+	envVars := []string{}
+	for k, v := range i.env {
+		envVars = append(envVars, k+"="+v)
+	}
+	sort.Strings(envVars)
+	return envVars, nil
+}
+
+func (i *Image) EnvMap() (map[string]string, error) {
+	return copyStringMap(i.env), nil
+}
+
 func (i *Image) TopLayer() (string, error) {
 	return i.topLayerSha, nil
 }
 
-func (i *Image) AddLayer(path string) error {
+// LayerDiffIDs returns the diff IDs of the added and reused layers in the order they were applied
+func (i *Image) LayerDiffIDs() ([]string, error) {
+	return append([]string(nil), i.diffIDs...), nil
+}
+
+func (i *Image) NumberOfLayers() (int, error) {
+	return len(i.diffIDs), nil
+}
+
+func (i *Image) Size() (int64, error) {
+	var size int64
+	for _, path := range i.layers {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		size += fi.Size()
+	}
+	return size, nil
+}
+
+// RawConfig returns a config built from the runtime config fields set on the fake
+func (i *Image) RawConfig() ([]byte, error) {
+	cfg, err := i.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(cfg)
+}
+
+func (i *Image) ConfigFile() (*v1.ConfigFile, error) {
+	var env []string
+	for k, v := range i.env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	cfg := &v1.ConfigFile{
+		Created:      v1.Time{Time: i.createdAt},
+		OS:           i.os,
+		OSVersion:    i.osVersion,
+		Architecture: i.architecture,
+		Config: v1.Config{
+			Labels:       i.labels,
+			Env:          env,
+			Entrypoint:   i.entryPoint,
+			Cmd:          i.cmd,
+			WorkingDir:   i.workingDir,
+			User:         i.user,
+			ExposedPorts: i.exposedPorts,
+			Volumes:      i.volumes,
+			StopSignal:   i.stopSignal,
+			OnBuild:      i.onBuild,
+			Shell:        i.shell,
+		},
+	}
+	return cfg.DeepCopy(), nil
+}
+
+func (i *Image) AddLayer(path string, opts ...imgutil.LayerOption) error {
 	sha, err := shaForFile(path)
 	if err != nil {
 		return err
 	}
 
+	var history v1.History
+	for _, opt := range opts {
+		opt(&history)
+	}
+
 	i.layersMap["sha256:"+sha] = path
 	i.layers = append(i.layers, path)
+	i.diffIDs = append(i.diffIDs, "sha256:"+sha)
+	i.layerHistory = append(i.layerHistory, history)
+	return nil
+}
+
+func (i *Image) AddLayerFromReader(r io.Reader) error {
+	f, err := ioutil.TempFile("", "fake-image-layer")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = i.AddLayer(f.Name())
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "writing layer")
+	}
+
+	i.tempLayers = append(i.tempLayers, f.Name())
+	return nil
+}
+
+func (i *Image) AddLayerFromImage(src imgutil.Image, diffID string) error {
+	rc, err := src.GetLayer(diffID)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return i.AddLayerFromReader(rc)
+}
+
+func (i *Image) Squash() error {
+	i.squashed = true
+	return nil
+}
+
+func (i *Image) NormalizeHistory() error {
 	return nil
 }
 
 func (i *Image) AddLayerWithDiffID(path string, diffID string) error {
 	i.layersMap[diffID] = path
 	i.layers = append(i.layers, path)
+	i.diffIDs = append(i.diffIDs, imgutil.NormalizeDiffID(diffID))
+	i.layerHistory = append(i.layerHistory, v1.History{})
 	return nil
 }
 
@@ -201,16 +543,33 @@ func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
 	return os.Open(path)
 }
 
+func (i *Image) GetCompressedLayer(sha string) (io.ReadCloser, error) {
+	rc, err := i.GetLayer(sha)
+	if err != nil {
+		return nil, err
+	}
+	return v1util.GzipReadCloser(rc), nil
+}
+
 func (i *Image) ReuseLayer(sha string) error {
 	prevLayer, ok := i.prevLayersMap[sha]
 	if !ok {
-		return fmt.Errorf("image does not have previous layer with sha '%s'", sha)
+		return errors.Wrapf(imgutil.ErrLayerNotFound, "image does not have previous layer with sha '%s'", sha)
 	}
 	i.reusedLayers = append(i.reusedLayers, sha)
 	i.layersMap[sha] = prevLayer
+	i.diffIDs = append(i.diffIDs, imgutil.NormalizeDiffID(sha))
+	i.layerHistory = append(i.layerHistory, v1.History{})
 	return nil
 }
 
+func (i *Image) ReuseLayerOrAdd(sha, path string) error {
+	if err := i.ReuseLayer(sha); !errors.Is(err, imgutil.ErrLayerNotFound) {
+		return err
+	}
+	return i.AddLayer(path)
+}
+
 func (i *Image) Save(additionalNames ...string) error {
 	var err error
 	i.layerDir, err = ioutil.TempDir("", "fake-image")
@@ -283,7 +642,14 @@ func (i *Image) SetIdentifier(identifier imgutil.Identifier) {
 	i.identifier = identifier
 }
 
+// Cleanup removes the layers copied on Save and the temp files written by AddLayerFromReader.
 func (i *Image) Cleanup() error {
+	for _, path := range i.tempLayers {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	i.tempLayers = nil
 	return os.RemoveAll(i.layerDir)
 }
 
@@ -307,8 +673,10 @@ func (i *Image) ReusedLayers() []string {
 	return i.reusedLayers
 }
 
-func (i *Image) WorkingDir() string {
-	return i.workingDir
+// LayerHistory returns the history recorded for each layer in the order of LayerDiffIDs;
+// layers added without options or reused have an empty entry
+func (i *Image) LayerHistory() []v1.History {
+	return append([]v1.History(nil), i.layerHistory...)
 }
 
 func (i *Image) AddPreviousLayer(sha, path string) {
@@ -395,6 +763,10 @@ func (i *Image) NumberOfAddedLayers() int {
 	return len(i.layers)
 }
 
+func (i *Image) IsSquashed() bool {
+	return i.squashed
+}
+
 func (i *Image) IsSaved() bool {
 	return len(i.savedNames) > 0
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -80,6 +81,351 @@ func testFake(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#LayerDiffIDs", func() {
+		it("returns the added and reused layers in order", func() {
+			image := fakes.NewImage(newRepoName(), "", nil)
+			image.AddPreviousLayer("previous", "/previous.tar")
+
+			layerPath, err := h.CreateSingleFileLayerTar("/file.txt", "contents", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+
+			h.AssertNil(t, image.ReuseLayer("previous"))
+			h.AssertNil(t, image.AddLayer(layerPath))
+
+			diffIDs, err := image.LayerDiffIDs()
+			h.AssertNil(t, err)
+			h.AssertEq(t, diffIDs, []string{"sha256:previous", h.FileDiffID(t, layerPath)})
+
+			count, err := image.NumberOfLayers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, count, 2)
+		})
+	})
+
+	when("#Registry, #Repository and #Tag", func() {
+		it("returns the components of the image name", func() {
+			image := fakes.NewImage("gcr.io/some-project/some-image:v1", "", nil)
+
+			h.AssertEq(t, image.Registry(), "gcr.io")
+			h.AssertEq(t, image.Repository(), "some-project/some-image")
+			tag, err := image.Tag()
+			h.AssertNil(t, err)
+			h.AssertEq(t, tag, "v1")
+		})
+
+		it("applies defaults for omitted components", func() {
+			image := fakes.NewImage("busybox", "", nil)
+
+			h.AssertEq(t, image.Registry(), "index.docker.io")
+			h.AssertEq(t, image.Repository(), "library/busybox")
+			tag, err := image.Tag()
+			h.AssertNil(t, err)
+			h.AssertEq(t, tag, "latest")
+		})
+
+		it("returns the digest of a digest reference", func() {
+			digest := "sha256:50edf1d080946c6a76989d1c3b0e753b62f7d9b5f5e66e88bef23ebbd1e9709c"
+			image := fakes.NewImage("gcr.io/some-project/some-image@"+digest, "", nil)
+
+			tag, err := image.Tag()
+			h.AssertNil(t, err)
+			h.AssertEq(t, tag, digest)
+		})
+
+		when("the image name is not valid", func() {
+			it("returns empty components and an error for the tag", func() {
+				image := fakes.NewImage("some-image:🧨", "", nil)
+
+				h.AssertEq(t, image.Registry(), "")
+				h.AssertEq(t, image.Repository(), "")
+				_, err := image.Tag()
+				h.AssertError(t, err, "failed to parse reference for image 'some-image:🧨'")
+			})
+		})
+	})
+
+	when("#RebasePlan", func() {
+		it("returns the layers that would be kept, removed and added", func() {
+			var diffIDs, paths []string
+			defer func() {
+				for _, path := range paths {
+					os.Remove(path)
+				}
+			}()
+			newLayer := func(contents string) string {
+				path, err := h.CreateSingleFileLayerTar("/file.txt", contents, "linux")
+				h.AssertNil(t, err)
+				paths = append(paths, path)
+				diffIDs = append(diffIDs, h.FileDiffID(t, path))
+				return path
+			}
+
+			image := fakes.NewImage(newRepoName(), "", nil)
+			h.AssertNil(t, image.AddLayer(newLayer("old-base")))
+			h.AssertNil(t, image.AddLayer(newLayer("app")))
+			newBase := fakes.NewImage(newRepoName(), "", nil)
+			h.AssertNil(t, newBase.AddLayer(newLayer("new-base")))
+
+			plan, err := image.RebasePlan(diffIDs[0], newBase)
+			h.AssertNil(t, err)
+			h.AssertEq(t, plan.RemovedLayers, diffIDs[:1])
+			h.AssertEq(t, plan.KeptLayers, diffIDs[1:2])
+			h.AssertEq(t, plan.NewBaseLayers, diffIDs[2:])
+		})
+
+		when("the base top layer appears more than once", func() {
+			var (
+				emptyLayerPath, emptyDiffID string
+				baseLayerPath, baseDiffID   string
+			)
+
+			it.Before(func() {
+				var err error
+				emptyLayerPath, err = h.CreateSingleFileLayerTar("/empty.txt", "", "linux")
+				h.AssertNil(t, err)
+				emptyDiffID = h.FileDiffID(t, emptyLayerPath)
+				baseLayerPath, err = h.CreateSingleFileLayerTar("/base.txt", "base", "linux")
+				h.AssertNil(t, err)
+				baseDiffID = h.FileDiffID(t, baseLayerPath)
+			})
+
+			it.After(func() {
+				os.Remove(emptyLayerPath)
+				os.Remove(baseLayerPath)
+			})
+
+			it("replaces the layers up to the first occurrence", func() {
+				image := fakes.NewImage("some-image", "", nil)
+				h.AssertNil(t, image.AddLayer(baseLayerPath))
+				h.AssertNil(t, image.AddLayer(emptyLayerPath))
+				h.AssertNil(t, image.AddLayer(emptyLayerPath))
+
+				plan, err := image.RebasePlan(emptyDiffID, fakes.NewImage("new-base", "", nil))
+				h.AssertNil(t, err)
+				h.AssertEq(t, plan.RemovedLayers, []string{baseDiffID, emptyDiffID})
+				h.AssertEq(t, plan.KeptLayers, []string{emptyDiffID})
+			})
+
+			it("replaces the layers of the old base when it is given", func() {
+				oldBase := fakes.NewImage("old-base", "", nil)
+				h.AssertNil(t, oldBase.AddLayer(baseLayerPath))
+				h.AssertNil(t, oldBase.AddLayer(emptyLayerPath))
+				h.AssertNil(t, oldBase.AddLayer(emptyLayerPath))
+
+				image := fakes.NewImage("some-image", "", nil)
+				h.AssertNil(t, image.AddLayer(baseLayerPath))
+				h.AssertNil(t, image.AddLayer(emptyLayerPath))
+				h.AssertNil(t, image.AddLayer(emptyLayerPath))
+				h.AssertNil(t, image.AddLayer(emptyLayerPath))
+
+				plan, err := image.RebasePlan(emptyDiffID, fakes.NewImage("new-base", "", nil), imgutil.WithOldBase(oldBase))
+				h.AssertNil(t, err)
+				h.AssertEq(t, plan.RemovedLayers, []string{baseDiffID, emptyDiffID, emptyDiffID})
+				h.AssertEq(t, plan.KeptLayers, []string{emptyDiffID})
+			})
+		})
+
+		when("the image does not start with the layers of the old base", func() {
+			it("returns an error", func() {
+				oldBaseLayerPath, err := h.CreateSingleFileLayerTar("/old-base.txt", "old-base", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(oldBaseLayerPath)
+				oldBaseDiffID := h.FileDiffID(t, oldBaseLayerPath)
+				otherLayerPath, err := h.CreateSingleFileLayerTar("/other.txt", "other", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(otherLayerPath)
+				otherDiffID := h.FileDiffID(t, otherLayerPath)
+
+				oldBase := fakes.NewImage("old-base", "", nil)
+				h.AssertNil(t, oldBase.AddLayer(oldBaseLayerPath))
+				image := fakes.NewImage("some-image", "", nil)
+				h.AssertNil(t, image.AddLayer(otherLayerPath))
+				h.AssertNil(t, image.AddLayer(oldBaseLayerPath))
+
+				_, err = image.RebasePlan(oldBaseDiffID, fakes.NewImage("new-base", "", nil), imgutil.WithOldBase(oldBase))
+				h.AssertError(t, err, fmt.Sprintf("'some-image' does not start with the layers of old base 'old-base': layer 0 is '%s', not '%s'", otherDiffID, oldBaseDiffID))
+			})
+		})
+
+		when("the base top layer is not the top layer of the old base", func() {
+			it("returns an error", func() {
+				image := fakes.NewImage("some-image", "", nil)
+
+				_, err := image.RebasePlan("sha256:other", fakes.NewImage("new-base", "", nil), imgutil.WithOldBase(fakes.NewImage("old-base", "", nil)))
+				h.AssertError(t, err, "'sha256:other' is not the top layer of old base 'old-base'")
+			})
+		})
+
+		when("the base top layer is not in the image", func() {
+			it("returns an error", func() {
+				image := fakes.NewImage("some-image", "", nil)
+
+				_, err := image.RebasePlan("sha256:missing", fakes.NewImage("new-base", "", nil))
+				h.AssertError(t, err, "'sha256:missing' not found in 'some-image' during rebase")
+			})
+		})
+	})
+
+	when("#ReuseLayerOrAdd", func() {
+		it("reuses a previous layer or adds the layer when there is none", func() {
+			image := fakes.NewImage(newRepoName(), "", nil)
+			image.AddPreviousLayer("sha256:previous", "/previous.tar")
+
+			layerPath, layerDiffID := h.SingleFileLayerTar(t, "/file.txt", "contents", "linux")
+			defer os.Remove(layerPath)
+
+			h.AssertNil(t, image.ReuseLayerOrAdd("sha256:previous", "/does-not-exist.tar"))
+			h.AssertNil(t, image.ReuseLayerOrAdd(layerDiffID, layerPath))
+
+			h.AssertEq(t, image.ReusedLayers(), []string{"sha256:previous"})
+			rc, err := image.GetLayer(layerDiffID)
+			h.AssertNil(t, err)
+			h.AssertNil(t, rc.Close())
+		})
+	})
+
+	when("#AddLayer", func() {
+		it("records the history given by the layer options", func() {
+			image := fakes.NewImage(newRepoName(), "", nil)
+			layerPath, _ := h.SingleFileLayerTar(t, "/file.txt", "contents", "linux")
+			defer os.Remove(layerPath)
+
+			createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			h.AssertNil(t, image.AddLayer(layerPath, imgutil.WithCreatedBy("some-created-by"), imgutil.WithLayerCreatedAt(createdAt)))
+
+			history := image.LayerHistory()
+			h.AssertEq(t, len(history), 1)
+			h.AssertEq(t, history[0].CreatedBy, "some-created-by")
+			h.AssertEq(t, history[0].Created.Time.Equal(createdAt), true)
+		})
+
+		it("records the history of each layer when the same path is added twice", func() {
+			image := fakes.NewImage(newRepoName(), "", nil)
+			layerPath, _ := h.SingleFileLayerTar(t, "/file.txt", "contents", "linux")
+			defer os.Remove(layerPath)
+
+			h.AssertNil(t, image.AddLayer(layerPath, imgutil.WithCreatedBy("first")))
+			h.AssertNil(t, image.AddLayer(layerPath, imgutil.WithCreatedBy("second")))
+
+			history := image.LayerHistory()
+			h.AssertEq(t, len(history), 2)
+			h.AssertEq(t, history[0].CreatedBy, "first")
+			h.AssertEq(t, history[1].CreatedBy, "second")
+		})
+	})
+
+	when("#AddLayerFromImage", func() {
+		it("adds the layer from the source image", func() {
+			src := fakes.NewImage("some-image", "", nil)
+			layerPath, layerDiffID := h.SingleFileLayerTar(t, "/file.txt", "contents", "linux")
+			defer os.Remove(layerPath)
+			h.AssertNil(t, src.AddLayer(layerPath))
+
+			image := fakes.NewImage(newRepoName(), "", nil)
+			h.AssertNil(t, image.AddLayerFromImage(src, layerDiffID))
+
+			rc, err := image.GetLayer(layerDiffID)
+			h.AssertNil(t, err)
+			h.AssertNil(t, rc.Close())
+		})
+	})
+
+	when("#SetLabelsFromFile", func() {
+		var labelsPath string
+
+		it.Before(func() {
+			labelsFile, err := ioutil.TempFile("", "labels")
+			h.AssertNil(t, err)
+			h.AssertNil(t, labelsFile.Close())
+			labelsPath = labelsFile.Name()
+		})
+
+		it.After(func() {
+			os.Remove(labelsPath)
+		})
+
+		it("sets the labels of a KEY=VALUE file, ignoring comments and blank lines", func() {
+			h.AssertNil(t, ioutil.WriteFile(labelsPath, []byte("# some comment\n\nsome-key=some-val\n  other-key=other=val  \n"), 0600))
+
+			image := fakes.NewImage("some-image", "", nil)
+			h.AssertNil(t, image.SetLabelsFromFile(labelsPath))
+
+			labels, err := image.Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, labels, map[string]string{"some-key": "some-val", "other-key": "other=val"})
+		})
+
+		it("trims the whitespace around keys and values", func() {
+			h.AssertNil(t, ioutil.WriteFile(labelsPath, []byte("some-key = some-val\nother-key\t=\tother val \n"), 0600))
+
+			image := fakes.NewImage("some-image", "", nil)
+			h.AssertNil(t, image.SetLabelsFromFile(labelsPath))
+
+			labels, err := image.Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, labels, map[string]string{"some-key": "some-val", "other-key": "other val"})
+		})
+
+		it("sets the labels of a JSON file", func() {
+			h.AssertNil(t, ioutil.WriteFile(labelsPath, []byte(`{"some-key": "some-val", "other-key": "other-val"}`), 0600))
+
+			image := fakes.NewImage("some-image", "", nil)
+			h.AssertNil(t, image.SetLabelsFromFile(labelsPath))
+
+			labels, err := image.Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, labels, map[string]string{"some-key": "some-val", "other-key": "other-val"})
+		})
+
+		it("returns an error with the line number of a malformed line", func() {
+			h.AssertNil(t, ioutil.WriteFile(labelsPath, []byte("# some comment\nsome-key=some-val\nmalformed\n"), 0600))
+
+			image := fakes.NewImage("some-image", "", nil)
+			err := image.SetLabelsFromFile(labelsPath)
+			h.AssertError(t, err, fmt.Sprintf("failed to parse labels file '%s': line 3: expected KEY=VALUE, got 'malformed'", labelsPath))
+
+			labels, err := image.Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(labels), 0)
+		})
+	})
+
+	when("#ConfigFile", func() {
+		it("returns the config of the image", func() {
+			image := fakes.NewImage("some-image", "", nil)
+			h.AssertNil(t, image.SetLabel("some-label", "some-val"))
+			h.AssertNil(t, image.SetEnv("SOME_ENV", "some-val"))
+			h.AssertNil(t, image.SetOS("linux"))
+
+			configFile, err := image.ConfigFile()
+			h.AssertNil(t, err)
+			h.AssertEq(t, configFile.OS, "linux")
+			h.AssertEq(t, configFile.Config.Labels, map[string]string{"some-label": "some-val"})
+			h.AssertEq(t, configFile.Config.Env, []string{"SOME_ENV=some-val"})
+		})
+	})
+
+	when("#Clone", func() {
+		it("does not share labels or env with the original", func() {
+			image := fakes.NewImage("some-image", "", nil)
+			h.AssertNil(t, image.SetLabel("some-label", "original"))
+
+			clone, err := image.Clone("some-clone")
+			h.AssertNil(t, err)
+			h.AssertEq(t, clone.Name(), "some-clone")
+			h.AssertNil(t, clone.SetLabel("some-label", "clone"))
+			h.AssertNil(t, clone.SetEnv("SOME_ENV", "clone"))
+
+			label, err := image.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "original")
+			env, err := image.Env("SOME_ENV")
+			h.AssertNil(t, err)
+			h.AssertEq(t, env, "")
+		})
+	})
+
 	when("#FindLayerWithPath", func() {
 		var (
 			image      *fakes.Image
@@ -136,6 +482,21 @@ Layers
 			})
 		})
 	})
+
+	when("#AddLayerFromReader", func() {
+		it("removes the layer file on Cleanup", func() {
+			image := fakes.NewImage(newRepoName(), "", nil)
+			h.AssertNil(t, image.AddLayerFromReader(strings.NewReader("some-layer")))
+
+			layerPath := image.AppLayerPath()
+			_, err := os.Stat(layerPath)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, image.Cleanup())
+			_, err = os.Stat(layerPath)
+			h.AssertEq(t, os.IsNotExist(err), true)
+		})
+	})
 }
 
 func createLayerTar(contents map[string]string) (string, error) {
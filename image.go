@@ -1,14 +1,20 @@
 package imgutil
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
 var NormalizedDateTime = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
 
+// SquashedCreatedBy is the created_by of the single history entry of an image after Squash.
+const SquashedCreatedBy = "imgutil squash"
+
 type SaveDiagnostic struct {
 	ImageName string
 	Cause     error
@@ -26,33 +32,176 @@ func (e SaveError) Error() string {
 	return fmt.Sprintf("failed to write image to the following tags: %s", strings.Join(errors, ","))
 }
 
+// ErrImageNotFound matches any NotFoundError using errors.Is.
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrLayerNotFound is the cause of the error returned by ReuseLayer when the previous image does not have the layer.
+var ErrLayerNotFound = errors.New("layer not found in previous image")
+
+// NormalizeDiffID prefixes a bare hex digest with "sha256:", the form in which image configs and the daemon list
+// diff IDs, so that layers can be looked up by either form.
+func NormalizeDiffID(diffID string) string {
+	if strings.Contains(diffID, ":") {
+		return diffID
+	}
+	return "sha256:" + diffID
+}
+
+// NotFoundError is returned when an image, or the config of an image, does not exist.
+type NotFoundError struct {
+	Message string
+}
+
+func (e NotFoundError) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is ErrImageNotFound, so that callers can check errors.Is(err, imgutil.ErrImageNotFound).
+func (e NotFoundError) Is(target error) bool {
+	return target == ErrImageNotFound
+}
+
+// HealthConfig describes the healthcheck run against containers created from an image.
+type HealthConfig struct {
+	// Test is the command to run, e.g. {"CMD", "curl", "localhost"}, or {"NONE"} to disable a healthcheck.
+	Test        []string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+}
+
+// LayerOption sets the history recorded for a layer added with AddLayer.
+type LayerOption func(*v1.History)
+
+// WithCreatedBy records createdBy, such as the Dockerfile instruction that produced the layer, in the history of
+// the layer.
+func WithCreatedBy(createdBy string) LayerOption {
+	return func(h *v1.History) {
+		h.CreatedBy = createdBy
+	}
+}
+
+// WithLayerCreatedAt records t in the history of the layer as the time it was created, instead of the creation
+// time of the image.
+func WithLayerCreatedAt(t time.Time) LayerOption {
+	return func(h *v1.History) {
+		h.Created = v1.Time{Time: t}
+	}
+}
+
 type Image interface {
 	Name() string
+	// Registry returns the registry of `Name()`, or an empty string if it is not a valid image name.
+	Registry() string
+	// Repository returns the repository of `Name()` without the registry, or an empty string if it is not a
+	// valid image name.
+	Repository() string
+	// Tag returns the tag of `Name()`, or its digest when it is a digest reference.
+	Tag() (string, error)
 	Rename(name string)
+	// Clone returns an independent copy of the image named newName, so that the copy and the original can be
+	// modified and saved separately.
+	Clone(newName string) (Image, error)
 	Label(string) (string, error)
 	Labels() (map[string]string, error)
 	SetLabel(string, string) error
+	SetLabels(map[string]string) error
+	// SetLabelsFromFile sets the labels read from the file at path, in either format ReadLabelsFile accepts.
+	SetLabelsFromFile(path string) error
+	// Annotations returns the annotations on the image manifest.
+	Annotations() (map[string]string, error)
+	// SetAnnotation sets an annotation on the image manifest.
+	SetAnnotation(string, string) error
 	RemoveLabel(string) error
 	Env(key string) (string, error)
+	// EnvVars returns the environment variables of the image as "KEY=VALUE" entries, in the order they are set.
+	EnvVars() ([]string, error)
+	// EnvMap returns the environment variables of the image keyed by name.
+	EnvMap() (map[string]string, error)
 	SetEnv(string, string) error
+	// RemoveEnv removes the environment variable key, if it is set.
+	RemoveEnv(key string) error
 	SetEntrypoint(...string) error
+	// ClearEntrypoint removes the entrypoint. Saved configs omit both a nil and an empty entrypoint, so calling
+	// SetEntrypoint with no arguments has the same effect; ClearEntrypoint makes the intent explicit.
+	ClearEntrypoint() error
 	SetWorkingDir(string) error
+	WorkingDir() (string, error)
+	// StopSignal returns the signal sent to stop containers created from the image, or "" when unset.
+	StopSignal() (string, error)
+	// SetStopSignal sets the signal sent to stop containers, e.g. "SIGTERM", or unsets it when passed "".
+	SetStopSignal(string) error
+	// OnBuild returns the ONBUILD triggers that run when the image is used as the base of a Dockerfile build.
+	OnBuild() ([]string, error)
+	// SetOnBuild replaces the ONBUILD triggers, or removes them when passed none.
+	SetOnBuild(...string) error
+	// Shell returns the shell used for the shell form of RUN, CMD and ENTRYPOINT, or nil when unset.
+	Shell() ([]string, error)
+	// SetShell sets the shell, e.g. "/bin/sh", "-c", or unsets it when passed none.
+	SetShell(...string) error
+	SetUser(string) error
+	User() (string, error)
+	// ExposedPorts returns the exposed ports of the image, keyed by "port/proto".
+	ExposedPorts() (map[string]struct{}, error)
+	SetExposedPort(port string) error
+	RemoveExposedPort(port string) error
+	Volumes() (map[string]struct{}, error)
+	SetVolume(path string) error
+	RemoveVolume(path string) error
+	// Healthcheck returns nil when the image defines no healthcheck.
+	Healthcheck() (*HealthConfig, error)
+	// SetHealthcheck replaces the healthcheck, or removes it when passed nil.
+	SetHealthcheck(*HealthConfig) error
 	SetCmd(...string) error
+	// ClearCmd removes the cmd, with the same semantics as ClearEntrypoint.
+	ClearCmd() error
 	SetOS(string) error
 	SetOSVersion(string) error
 	SetArchitecture(string) error
-	Rebase(string, Image) error
-	AddLayer(path string) error
+	Rebase(string, Image, ...RebaseOption) error
+	// RebasePlan returns the layers that Rebase would keep, remove and add, without modifying the image.
+	RebasePlan(baseTopLayer string, newBase Image, opts ...RebaseOption) (*RebaseResult, error)
+	// AddLayer adds the layer tar at path, recording the history set by opts for it.
+	AddLayer(path string, opts ...LayerOption) error
+	// AddLayerFromReader adds a layer from the uncompressed tar contents of r.
+	AddLayerFromReader(r io.Reader) error
 	AddLayerWithDiffID(path, diffID string) error
 	ReuseLayer(diffID string) error
+	// ReuseLayerOrAdd reuses the layer with diffID from the previous image, or adds the layer tar at path when the
+	// previous image does not have it.
+	ReuseLayerOrAdd(diffID, path string) error
+	// AddLayerFromImage adds the layer with diffID from src, which may be an image from any backend.
+	AddLayerFromImage(src Image, diffID string) error
 	// TopLayer returns the diff id for the top layer
 	TopLayer() (string, error)
+	// NumberOfLayers returns the number of layers in the image, without reading their contents.
+	NumberOfLayers() (int, error)
+	// LayerDiffIDs returns the diff id of every layer, from the bottom layer to the top layer.
+	LayerDiffIDs() ([]string, error)
+	// Squash replaces the image's layers with a single layer holding the filesystem they produce together, and the
+	// image's history with a single entry created by SquashedCreatedBy.
+	Squash() error
+	// NormalizeHistory pads or trims the image's history to one entry per layer, as registries require.
+	NormalizeHistory() error
+	// Size returns the total size in bytes of the image's layers and config.
+	Size() (int64, error)
+	// RawConfig returns the config JSON the image would be saved with, including fields imgutil does not model.
+	RawConfig() ([]byte, error)
+	// ConfigFile returns the config the image would be saved with, as the same typed struct for every backend.
+	// Changes to the returned config do not affect the image.
+	ConfigFile() (*v1.ConfigFile, error)
 	// Save saves the image as `Name()` and any additional names provided to this method.
 	Save(additionalNames ...string) error
 	// Found tells whether the image exists in the repository by `Name()`.
 	Found() bool
-	// GetLayer retrieves layer by diff id. Returns a reader of the uncompressed contents of the layer.
+	// GetLayer retrieves layer by diff id. Returns a reader of the uncompressed tar contents of the layer, whichever
+	// backend holds it, so the contents read from different backends can be compared directly.
 	GetLayer(diffID string) (io.ReadCloser, error)
+	// GetCompressedLayer retrieves layer by diff id. Returns a reader of the gzip compressed contents of the layer.
+	// Backends that only keep uncompressed layers compress them while they are read, so the result is not
+	// guaranteed to match the blob a registry holds for the layer.
+	GetCompressedLayer(diffID string) (io.ReadCloser, error)
 	Delete() error
 	CreatedAt() (time.Time, error)
 	Identifier() (Identifier, error)
@@ -62,3 +211,17 @@ type Image interface {
 }
 
 type Identifier fmt.Stringer
+
+// NormalizedHistory returns the entries at the top of history that describe the top numLayers layers, keeping the
+// entries of instructions that did not create a layer, with an empty entry for each bottom layer history has none for.
+func NormalizedHistory(history []v1.History, numLayers int) []v1.History {
+	start, layers := len(history), 0
+	for start > 0 && layers < numLayers {
+		start--
+		if !history[start].EmptyLayer {
+			layers++
+		}
+	}
+	normalized := make([]v1.History, numLayers-layers, numLayers-layers+len(history)-start)
+	return append(normalized, history[start:]...)
+}
@@ -0,0 +1,62 @@
+// Package daemon holds the helpers the local and remote packages share for exchanging image archives with the
+// docker daemon.
+package daemon
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+)
+
+// AddTextToTar writes contents to tw as a regular file at name, with a normalized modification time.
+func AddTextToTar(tw *tar.Writer, name string, contents []byte) error {
+	hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents)), ModTime: imgutil.NormalizedDateTime}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(contents)
+	return err
+}
+
+// CheckResponseError returns the first error reported in the stream of JSON messages the daemon responds to an
+// image load with, displaying the messages on out if it is not nil.
+func CheckResponseError(r io.Reader, out io.Writer) error {
+	decoder := json.NewDecoder(r)
+	for first := true; ; first = false {
+		var jsonMessage jsonmessage.JSONMessage
+		if err := decoder.Decode(&jsonMessage); err != nil {
+			if err == io.EOF && !first {
+				return nil
+			}
+			return errors.Wrapf(err, "parsing daemon response")
+		}
+
+		if jsonMessage.Error != nil {
+			return errors.Wrap(jsonMessage.Error, "embedded daemon response")
+		}
+		// older daemons only set the deprecated error field, without the error detail
+		if jsonMessage.ErrorMessage != "" {
+			return errors.Wrap(errors.New(jsonMessage.ErrorMessage), "embedded daemon response")
+		}
+		if out != nil {
+			if err := jsonMessage.Display(out, false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// EnsureReaderClosed drains and closes r, returning the first error.
+func EnsureReaderClosed(r io.ReadCloser) error {
+	_, err := io.Copy(ioutil.Discard, r)
+	if closeErr := r.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
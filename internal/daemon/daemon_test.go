@@ -0,0 +1,54 @@
+package daemon_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/internal/daemon"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestDaemon(t *testing.T) {
+	spec.Run(t, "daemon", testDaemon, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testDaemon(t *testing.T, when spec.G, it spec.S) {
+	when("#CheckResponseError", func() {
+		it("accepts responses that report loaded images", func() {
+			err := daemon.CheckResponseError(strings.NewReader(`{"stream":"Loaded image: some-image:latest\n"}`+"\n"), nil)
+			h.AssertNil(t, err)
+		})
+
+		it("returns errors reported after other messages", func() {
+			err := daemon.CheckResponseError(strings.NewReader(
+				`{"stream":"Loaded image ID: sha256:some-id\n"}`+"\n"+
+					`{"errorDetail":{"message":"some daemon error"},"error":"some daemon error"}`+"\n",
+			), nil)
+			h.AssertError(t, err, "some daemon error")
+		})
+
+		it("returns errors reported without an error detail", func() {
+			err := daemon.CheckResponseError(strings.NewReader(`{"error":"some daemon error"}`+"\n"), nil)
+			h.AssertError(t, err, "embedded daemon response: some daemon error")
+		})
+
+		it("returns an error for an empty response", func() {
+			err := daemon.CheckResponseError(strings.NewReader(""), nil)
+			h.AssertError(t, err, "parsing daemon response")
+		})
+
+		it("displays the messages on out", func() {
+			out := &bytes.Buffer{}
+			err := daemon.CheckResponseError(strings.NewReader(
+				`{"status":"Loading layer","progressDetail":{"current":1,"total":2},"progress":"[=>  ]","id":"some-layer"}`+"\n"+
+					`{"stream":"Loaded image: some-image:latest\n"}`+"\n",
+			), out)
+			h.AssertNil(t, err)
+			h.AssertEq(t, out.String(), "Loaded image: some-image:latest\n")
+		})
+	})
+}
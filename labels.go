@@ -0,0 +1,45 @@
+package imgutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ReadLabelsFile reads labels from the file at path, which holds either a JSON object of label keys to values, or
+// one KEY=VALUE pair per line. In the line format, blank lines and lines starting with '#' are ignored, and values
+// may contain '='.
+func ReadLabelsFile(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read labels file '%s': %s", path, err)
+	}
+
+	labels := map[string]string{}
+	if bytes.HasPrefix(bytes.TrimSpace(contents), []byte("{")) {
+		if err := json.Unmarshal(contents, &labels); err != nil {
+			return nil, fmt.Errorf("failed to parse labels file '%s': %s", path, err)
+		}
+		return labels, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("failed to parse labels file '%s': line %d: expected KEY=VALUE, got '%s'", path, lineNum, line)
+		}
+		labels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read labels file '%s': %s", path, err)
+	}
+	return labels, nil
+}
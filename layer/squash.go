@@ -0,0 +1,110 @@
+package layer
+
+import (
+	"archive/tar"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// Squash writes a single layer tar to w containing the filesystem produced by stacking layers, bottom layer first.
+// Whiteouts and opaque whiteouts are applied to the layers below them and are not written to w. Hardlinks are written
+// after all other entries, bottom layer first, so that they follow the files they link to in lower layers. Hardlinks
+// whose target is deleted or replaced by a layer above them are dropped, since they would dangle or link to the new
+// contents.
+func Squash(w io.Writer, layers ...io.Reader) error {
+	tw := tar.NewWriter(w)
+
+	// paths already written or deleted by a higher layer; true when the path also hides anything beneath it
+	seen := map[string]bool{}
+	// index of the highest layer that wrote or deleted each path in seen
+	owners := map[string]int{}
+	// directories made opaque by a higher layer, mapped to the index of that layer
+	opaqueDirs := map[string]int{}
+	// hardlinks of each layer, in the order they appear in it
+	links := make([][]*tar.Header, len(layers))
+
+	for idx := len(layers) - 1; idx >= 0; idx-- {
+		tr := tar.NewReader(layers[idx])
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			name := path.Clean("/" + header.Name)
+			dir, base := path.Split(name)
+			dir = path.Clean(dir)
+
+			if base == opaqueWhiteout {
+				if _, ok := opaqueDirs[dir]; !ok {
+					opaqueDirs[dir] = idx
+				}
+				continue
+			}
+
+			whiteout := strings.HasPrefix(base, whiteoutPrefix)
+			if whiteout {
+				name = path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			}
+
+			if _, ok := seen[name]; ok || hiddenByParent(seen, opaqueDirs, name, idx) {
+				continue
+			}
+			seen[name] = whiteout || header.Typeflag != tar.TypeDir
+			owners[name] = idx
+			if whiteout {
+				continue
+			}
+			if header.Typeflag == tar.TypeLink {
+				target := path.Clean("/" + header.Linkname)
+				if owner, ok := owners[target]; ok && owner > idx || hiddenByParent(seen, opaqueDirs, target, idx) {
+					continue
+				}
+				links[idx] = append(links[idx], header)
+				continue
+			}
+
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, layerLinks := range links {
+		for _, header := range layerLinks {
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+// hiddenByParent reports whether a parent of name was deleted, replaced by a non-directory, or made opaque
+// by a layer above layerIdx
+func hiddenByParent(seen map[string]bool, opaqueDirs map[string]int, name string, layerIdx int) bool {
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if seen[dir] {
+			return true
+		}
+		if opaqueIdx, ok := opaqueDirs[dir]; ok && opaqueIdx > layerIdx {
+			return true
+		}
+		if dir == "/" {
+			return false
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package layer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/buildpacks/imgutil/layer"
+	h "github.com/buildpacks/imgutil/testhelpers"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestSquash(t *testing.T) {
+	spec.Run(t, "squash", testSquash, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testSquash(t *testing.T, when spec.G, it spec.S) {
+	when("#Squash", func() {
+		it("removes files deleted by a later layer", func() {
+			contents := squash(t,
+				layerTar(t, entry{name: "dir/", dir: true}, entry{name: "dir/file.txt", contents: "file"}, entry{name: "keep.txt", contents: "keep"}),
+				layerTar(t, entry{name: "dir/.wh.file.txt"}),
+			)
+
+			h.AssertEq(t, contents, map[string]string{"/dir": "", "/keep.txt": "keep"})
+		})
+
+		it("keeps the contents of the highest layer for overridden files", func() {
+			contents := squash(t,
+				layerTar(t, entry{name: "file.txt", contents: "old"}),
+				layerTar(t, entry{name: "file.txt", contents: "new"}),
+			)
+
+			h.AssertEq(t, contents, map[string]string{"/file.txt": "new"})
+		})
+
+		it("hides lower contents of opaque directories", func() {
+			contents := squash(t,
+				layerTar(t, entry{name: "dir/", dir: true}, entry{name: "dir/old.txt", contents: "old"}),
+				layerTar(t, entry{name: "dir/", dir: true}, entry{name: "dir/.wh..wh..opq"}, entry{name: "dir/new.txt", contents: "new"}),
+			)
+
+			h.AssertEq(t, contents, map[string]string{"/dir": "", "/dir/new.txt": "new"})
+		})
+
+		it("hides lower contents of directories replaced by files", func() {
+			contents := squash(t,
+				layerTar(t, entry{name: "path/", dir: true}, entry{name: "path/file.txt", contents: "old"}),
+				layerTar(t, entry{name: "path", contents: "now-a-file"}),
+			)
+
+			h.AssertEq(t, contents, map[string]string{"/path": "now-a-file"})
+		})
+
+		it("drops hardlinks whose target is deleted by a later layer", func() {
+			contents := squash(t,
+				layerTar(t, entry{name: "target.txt", contents: "target"}, entry{name: "link.txt", link: "target.txt"}),
+				layerTar(t, entry{name: ".wh.target.txt"}),
+			)
+
+			h.AssertEq(t, contents, map[string]string{})
+		})
+
+		it("drops hardlinks whose target is replaced by a later layer", func() {
+			contents := squash(t,
+				layerTar(t, entry{name: "target.txt", contents: "old"}, entry{name: "link.txt", link: "target.txt"}),
+				layerTar(t, entry{name: "target.txt", contents: "new"}),
+			)
+
+			h.AssertEq(t, contents, map[string]string{"/target.txt": "new"})
+		})
+
+		it("writes hardlinks after the files of lower layers they link to", func() {
+			buf := &bytes.Buffer{}
+			h.AssertNil(t, layer.Squash(buf,
+				layerTar(t, entry{name: "target.txt", contents: "target"}),
+				layerTar(t, entry{name: "link.txt", link: "target.txt"}, entry{name: "other.txt", contents: "other"}),
+			))
+
+			var names []string
+			tr := tar.NewReader(buf)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				h.AssertNil(t, err)
+				names = append(names, hdr.Name)
+			}
+			h.AssertEq(t, names, []string{"other.txt", "target.txt", "link.txt"})
+		})
+	})
+}
+
+type entry struct {
+	name     string
+	contents string
+	dir      bool
+	link     string
+}
+
+func layerTar(t *testing.T, entries ...entry) io.Reader {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.contents)), Typeflag: tar.TypeReg}
+		if e.dir {
+			hdr.Mode = 0755
+			hdr.Typeflag = tar.TypeDir
+		}
+		if e.link != "" {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = e.link
+		}
+		h.AssertNil(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.contents))
+		h.AssertNil(t, err)
+	}
+	h.AssertNil(t, tw.Close())
+	return buf
+}
+
+func squash(t *testing.T, layers ...io.Reader) map[string]string {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	h.AssertNil(t, layer.Squash(buf, layers...))
+
+	contents := map[string]string{}
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		h.AssertNil(t, err)
+		b, err := ioutil.ReadAll(tr)
+		h.AssertNil(t, err)
+		contents["/"+strings.TrimRight(hdr.Name, "/")] = string(b)
+	}
+	return contents
+}
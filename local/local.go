@@ -2,6 +2,9 @@ package local
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -10,35 +13,69 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/v1util"
 	"github.com/pkg/errors"
 
 	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/internal/daemon"
+	"github.com/buildpacks/imgutil/layer"
 )
 
 type Image struct {
-	repoName      string
-	docker        client.CommonAPIClient
-	inspect       types.ImageInspect
-	layerPaths    []string
-	downloadOnce  *sync.Once
-	prevName      string
-	prevImage     *FileSystemLocalImage
-	easyAddLayers []string
+	repoName         string
+	docker           client.CommonAPIClient
+	inspect          types.ImageInspect
+	layerPaths       []string
+	history          []v1.History
+	downloads        map[string]*FileSystemLocalImage
+	baseImageName    string
+	prevName         string
+	easyAddLayers    []string
+	saveProgress     func(bytesWritten int64)
+	tempLayers       []string
+	createdAt        time.Time
+	strictValidation bool
+	closed           bool
+	validateDiffIDs  bool
+	stdout           io.Writer
+	// daemonLayers maps the diff IDs of layers without a layer path to the ID of an image in the daemon holding them
+	daemonLayers map[string]string
+	// savedConfig is the config of the image as it was last loaded from or saved to the daemon
+	savedConfig []byte
 }
 
+// FileSystemLocalImage is an image exported from the daemon whose layers are extracted to dir when first read
 type FileSystemLocalImage struct {
 	dir       string
+	imageName string
+	// imageID is the image exported from the daemon, resolved from imageName once so that every export reads the
+	// same image even if imageName is later tagged on another one
+	imageID   string
+	docker    client.CommonAPIClient
+	stdout    io.Writer
+	layers    []string
+	diffIDs   []string
 	layersMap map[string]string
 }
 
@@ -46,48 +83,89 @@ type ImageOption func(image *Image) (*Image, error)
 
 func WithPreviousImage(imageName string) ImageOption {
 	return func(i *Image) (*Image, error) {
-		if _, err := inspectOptionalImage(i.docker, imageName); err != nil {
-			return i, err
-		}
-
 		i.prevName = imageName
-
 		return i, nil
 	}
 }
 
 func FromBaseImage(imageName string) ImageOption {
 	return func(i *Image) (*Image, error) {
-		var (
-			err     error
-			inspect types.ImageInspect
-		)
+		i.baseImageName = imageName
+		return i, nil
+	}
+}
+
+// WithCreatedAt sets the creation time recorded in the image config on Save, instead of imgutil.NormalizedDateTime.
+// Pass the time from SOURCE_DATE_EPOCH to make builds reproducible against a source date.
+func WithCreatedAt(createdAt time.Time) ImageOption {
+	return func(i *Image) (*Image, error) {
+		i.createdAt = createdAt
+		return i, nil
+	}
+}
 
-		if inspect, err = inspectOptionalImage(i.docker, imageName); err != nil {
-			return i, err
+// WithStrictValidation requires image names to be fully specified, with no defaulting of registry, repository
+// or tag, and fails immediately if the image name is not.
+func WithStrictValidation() ImageOption {
+	return func(i *Image) (*Image, error) {
+		i.strictValidation = true
+		if _, err := name.NewTag(i.repoName, i.nameOptions()...); err != nil {
+			return i, errors.Wrapf(err, "invalid image name '%s'", i.repoName)
 		}
+		return i, nil
+	}
+}
 
-		i.inspect = inspect
-		i.layerPaths = make([]string, len(i.inspect.RootFS.Layers))
+// WithSaveProgress registers a callback invoked with the cumulative number of layer bytes written while saving.
+func WithSaveProgress(fn func(bytesWritten int64)) ImageOption {
+	return func(i *Image) (*Image, error) {
+		i.saveProgress = fn
+		return i, nil
+	}
+}
 
+// WithOperationTimeout fails each call to the daemon that takes longer than timeout, including loading the image on
+// Save, instead of waiting on an unresponsive daemon forever. Zero means no timeout, which is the default.
+func WithOperationTimeout(timeout time.Duration) ImageOption {
+	return func(i *Image) (*Image, error) {
+		if timeout < 0 {
+			return i, fmt.Errorf("operation timeout must not be negative, got %s", timeout)
+		}
+		if timeout > 0 {
+			i.docker = &timeoutClient{CommonAPIClient: i.docker, timeout: timeout}
+		}
 		return i, nil
 	}
 }
 
-func NewImage(repoName string, dockerClient client.CommonAPIClient, ops ...ImageOption) (imgutil.Image, error) {
-	var err error
+// WithStdout writes the status messages the daemon streams while Save loads the image to w, as `docker load` shows
+// them, along with a line for each image exported from the daemon to read its layers. By default nothing is written.
+func WithStdout(w io.Writer) ImageOption {
+	return func(i *Image) (*Image, error) {
+		i.stdout = w
+		return i, nil
+	}
+}
 
-	inspect, err := defaultInspect(dockerClient)
-	if err != nil {
-		return nil, err
+// WithDiffIDValidation makes AddLayerWithDiffID hash the layer and fail if it does not match the diff ID it was
+// given. It costs the read AddLayerWithDiffID otherwise avoids, so it is meant for debugging callers.
+func WithDiffIDValidation() ImageOption {
+	return func(i *Image) (*Image, error) {
+		i.validateDiffIDs = true
+		return i, nil
 	}
+}
+
+// NewImage returns an image named repoName that is read from and saved to the daemon dockerClient connects to.
+// Clients created by NewDockerClient avoid failures caused by the client using a newer API version than the daemon.
+func NewImage(repoName string, dockerClient client.CommonAPIClient, ops ...ImageOption) (imgutil.Image, error) {
+	var err error
 
 	image := &Image{
-		docker:       dockerClient,
-		repoName:     repoName,
-		inspect:      inspect,
-		layerPaths:   make([]string, len(inspect.RootFS.Layers)),
-		downloadOnce: &sync.Once{},
+		docker:    dockerClient,
+		repoName:  repoName,
+		downloads: map[string]*FileSystemLocalImage{},
+		createdAt: imgutil.NormalizedDateTime,
 	}
 
 	for _, v := range ops {
@@ -97,32 +175,208 @@ func NewImage(repoName string, dockerClient client.CommonAPIClient, ops ...Image
 		}
 	}
 
+	// the options only record the images to read, so that reading them honors options such as
+	// WithOperationTimeout whatever their order
+	var inspect types.ImageInspect
+	if image.baseImageName != "" {
+		inspect, err = inspectOptionalImage(image.docker, image.baseImageName)
+	} else {
+		inspect, err = defaultInspect(image.docker)
+	}
+	if err != nil {
+		return nil, err
+	}
+	image.inspect = inspect
+	image.layerPaths = make([]string, len(inspect.RootFS.Layers))
+	if image.history, err = imageHistory(image.docker, inspect); err != nil {
+		return nil, err
+	}
+	image.trackDaemonLayers(inspect)
+
+	if image.prevName != "" {
+		if _, err := inspectOptionalImage(image.docker, image.prevName); err != nil {
+			return nil, err
+		}
+	}
+	image.savedConfig, _ = image.newConfigFile()
+
 	return image, nil
 }
 
+// NewImageFromEnv is like NewImage, but connects to the daemon configured by the environment using NewDockerClient.
+func NewImageFromEnv(repoName string, ops ...ImageOption) (imgutil.Image, error) {
+	dockerClient, err := NewDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	return NewImage(repoName, dockerClient, ops...)
+}
+
+// NewDockerClient returns a client for the daemon configured by the environment, honoring DOCKER_HOST,
+// DOCKER_TLS_VERIFY, DOCKER_CERT_PATH and DOCKER_API_VERSION. Unless DOCKER_API_VERSION is set, the client
+// negotiates the API version with the daemon so that it works with daemons older than the client.
+func NewDockerClient() (*client.Client, error) {
+	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, errors.Wrap(err, "create docker client from environment")
+	}
+	return dockerClient, nil
+}
+
+func (i *Image) nameOptions() []name.Option {
+	if i.strictValidation {
+		return []name.Option{name.StrictValidation}
+	}
+	return []name.Option{name.WeakValidation}
+}
+
+// config returns the image's runtime config, or a NotFoundError if the image has none.
+func (i *Image) config() (*container.Config, error) {
+	if i.inspect.Config == nil {
+		return nil, imgutil.NotFoundError{Message: fmt.Sprintf("failed to get config for image '%s'", i.repoName)}
+	}
+	return i.inspect.Config, nil
+}
+
 func (i *Image) Label(key string) (string, error) {
-	labels := i.inspect.Config.Labels
-	return labels[key], nil
+	config, err := i.config()
+	if err != nil {
+		return "", err
+	}
+	return config.Labels[key], nil
 }
 
 func (i *Image) Labels() (map[string]string, error) {
+	config, err := i.config()
+	if err != nil {
+		return nil, err
+	}
 	copiedLabels := make(map[string]string)
-	for i, l := range i.inspect.Config.Labels {
+	for i, l := range config.Labels {
 		copiedLabels[i] = l
 	}
 	return copiedLabels, nil
 }
 
 func (i *Image) Env(key string) (string, error) {
-	for _, envVar := range i.inspect.Config.Env {
-		parts := strings.Split(envVar, "=")
-		if parts[0] == key {
+	config, err := i.config()
+	if err != nil {
+		return "", err
+	}
+	for _, envVar := range config.Env {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) == 2 && parts[0] == key {
 			return parts[1], nil
 		}
 	}
 	return "", nil
 }
 
+func (i *Image) EnvVars() ([]string, error) {
+	config, err := i.config()
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{}, config.Env...), nil
+}
+
+func (i *Image) EnvMap() (map[string]string, error) {
+	config, err := i.config()
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]string{}
+	for _, envVar := range config.Env {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env, nil
+}
+
+func (i *Image) WorkingDir() (string, error) {
+	config, err := i.config()
+	if err != nil {
+		return "", err
+	}
+	return config.WorkingDir, nil
+}
+
+func (i *Image) StopSignal() (string, error) {
+	config, err := i.config()
+	if err != nil {
+		return "", err
+	}
+	return config.StopSignal, nil
+}
+
+func (i *Image) OnBuild() ([]string, error) {
+	config, err := i.config()
+	if err != nil {
+		return nil, err
+	}
+	return config.OnBuild, nil
+}
+
+func (i *Image) Shell() ([]string, error) {
+	config, err := i.config()
+	if err != nil {
+		return nil, err
+	}
+	return config.Shell, nil
+}
+
+func (i *Image) User() (string, error) {
+	config, err := i.config()
+	if err != nil {
+		return "", err
+	}
+	return config.User, nil
+}
+
+func (i *Image) ExposedPorts() (map[string]struct{}, error) {
+	config, err := i.config()
+	if err != nil {
+		return nil, err
+	}
+	ports := make(map[string]struct{}, len(config.ExposedPorts))
+	for port := range config.ExposedPorts {
+		ports[string(port)] = struct{}{}
+	}
+	return ports, nil
+}
+
+func (i *Image) Volumes() (map[string]struct{}, error) {
+	config, err := i.config()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]struct{}, len(config.Volumes))
+	for path := range config.Volumes {
+		volumes[path] = struct{}{}
+	}
+	return volumes, nil
+}
+
+func (i *Image) Healthcheck() (*imgutil.HealthConfig, error) {
+	config, err := i.config()
+	if err != nil {
+		return nil, err
+	}
+	hc := config.Healthcheck
+	if hc == nil {
+		return nil, nil
+	}
+	return &imgutil.HealthConfig{
+		Test:        append([]string(nil), hc.Test...),
+		Interval:    hc.Interval,
+		Timeout:     hc.Timeout,
+		StartPeriod: hc.StartPeriod,
+		Retries:     hc.Retries,
+	}, nil
+}
+
 func (i *Image) OS() (string, error) {
 	return i.inspect.Os, nil
 }
@@ -140,12 +394,41 @@ func (i *Image) Rename(name string) {
 	if prevInspect, _, err := i.docker.ImageInspectWithRaw(context.TODO(), name); err == nil {
 		if i.sameBase(prevInspect) {
 			i.easyAddLayers = prevInspect.RootFS.Layers[len(i.inspect.RootFS.Layers):]
+			i.trackDaemonLayers(prevInspect)
 		}
 	}
 
 	i.repoName = name
 }
 
+// Clone returns a copy of the image named newName. Layers added to the image before it was cloned are read from
+// the same files, so the image must not be closed until the clone has been saved.
+func (i *Image) Clone(newName string) (imgutil.Image, error) {
+	// round trip through JSON to copy the maps and slices held by the inspect
+	b, err := json.Marshal(i.inspect)
+	if err != nil {
+		return nil, errors.Wrapf(err, "copy image '%s'", i.repoName)
+	}
+	var inspect types.ImageInspect
+	if err := json.Unmarshal(b, &inspect); err != nil {
+		return nil, errors.Wrapf(err, "copy image '%s'", i.repoName)
+	}
+
+	clone := *i
+	clone.inspect = inspect
+	clone.layerPaths = append([]string(nil), i.layerPaths...)
+	clone.history = append([]v1.History(nil), i.history...)
+	clone.easyAddLayers = append([]string(nil), i.easyAddLayers...)
+	clone.daemonLayers = map[string]string{}
+	for diffID, imageID := range i.daemonLayers {
+		clone.daemonLayers[diffID] = imageID
+	}
+	clone.downloads = map[string]*FileSystemLocalImage{}
+	clone.tempLayers = nil
+	clone.Rename(newName)
+	return &clone, nil
+}
+
 func (i *Image) sameBase(prevInspect types.ImageInspect) bool {
 	if len(prevInspect.RootFS.Layers) < len(i.inspect.RootFS.Layers) {
 		return false
@@ -162,6 +445,18 @@ func (i *Image) Name() string {
 	return i.repoName
 }
 
+func (i *Image) Registry() string {
+	return imgutil.RegistryFromName(i.repoName, i.nameOptions()...)
+}
+
+func (i *Image) Repository() string {
+	return imgutil.RepositoryFromName(i.repoName, i.nameOptions()...)
+}
+
+func (i *Image) Tag() (string, error) {
+	return imgutil.TagFromName(i.repoName, i.nameOptions()...)
+}
+
 func (i *Image) Found() bool {
 	return i.inspect.ID != ""
 }
@@ -172,6 +467,46 @@ func (i *Image) Identifier() (imgutil.Identifier, error) {
 	}, nil
 }
 
+// ConfigDigest returns the digest of the config the image would be saved with, which is the image ID the
+// daemon assigns on Save. It is not the manifest digest a registry would report for the image.
+func (i *Image) ConfigDigest() (string, error) {
+	configFile, err := i.newConfigFile()
+	if err != nil {
+		return "", errors.Wrap(err, "generate config file")
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(configFile)), nil
+}
+
+// Dirty reports whether the image has been modified since it was loaded from or last saved to the daemon. Until it
+// is saved, Identifier and RepoDigest describe the image in the daemon rather than the modified image.
+func (i *Image) Dirty() (bool, error) {
+	config, err := i.newConfigFile()
+	if err != nil {
+		return false, errors.Wrap(err, "generate config file")
+	}
+	return !bytes.Equal(config, i.savedConfig), nil
+}
+
+// RepoDigest returns the image as repository@digest, using the manifest digest the daemon recorded when the image
+// was pulled from or pushed to the image's repository, so that callers can pin the exact image they read. It returns
+// an empty string if the daemon has no digest for that repository, such as for images that were only built locally.
+func (i *Image) RepoDigest() (string, error) {
+	ref, err := name.ParseReference(i.repoName, i.nameOptions()...)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse reference for image '%s'", i.repoName)
+	}
+	for _, repoDigest := range i.inspect.RepoDigests {
+		digest, err := name.NewDigest(repoDigest, name.WeakValidation)
+		if err != nil {
+			continue
+		}
+		if digest.Context().Name() == ref.Context().Name() {
+			return fmt.Sprintf("%s@%s", ref.Context().Name(), digest.DigestStr()), nil
+		}
+	}
+	return "", nil
+}
+
 func (i *Image) CreatedAt() (time.Time, error) {
 	createdAtTime := i.inspect.Created
 	createdTime, err := time.Parse(time.RFC3339Nano, createdAtTime)
@@ -182,66 +517,146 @@ func (i *Image) CreatedAt() (time.Time, error) {
 	return createdTime, nil
 }
 
-func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
+// Rebase replaces the layers up to and including baseTopLayer with the layers of newBase. The new base may be
+// from any backend; layers of a base that is not a local image are read through GetLayer.
+func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image, opts ...imgutil.RebaseOption) error {
 	ctx := context.Background()
 
 	// FIND TOP LAYER
-	keepLayers := -1
-	for idx, diffID := range i.inspect.RootFS.Layers {
-		if diffID == baseTopLayer {
-			keepLayers = len(i.inspect.RootFS.Layers) - idx - 1
-			break
-		}
-	}
-	if keepLayers == -1 {
-		return fmt.Errorf("'%s' not found in '%s' during rebase", baseTopLayer, i.repoName)
+	plan, err := i.RebasePlan(baseTopLayer, newBase, opts...)
+	if err != nil {
+		return err
 	}
+	keepLayers := len(plan.KeptLayers)
 
-	// SWITCH BASE LAYERS
-	newBaseInspect, _, err := i.docker.ImageInspectWithRaw(ctx, newBase.Name())
+	// DOWNLOAD IMAGE
+	prevImage, err := i.downloadImageOnce(i.repoName)
 	if err != nil {
-		return errors.Wrap(err, "analyze read previous image config")
+		return err
 	}
-	i.inspect.RootFS.Layers = newBaseInspect.RootFS.Layers
-	i.layerPaths = make([]string, len(i.inspect.RootFS.Layers))
 
-	// DOWNLOAD IMAGE
-	if err := i.downloadImageOnce(i.repoName); err != nil {
+	// kept layers are read from the image in the daemon by position, so its layers must be the ones being rebased
+	if !sameLayers(prevImage.diffIDs, i.inspect.RootFS.Layers) {
+		return fmt.Errorf("layers of '%s' do not match the image in the daemon, save the image before rebasing it", i.repoName)
+	}
+
+	// SWITCH BASE LAYERS
+	if _, ok := newBase.(*Image); ok {
+		newBaseInspect, _, err := i.docker.ImageInspectWithRaw(ctx, newBase.Name())
+		if err != nil {
+			return errors.Wrap(err, "analyze read previous image config")
+		}
+		i.inspect.RootFS.Layers = newBaseInspect.RootFS.Layers
+		i.layerPaths = make([]string, len(i.inspect.RootFS.Layers))
+		if i.history, err = imageHistory(i.docker, newBaseInspect); err != nil {
+			return err
+		}
+		i.trackDaemonLayers(newBaseInspect)
+	} else if err := i.switchToForeignBase(newBase); err != nil {
 		return err
 	}
 
-	// READ MANIFEST.JSON
-	b, err := ioutil.ReadFile(filepath.Join(i.prevImage.dir, "manifest.json"))
+	// ADD EXISTING LAYERS
+	layerPaths, err := prevImage.layerFiles(prevImage.layers[(len(prevImage.layers) - keepLayers):]...)
 	if err != nil {
 		return err
 	}
-	var manifest []struct{ Layers []string }
-	if err := json.Unmarshal(b, &manifest); err != nil {
-		return err
+	for _, layerPath := range layerPaths {
+		if err := i.AddLayer(layerPath); err != nil {
+			return err
+		}
 	}
-	if len(manifest) != 1 {
-		return fmt.Errorf("expected 1 image received %d", len(manifest))
+
+	return nil
+}
+
+func (i *Image) RebasePlan(baseTopLayer string, newBase imgutil.Image, opts ...imgutil.RebaseOption) (*imgutil.RebaseResult, error) {
+	return imgutil.PlanRebase(i, baseTopLayer, newBase, opts...)
+}
+
+func sameLayers(diffIDs, otherDiffIDs []string) bool {
+	if len(diffIDs) != len(otherDiffIDs) {
+		return false
+	}
+	for idx := range diffIDs {
+		if diffIDs[idx] != otherDiffIDs[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// switchToForeignBase replaces the image's layers with the layers of newBase, which is not in the daemon, copying
+// each of them so that they can be loaded with the image
+func (i *Image) switchToForeignBase(newBase imgutil.Image) error {
+	diffIDs, err := newBase.LayerDiffIDs()
+	if err != nil {
+		return errors.Wrapf(err, "get layers of new base '%s'", newBase.Name())
 	}
 
-	// ADD EXISTING LAYERS
-	for _, filename := range manifest[0].Layers[(len(manifest[0].Layers) - keepLayers):] {
-		if err := i.AddLayer(filepath.Join(i.prevImage.dir, filename)); err != nil {
+	i.inspect.RootFS.Layers = nil
+	i.layerPaths = nil
+	i.history = nil
+	for _, diffID := range diffIDs {
+		rc, err := newBase.GetLayer(diffID)
+		if err != nil {
+			return errors.Wrapf(err, "get layer '%s' of new base '%s'", diffID, newBase.Name())
+		}
+		err = i.AddLayerFromReader(rc)
+		rc.Close()
+		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
 func (i *Image) SetLabel(key, val string) error {
-	if i.inspect.Config.Labels == nil {
-		i.inspect.Config.Labels = map[string]string{}
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+
+	config.Labels[key] = val
+	return nil
+}
+
+func (i *Image) SetLabels(labels map[string]string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
 	}
 
-	i.inspect.Config.Labels[key] = val
+	for key, val := range labels {
+		config.Labels[key] = val
+	}
 	return nil
 }
 
+func (i *Image) SetLabelsFromFile(path string) error {
+	labels, err := imgutil.ReadLabelsFile(path)
+	if err != nil {
+		return err
+	}
+	return i.SetLabels(labels)
+}
+
+// Annotations returns the image's labels, since the docker image format has no manifest annotations.
+func (i *Image) Annotations() (map[string]string, error) {
+	return i.Labels()
+}
+
+// SetAnnotation sets a label, since the docker image format has no manifest annotations.
+func (i *Image) SetAnnotation(key, val string) error {
+	return i.SetLabel(key, val)
+}
+
 func (i *Image) SetOS(osVal string) error {
 	if osVal != i.inspect.Os {
 		return fmt.Errorf(`invalid os: must match the daemon: "%s"`, i.inspect.Os)
@@ -260,13 +675,21 @@ func (i *Image) SetArchitecture(architecture string) error {
 }
 
 func (i *Image) RemoveLabel(key string) error {
-	delete(i.inspect.Config.Labels, key)
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	delete(config.Labels, key)
 	return nil
 }
 
 func (i *Image) SetEnv(key, val string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
 	ignoreCase := i.inspect.Os == "windows"
-	for idx, kv := range i.inspect.Config.Env {
+	for idx, kv := range config.Env {
 		parts := strings.SplitN(kv, "=", 2)
 		foundKey := parts[0]
 		searchKey := key
@@ -275,230 +698,1077 @@ func (i *Image) SetEnv(key, val string) error {
 			searchKey = strings.ToUpper(searchKey)
 		}
 		if foundKey == searchKey {
-			i.inspect.Config.Env[idx] = fmt.Sprintf("%s=%s", key, val)
+			config.Env[idx] = fmt.Sprintf("%s=%s", key, val)
 			return nil
 		}
 	}
-	i.inspect.Config.Env = append(i.inspect.Config.Env, fmt.Sprintf("%s=%s", key, val))
+	config.Env = append(config.Env, fmt.Sprintf("%s=%s", key, val))
 	return nil
 }
 
-func (i *Image) SetWorkingDir(dir string) error {
-	i.inspect.Config.WorkingDir = dir
+func (i *Image) RemoveEnv(key string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	ignoreCase := i.inspect.Os == "windows"
+	var env []string
+	for _, kv := range config.Env {
+		foundKey := strings.SplitN(kv, "=", 2)[0]
+		if foundKey == key || (ignoreCase && strings.EqualFold(foundKey, key)) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	config.Env = env
 	return nil
 }
 
-func (i *Image) SetEntrypoint(ep ...string) error {
-	i.inspect.Config.Entrypoint = ep
+func (i *Image) SetWorkingDir(dir string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	config.WorkingDir = dir
 	return nil
 }
 
-func (i *Image) SetCmd(cmd ...string) error {
-	i.inspect.Config.Cmd = cmd
+func (i *Image) SetStopSignal(sig string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	config.StopSignal = sig
 	return nil
 }
 
-func (i *Image) TopLayer() (string, error) {
-	all := i.inspect.RootFS.Layers
-
-	if len(all) == 0 {
-		return "", fmt.Errorf("image '%s' has no layers", i.repoName)
+func (i *Image) SetOnBuild(triggers ...string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
 	}
-
-	topLayer := all[len(all)-1]
-	return topLayer, nil
+	config.OnBuild = triggers
+	return nil
 }
 
-func (i *Image) GetLayer(diffID string) (io.ReadCloser, error) {
-	err := i.downloadImageOnce(i.repoName)
+func (i *Image) SetShell(shell ...string) error {
+	config, err := i.config()
 	if err != nil {
-		return nil, err
-	}
-
-	layerID, ok := i.prevImage.layersMap[diffID]
-	if !ok {
-		return nil, fmt.Errorf("image '%s' does not contain layer with diff ID '%s'", i.repoName, diffID)
+		return err
 	}
-	return os.Open(filepath.Join(i.prevImage.dir, layerID))
+	config.Shell = shell
+	return nil
 }
 
-func (i *Image) AddLayer(path string) error {
+func (i *Image) SetUser(user string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	config.User = user
+	return nil
+}
+
+func (i *Image) SetExposedPort(port string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	if config.ExposedPorts == nil {
+		config.ExposedPorts = nat.PortSet{}
+	}
+	config.ExposedPorts[nat.Port(port)] = struct{}{}
+	return nil
+}
+
+func (i *Image) RemoveExposedPort(port string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	delete(config.ExposedPorts, nat.Port(port))
+	return nil
+}
+
+func (i *Image) SetVolume(path string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	if config.Volumes == nil {
+		config.Volumes = map[string]struct{}{}
+	}
+	config.Volumes[path] = struct{}{}
+	return nil
+}
+
+func (i *Image) RemoveVolume(path string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	delete(config.Volumes, path)
+	return nil
+}
+
+func (i *Image) SetHealthcheck(hc *imgutil.HealthConfig) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	if hc == nil {
+		config.Healthcheck = nil
+		return nil
+	}
+	config.Healthcheck = &container.HealthConfig{
+		Test:        append([]string(nil), hc.Test...),
+		Interval:    hc.Interval,
+		Timeout:     hc.Timeout,
+		StartPeriod: hc.StartPeriod,
+		Retries:     hc.Retries,
+	}
+	return nil
+}
+
+func (i *Image) SetEntrypoint(ep ...string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	config.Entrypoint = ep
+	return nil
+}
+
+func (i *Image) SetCmd(cmd ...string) error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	config.Cmd = cmd
+	return nil
+}
+
+func (i *Image) ClearEntrypoint() error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	config.Entrypoint = nil
+	return nil
+}
+
+func (i *Image) ClearCmd() error {
+	config, err := i.config()
+	if err != nil {
+		return err
+	}
+	config.Cmd = nil
+	return nil
+}
+
+// SetConfig replaces the image's runtime config with a copy of cfg, including fields that have no typed setter, such
+// as OnBuild and ArgsEscaped. Fields the daemon keeps that are not part of an image config, such as StopTimeout, are
+// cleared.
+func (i *Image) SetConfig(cfg *v1.Config) error {
+	if cfg == nil {
+		return errors.New("config must not be nil")
+	}
+	i.inspect.Config = containerConfig(cfg.DeepCopy())
+	return nil
+}
+
+func (i *Image) TopLayer() (string, error) {
+	all := i.inspect.RootFS.Layers
+
+	if len(all) == 0 {
+		return "", fmt.Errorf("image '%s' has no layers", i.repoName)
+	}
+
+	topLayer := all[len(all)-1]
+	return topLayer, nil
+}
+
+func (i *Image) LayerDiffIDs() ([]string, error) {
+	return append([]string(nil), i.inspect.RootFS.Layers...), nil
+}
+
+func (i *Image) NumberOfLayers() (int, error) {
+	return len(i.inspect.RootFS.Layers), nil
+}
+
+// Size returns the sum of the uncompressed layer tar sizes and the config size.
+// Layers that only exist in the daemon are exported in order to measure them.
+func (i *Image) Size() (int64, error) {
+	configFile, err := i.newConfigFile()
+	if err != nil {
+		return 0, errors.Wrap(err, "generate config file")
+	}
+
+	size := int64(len(configFile))
+	for idx := range i.layerPaths {
+		path, err := i.layerPath(idx)
+		if err != nil {
+			return 0, err
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		size += fi.Size()
+	}
+	return size, nil
+}
+
+func (i *Image) RawConfig() ([]byte, error) {
+	return i.newConfigFile()
+}
+
+// ConfigFile returns the config the image would be saved with, generated from the daemon's view of the image.
+func (i *Image) ConfigFile() (*v1.ConfigFile, error) {
+	cfg, err := v1Config(i.inspect, i.history, i.createdAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate config file")
+	}
+	return cfg.DeepCopy(), nil
+}
+
+func (i *Image) GetLayer(diffID string) (io.ReadCloser, error) {
+	fsimg, err := i.downloadImageOnce(i.repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	layerID, ok := fsimg.layersMap[imgutil.NormalizeDiffID(diffID)]
+	if !ok {
+		return nil, fmt.Errorf("image '%s' does not contain layer with diff ID '%s'", i.repoName, diffID)
+	}
+	layerPath, err := fsimg.layerFile(layerID)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(layerPath)
+}
+
+// GetCompressedLayer is like GetLayer, but gzips the layer while it is read. The daemon only keeps uncompressed
+// layers, so the compressed contents may not match the blob a registry holds for the layer.
+func (i *Image) GetCompressedLayer(diffID string) (io.ReadCloser, error) {
+	rc, err := i.GetLayer(diffID)
+	if err != nil {
+		return nil, err
+	}
+	return v1util.GzipReadCloser(rc), nil
+}
+
+// GetLayerByDigest is like GetLayer, but finds the layer by the digest of its compressed contents. The daemon only
+// keeps uncompressed layers, so that digest is the one the layer gets when gzipped for a registry push, which is
+// computed by exporting and compressing each of the image's layers until one matches.
+func (i *Image) GetLayerByDigest(digest string) (io.ReadCloser, error) {
+	hash, err := v1.NewHash(digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse layer digest '%s'", digest)
+	}
+	layers, err := i.v1Layers()
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		layerDigest, err := layer.Digest()
+		if err != nil {
+			return nil, errors.Wrap(err, "get layer digest")
+		}
+		if layerDigest == hash {
+			return layer.Uncompressed()
+		}
+	}
+	return nil, fmt.Errorf("image '%s' does not contain layer with digest '%s'", i.repoName, digest)
+}
+
+// AddLayer adds the layer tar at path. The daemon only loads uncompressed layers, so a gzipped tar is
+// decompressed to a temporary file first.
+func (i *Image) AddLayer(path string, opts ...imgutil.LayerOption) error {
+	compressed, err := isGzipped(path)
+	if err != nil {
+		return errors.Wrap(err, "AddLayer")
+	}
+	if compressed {
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrapf(err, "AddLayer: open layer: %s", path)
+		}
+		defer f.Close()
+		if err := i.AddLayerFromReader(f); err != nil {
+			return err
+		}
+	} else {
+		diffID, err := fileDiffID(path)
+		if err != nil {
+			return errors.Wrap(err, "AddLayer")
+		}
+		i.addLayer(path, diffID)
+	}
+
+	for _, opt := range opts {
+		opt(&i.history[len(i.history)-1])
+	}
+	return nil
+}
+
+// gzipMagic is the header that starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzipped reports whether the file at path starts with the gzip magic number
+func isGzipped(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, errors.Wrapf(err, "open layer: %s", path)
+	}
+	defer f.Close()
+	magic := make([]byte, len(gzipMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "read layer: %s", path)
+	}
+	return bytes.Equal(magic, gzipMagic), nil
+}
+
+// fileDiffID returns the diff ID of the uncompressed layer tar at path
+func fileDiffID(path string) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return errors.Wrapf(err, "AddLayer: open layer: %s", path)
+		return "", errors.Wrapf(err, "open layer: %s", path)
 	}
 	defer f.Close()
 	hasher := sha256.New()
 	if _, err := io.Copy(hasher, f); err != nil {
-		return errors.Wrapf(err, "AddLayer: calculate checksum: %s", path)
+		return "", errors.Wrapf(err, "calculate checksum: %s", path)
 	}
-	diffID := "sha256:" + hex.EncodeToString(hasher.Sum(make([]byte, 0, hasher.Size())))
-	return i.AddLayerWithDiffID(path, diffID)
+	return "sha256:" + hex.EncodeToString(hasher.Sum(make([]byte, 0, hasher.Size()))), nil
 }
 
+// AddLayerFromReader adds a layer from the tar contents of r, decompressing them if they are gzipped.
+func (i *Image) AddLayerFromReader(r io.Reader) error {
+	if err := i.checkOpen(); err != nil {
+		return err
+	}
+	path, diffID, err := writeTempLayer(r)
+	if err != nil {
+		return errors.Wrap(err, "AddLayerFromReader")
+	}
+	i.tempLayers = append(i.tempLayers, path)
+	i.addLayer(path, diffID)
+	return nil
+}
+
+// AddLayerFromImage adds the layer with diffID from src, which may be an image from any backend. The layer is read
+// through src.GetLayer and its contents must match diffID.
+func (i *Image) AddLayerFromImage(src imgutil.Image, diffID string) error {
+	if err := i.checkOpen(); err != nil {
+		return err
+	}
+	rc, err := src.GetLayer(diffID)
+	if err != nil {
+		return errors.Wrapf(err, "get layer '%s' from image '%s'", diffID, src.Name())
+	}
+	defer rc.Close()
+
+	path, actual, err := writeTempLayer(rc)
+	if err != nil {
+		return errors.Wrapf(err, "add layer '%s' from image '%s'", diffID, src.Name())
+	}
+	if actual != diffID {
+		os.Remove(path)
+		return fmt.Errorf("layer '%s' from image '%s' has diff ID '%s'", diffID, src.Name(), actual)
+	}
+	i.tempLayers = append(i.tempLayers, path)
+	i.addLayer(path, diffID)
+	return nil
+}
+
+// writeTempLayer writes the tar contents of r, decompressed if they are gzipped, to a temp file and returns its
+// path and diff ID. Layers are read from disk during save, so the contents are spilled to a temp file. The file is
+// removed if it cannot be written.
+func writeTempLayer(r io.Reader) (string, string, error) {
+	br := bufio.NewReader(r)
+	r = br
+	if magic, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return "", "", errors.Wrap(err, "decompress layer")
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	f, err := ioutil.TempFile("", "imgutil.local.layer.")
+	if err != nil {
+		return "", "", errors.Wrap(err, "create temp file")
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(f, io.TeeReader(r, hasher))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", "", errors.Wrapf(err, "write layer: %s", f.Name())
+	}
+	return f.Name(), "sha256:" + hex.EncodeToString(hasher.Sum(make([]byte, 0, hasher.Size()))), nil
+}
+
+// AddLayers adds the layer tars at paths, in order, as AddLayer would. The files are read and hashed concurrently,
+// with at most one file per CPU in flight, and no layer is added unless all of them can be.
+func (i *Image) AddLayers(paths ...string) error {
+	if err := i.checkOpen(); err != nil {
+		return err
+	}
+	type result struct {
+		path, diffID, tempPath string
+		err                    error
+	}
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, runtime.NumCPU())
+		results = make([]result, len(paths))
+		failed  int32
+	)
+	for idx, path := range paths {
+		sem <- struct{}{}
+		// no layer is added once one fails, so the remaining layers are not read
+		if atomic.LoadInt32(&failed) != 0 {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(res *result, path string) {
+			defer func() {
+				if res.err != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+				<-sem
+				wg.Done()
+			}()
+			res.path = path
+			compressed, err := isGzipped(path)
+			if err != nil {
+				res.err = err
+				return
+			}
+			if !compressed {
+				res.diffID, res.err = fileDiffID(path)
+				return
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				res.err = errors.Wrapf(err, "open layer: %s", path)
+				return
+			}
+			defer f.Close()
+			res.tempPath, res.diffID, res.err = writeTempLayer(f)
+			if res.tempPath != "" {
+				res.path = res.tempPath
+			}
+		}(&results[idx], path)
+	}
+	wg.Wait()
+
+	for _, res := range results {
+		if res.err == nil {
+			continue
+		}
+		// no layer is added, so the layers decompressed so far are not needed
+		for _, res := range results {
+			if res.tempPath != "" {
+				os.Remove(res.tempPath)
+			}
+		}
+		return errors.Wrap(res.err, "AddLayers")
+	}
+	for _, res := range results {
+		if res.tempPath != "" {
+			i.tempLayers = append(i.tempLayers, res.tempPath)
+		}
+		i.addLayer(res.path, res.diffID)
+	}
+	return nil
+}
+
+// AddLayerWithDiffID adds the layer tar at path, trusting that diffID is its digest instead of reading the whole
+// file to compute it, unless WithDiffIDValidation is used. The tar must be uncompressed, since the daemon only
+// loads uncompressed layers and a compressed digest would not match the loaded layer.
 func (i *Image) AddLayerWithDiffID(path, diffID string) error {
+	compressed, err := isGzipped(path)
+	if err != nil {
+		return errors.Wrap(err, "AddLayerWithDiffID")
+	}
+	if compressed {
+		return fmt.Errorf("layer '%s' is gzip compressed, pass an uncompressed tar or use AddLayer to decompress it", path)
+	}
+	if i.validateDiffIDs {
+		actual, err := fileDiffID(path)
+		if err != nil {
+			return errors.Wrap(err, "AddLayerWithDiffID")
+		}
+		if actual != diffID {
+			return fmt.Errorf("layer '%s' has diff ID '%s', not '%s'", path, actual, diffID)
+		}
+	}
+	i.addLayer(path, diffID)
+	return nil
+}
+
+func (i *Image) addLayer(path, diffID string) {
 	i.inspect.RootFS.Layers = append(i.inspect.RootFS.Layers, diffID)
 	i.layerPaths = append(i.layerPaths, path)
+	i.history = append(i.history, v1.History{})
+	i.easyAddLayers = nil
+}
+
+// Squash replaces the image's layers with a single layer holding the filesystem they produce together.
+func (i *Image) Squash() error {
+	if len(i.layerPaths) == 0 {
+		return nil
+	}
+
+	var layers []io.Reader
+	for idx := range i.layerPaths {
+		path, err := i.layerPath(idx)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		layers = append(layers, f)
+	}
+
+	f, err := ioutil.TempFile("", "imgutil.local.layer.")
+	if err != nil {
+		return errors.Wrap(err, "Squash: create temp file")
+	}
+	defer f.Close()
+	i.tempLayers = append(i.tempLayers, f.Name())
+	hasher := sha256.New()
+	if err := layer.Squash(io.MultiWriter(f, hasher), layers...); err != nil {
+		return errors.Wrapf(err, "Squash: write layer: %s", f.Name())
+	}
+
+	i.inspect.RootFS.Layers = []string{"sha256:" + hex.EncodeToString(hasher.Sum(nil))}
+	i.layerPaths = []string{f.Name()}
+	i.history = []v1.History{{CreatedBy: imgutil.SquashedCreatedBy}}
 	i.easyAddLayers = nil
 	return nil
 }
 
+// NormalizeHistory pads or trims the image's history to one entry per layer, as registries require, not counting
+// entries for instructions that did not create a layer. Entries are matched to layers from the top, so that the
+// oldest entries are trimmed and empty entries are added for the bottom layers. Save normalizes the history as well.
+func (i *Image) NormalizeHistory() error {
+	i.history = imgutil.NormalizedHistory(i.history, len(i.inspect.RootFS.Layers))
+	return nil
+}
+
+// ReuseLayer reuses the layer with diffID from the previous image. diffID may be given with or without the
+// "sha256:" prefix.
 func (i *Image) ReuseLayer(diffID string) error {
+	diffID = imgutil.NormalizeDiffID(diffID)
 	if len(i.easyAddLayers) > 0 && i.easyAddLayers[0] == diffID {
 		i.inspect.RootFS.Layers = append(i.inspect.RootFS.Layers, diffID)
 		i.layerPaths = append(i.layerPaths, "")
+		i.history = append(i.history, v1.History{})
 		i.easyAddLayers = i.easyAddLayers[1:]
 		return nil
 	}
 
-	if i.prevName == "" {
-		return errors.New("no previous image provided to reuse layers from")
+	if i.prevName == "" {
+		return errors.Wrap(imgutil.ErrLayerNotFound, "no previous image provided to reuse layers from")
+	}
+
+	prevImage, err := i.downloadImageOnce(i.prevName)
+	if err != nil {
+		return err
+	}
+
+	reuseLayer, ok := prevImage.layersMap[diffID]
+	if !ok {
+		return errors.Wrapf(imgutil.ErrLayerNotFound, "SHA %s was not found in %s", diffID, i.prevName)
+	}
+
+	layerPath, err := prevImage.layerFile(reuseLayer)
+	if err != nil {
+		return err
+	}
+	// the daemon's manifest maps diff IDs to layer files, so check the file really has the requested diff ID
+	actualDiffID, err := fileDiffID(layerPath)
+	if err != nil {
+		return errors.Wrapf(err, "reuse layer '%s'", diffID)
+	}
+	if actualDiffID != diffID {
+		return fmt.Errorf("layer '%s' in %s has diff ID '%s', expected '%s'", reuseLayer, i.prevName, actualDiffID, diffID)
+	}
+	i.addLayer(layerPath, diffID)
+	return nil
+}
+
+func (i *Image) ReuseLayerOrAdd(diffID, path string) error {
+	if err := i.ReuseLayer(diffID); !errors.Is(err, imgutil.ErrLayerNotFound) {
+		return err
+	}
+	return i.AddLayer(path)
+}
+
+func (i *Image) Save(additionalNames ...string) error {
+	return i.SaveCtx(context.Background(), additionalNames...)
+}
+
+// SaveCtx behaves like Save, but aborts loading the image into the daemon when ctx is cancelled. A cancelled save
+// also removes the files exported from the previous image.
+func (i *Image) SaveCtx(ctx context.Context, additionalNames ...string) error {
+	// all valid names are tagged by a single image load; invalid names don't block the others
+	var (
+		errs       []imgutil.SaveDiagnostic
+		validNames = []string{i.Name()}
+		repoTags   []string
+	)
+	for _, n := range additionalNames {
+		t, err := name.NewTag(n, i.nameOptions()...)
+		if err != nil {
+			errs = append(errs, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
+			continue
+		}
+		validNames = append(validNames, n)
+		repoTags = append(repoTags, t.Name())
+	}
+
+	inspect, err := i.doSave(ctx, repoTags...)
+	if err != nil {
+		if ctx.Err() != nil && i.prevName != "" {
+			if rmErr := i.removeDownload(i.prevName); rmErr != nil {
+				err = errors.Wrapf(err, "remove files of previous image '%s': %s", i.prevName, rmErr)
+			}
+		}
+		for _, n := range validNames {
+			errs = append(errs, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
+		}
+		return imgutil.SaveError{Errors: errs}
+	}
+	i.inspect = inspect
+	i.trackDaemonLayers(inspect)
+	i.savedConfig, _ = i.newConfigFile()
+
+	if len(errs) > 0 {
+		return imgutil.SaveError{Errors: errs}
+	}
+
+	return nil
+}
+
+func (i *Image) doSave(ctx context.Context, additionalTags ...string) (types.ImageInspect, error) {
+	done := make(chan error, 1)
+
+	t, err := name.NewTag(i.repoName, i.nameOptions()...)
+	if err != nil {
+		return types.ImageInspect{}, err
+	}
+
+	// returns valid 'name:tag' appending 'latest', if missing tag
+	repoName := t.Name()
+
+	if err := i.checkLayerFiles(); err != nil {
+		return types.ImageInspect{}, err
+	}
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		res, err := i.docker.ImageLoad(ctx, pr, i.stdout == nil)
+		if err != nil {
+			// unblock any pending writes to the pipe
+			pr.CloseWithError(err)
+			done <- err
+			return
+		}
+
+		// only return response error after response is drained and closed
+		responseErr := daemon.CheckResponseError(res.Body, i.stdout)
+		drainCloseErr := daemon.EnsureReaderClosed(res.Body)
+		if responseErr != nil {
+			done <- responseErr
+			return
+		}
+		if drainCloseErr != nil {
+			done <- drainCloseErr
+		}
+
+		done <- nil
+	}()
+
+	id, err := i.writeArchive(pw, append([]string{repoName}, additionalTags...), false)
+	if err != nil {
+		return types.ImageInspect{}, err
+	}
+
+	pw.Close()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		return types.ImageInspect{}, errors.Wrapf(err, "image load '%s'. first error", i.repoName)
+	}
+
+	inspect, _, err := i.docker.ImageInspectWithRaw(ctx, id)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return types.ImageInspect{}, errors.Wrapf(err, "save image '%s'", i.repoName)
+		}
+		return types.ImageInspect{}, err
+	}
+
+	return inspect, nil
+}
+
+// SaveToWriter writes the image to w in the `docker save` format, tagged with the image name, instead of loading it
+// into the daemon. Layers that only exist in the daemon are exported from it so that the archive is complete.
+func (i *Image) SaveToWriter(w io.Writer) error {
+	t, err := name.NewTag(i.repoName, i.nameOptions()...)
+	if err != nil {
+		return err
+	}
+	if err := i.checkLayerFiles(); err != nil {
+		return err
+	}
+	_, err = i.writeArchive(w, []string{t.Name()}, true)
+	return err
+}
+
+// checkLayerFiles returns an error for the first added layer whose file cannot be read, so that saving fails
+// before any of the image is written instead of partway through the archive
+func (i *Image) checkLayerFiles() error {
+	if err := i.checkOpen(); err != nil {
+		return err
+	}
+	for idx, path := range i.layerPaths {
+		if path == "" {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrapf(err, "layer file missing for layer '%s'", i.inspect.RootFS.Layers[idx])
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// writeArchive writes the image to w in the `docker save` format and returns its image ID. Unless allLayers is set,
+// layers the daemon already has are left out, which `docker load` allows for images sharing those layers.
+func (i *Image) writeArchive(w io.Writer, repoTags []string, allLayers bool) (string, error) {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	configFile, err := i.newConfigFile()
+	if err != nil {
+		return "", errors.Wrap(err, "generate config file")
+	}
+
+	id := fmt.Sprintf("%x", sha256.Sum256(configFile))
+	if err := daemon.AddTextToTar(tw, id+".json", configFile); err != nil {
+		return "", err
+	}
+
+	var onWrite func(int64)
+	if i.saveProgress != nil {
+		var written int64
+		onWrite = func(n int64) {
+			written += n
+			i.saveProgress(written)
+		}
+	}
+
+	paths := i.layerPaths
+	if allLayers {
+		if paths, err = i.allLayerPaths(); err != nil {
+			return "", err
+		}
+	}
+
+	var layerPaths []string
+	for _, path := range paths {
+		if path == "" {
+			layerPaths = append(layerPaths, "")
+			continue
+		}
+		layerName := fmt.Sprintf("/%x.tar", sha256.Sum256([]byte(path)))
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if err := addFileToTar(tw, layerName, f, onWrite); err != nil {
+			return "", err
+		}
+		f.Close()
+		layerPaths = append(layerPaths, layerName)
+	}
+
+	manifest, err := json.Marshal([]map[string]interface{}{
+		{
+			"Config":   id + ".json",
+			"RepoTags": repoTags,
+			"Layers":   layerPaths,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := daemon.AddTextToTar(tw, "manifest.json", manifest); err != nil {
+		return "", err
+	}
+	return id, tw.Close()
+}
+
+func (i *Image) newConfigFile() ([]byte, error) {
+	cfg, err := v1Config(i.inspect, i.history, i.createdAt)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(cfg)
+}
+
+// SaveToOCI writes the image to dir as an OCI image layout.
+func (i *Image) SaveToOCI(dir string) error {
+	layers, err := i.v1Layers()
+	if err != nil {
+		return err
+	}
+	for idx, layer := range layers {
+		layers[idx] = &ociLayer{Layer: layer}
 	}
 
-	err := i.downloadImageOnce(i.prevName)
+	image, err := i.v1Image(layers)
 	if err != nil {
 		return err
 	}
+	image = mutate.MediaType(image, ggcrtypes.OCIManifestSchema1)
 
-	reuseLayer, ok := i.prevImage.layersMap[diffID]
-	if !ok {
-		return fmt.Errorf("SHA %s was not found in %s", diffID, i.repoName)
+	path, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		return errors.Wrapf(err, "create layout '%s'", dir)
 	}
-
-	return i.AddLayer(filepath.Join(i.prevImage.dir, reuseLayer))
+	return path.AppendImage(image)
 }
 
-func (i *Image) Save(additionalNames ...string) error {
-	inspect, err := i.doSave()
+// SaveToOCIArchive writes the image to path as an OCI image layout in a single tar, the format that tools such as
+// skopeo and podman read as `oci-archive:`.
+func (i *Image) SaveToOCIArchive(path string) error {
+	dir, err := ioutil.TempDir("", "imgutil.local.oci.")
 	if err != nil {
-		saveErr := imgutil.SaveError{}
-		for _, n := range append([]string{i.Name()}, additionalNames...) {
-			saveErr.Errors = append(saveErr.Errors, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
-		}
-		return saveErr
+		return errors.Wrap(err, "create temp dir")
 	}
-	i.inspect = inspect
+	defer os.RemoveAll(dir)
 
-	var errs []imgutil.SaveDiagnostic
-	for _, n := range append([]string{i.Name()}, additionalNames...) {
-		if err := i.docker.ImageTag(context.Background(), i.inspect.ID, n); err != nil {
-			errs = append(errs, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
-		}
+	if err := i.SaveToOCI(dir); err != nil {
+		return err
 	}
 
-	if len(errs) > 0 {
-		return imgutil.SaveError{Errors: errs}
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "create archive '%s'", path)
+	}
+	err = writeDirToTar(f, dir)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return errors.Wrapf(err, "write archive '%s'", path)
 	}
-
 	return nil
 }
 
-func (i *Image) doSave() (types.ImageInspect, error) {
-	ctx := context.Background()
-	done := make(chan error)
+// writeDirToTar writes the contents of dir to w as a tar with normalized timestamps
+func writeDirToTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil || filePath == dir {
+			return err
+		}
+		name, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if fi.IsDir() {
+			return tw.WriteHeader(&tar.Header{Name: name + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: imgutil.NormalizedDateTime})
+		}
+		contents, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer contents.Close()
+		return addFileToTar(tw, name, contents, nil)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
 
-	t, err := name.NewTag(i.repoName, name.WeakValidation)
+// CopyToRegistry pushes the image to repoName, returning the digest of the pushed manifest.
+// Layers the registry already has are not uploaded again.
+func (i *Image) CopyToRegistry(repoName string, keychain authn.Keychain) (string, error) {
+	ref, err := name.ParseReference(repoName, i.nameOptions()...)
 	if err != nil {
-		return types.ImageInspect{}, err
+		return "", err
+	}
+	auth, err := keychain.Resolve(ref.Context().Registry)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolve auth for '%s'", repoName)
 	}
 
-	// returns valid 'name:tag' appending 'latest', if missing tag
-	repoName := t.Name()
+	layers, err := i.v1Layers()
+	if err != nil {
+		return "", err
+	}
+	image, err := i.v1Image(layers)
+	if err != nil {
+		return "", err
+	}
 
-	pr, pw := io.Pipe()
-	defer pw.Close()
-	go func() {
-		res, err := i.docker.ImageLoad(ctx, pr, true)
-		if err != nil {
-			done <- err
-			return
-		}
+	if err := remote.Write(ref, image, remote.WithAuth(auth)); err != nil {
+		return "", errors.Wrapf(err, "write image to '%s'", repoName)
+	}
 
-		// only return response error after response is drained and closed
-		responseErr := checkResponseError(res.Body)
-		drainCloseErr := ensureReaderClosed(res.Body)
-		if responseErr != nil {
-			done <- responseErr
-			return
-		}
-		if drainCloseErr != nil {
-			done <- drainCloseErr
-		}
+	digest, err := image.Digest()
+	if err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
 
-		done <- nil
-	}()
+// v1Image returns an image made of layers and the config the image would be saved with
+func (i *Image) v1Image(layers []v1.Layer) (v1.Image, error) {
+	cfg, err := v1Config(i.inspect, i.history, i.createdAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate config file")
+	}
 
-	tw := tar.NewWriter(pw)
-	defer tw.Close()
+	image, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		return nil, err
+	}
+	return mutate.ConfigFile(image, &cfg)
+}
 
-	configFile, err := i.newConfigFile()
+// layerPath returns the path to the tar of the layer at idx, exporting the image from the daemon if the layer
+// only exists there
+func (i *Image) layerPath(idx int) (string, error) {
+	paths, err := i.layerPathsAt(idx)
 	if err != nil {
-		return types.ImageInspect{}, errors.Wrap(err, "generate config file")
+		return "", err
 	}
+	return paths[0], nil
+}
 
-	id := fmt.Sprintf("%x", sha256.Sum256(configFile))
-	if err := addTextToTar(tw, id+".json", configFile); err != nil {
-		return types.ImageInspect{}, err
+// layerPathsAt is like layerPath for the layers at idxs, but exports each image in the daemon they come from once
+func (i *Image) layerPathsAt(idxs ...int) ([]string, error) {
+	if err := i.checkOpen(); err != nil {
+		return nil, err
 	}
 
-	var layerPaths []string
-	for _, path := range i.layerPaths {
-		if path == "" {
-			layerPaths = append(layerPaths, "")
+	paths := make([]string, len(idxs))
+	var imageIDs []string
+	daemonIdxs := map[string][]int{}
+	for n, idx := range idxs {
+		if i.layerPaths[idx] != "" {
+			paths[n] = i.layerPaths[idx]
 			continue
 		}
-		layerName := fmt.Sprintf("/%x.tar", sha256.Sum256([]byte(path)))
-		f, err := os.Open(path)
-		if err != nil {
-			return types.ImageInspect{}, err
+		diffID := i.inspect.RootFS.Layers[idx]
+		imageID, ok := i.daemonLayers[diffID]
+		if !ok {
+			return nil, fmt.Errorf("image '%s' does not contain layer with diff ID '%s'", i.repoName, diffID)
 		}
-		defer f.Close()
-		if err := addFileToTar(tw, layerName, f); err != nil {
-			return types.ImageInspect{}, err
+		if _, ok := daemonIdxs[imageID]; !ok {
+			imageIDs = append(imageIDs, imageID)
 		}
-		f.Close()
-		layerPaths = append(layerPaths, layerName)
+		daemonIdxs[imageID] = append(daemonIdxs[imageID], n)
 	}
 
-	manifest, err := json.Marshal([]map[string]interface{}{
-		{
-			"Config":   id + ".json",
-			"RepoTags": []string{repoName},
-			"Layers":   layerPaths,
-		},
-	})
-	if err != nil {
-		return types.ImageInspect{}, err
+	for _, imageID := range imageIDs {
+		daemonImage, err := i.downloadImageOnce(imageID)
+		if err != nil {
+			return nil, errors.Wrap(err, "download base image layers")
+		}
+		var layerIDs []string
+		for _, n := range daemonIdxs[imageID] {
+			diffID := i.inspect.RootFS.Layers[idxs[n]]
+			layerID, ok := daemonImage.layersMap[diffID]
+			if !ok {
+				return nil, fmt.Errorf("image '%s' does not contain layer with diff ID '%s'", i.repoName, diffID)
+			}
+			layerIDs = append(layerIDs, layerID)
+		}
+		layerFiles, err := daemonImage.layerFiles(layerIDs...)
+		if err != nil {
+			return nil, err
+		}
+		for m, n := range daemonIdxs[imageID] {
+			paths[n] = layerFiles[m]
+		}
 	}
+	return paths, nil
+}
 
-	if err := addTextToTar(tw, "manifest.json", manifest); err != nil {
-		return types.ImageInspect{}, err
+// allLayerPaths returns the paths to the tars of all the image's layers, exporting any that only exist in the daemon
+func (i *Image) allLayerPaths() ([]string, error) {
+	idxs := make([]int, len(i.layerPaths))
+	for idx := range idxs {
+		idxs[idx] = idx
 	}
+	return i.layerPathsAt(idxs...)
+}
 
-	tw.Close()
-	pw.Close()
-	err = <-done
+// v1Layers returns the image's layers, exporting any that only exist in the daemon
+func (i *Image) v1Layers() ([]v1.Layer, error) {
+	paths, err := i.allLayerPaths()
 	if err != nil {
-		return types.ImageInspect{}, errors.Wrapf(err, "image load '%s'. first error", i.repoName)
+		return nil, err
 	}
-
-	inspect, _, err := i.docker.ImageInspectWithRaw(context.Background(), id)
-	if err != nil {
-		if client.IsErrNotFound(err) {
-			return types.ImageInspect{}, errors.Wrapf(err, "save image '%s'", i.repoName)
+	layers := make([]v1.Layer, len(paths))
+	for idx, path := range paths {
+		layer, err := tarball.LayerFromFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read layer: %s", path)
 		}
-		return types.ImageInspect{}, err
+		layers[idx] = layer
 	}
-
-	return inspect, nil
+	return layers, nil
 }
 
-func (i *Image) newConfigFile() ([]byte, error) {
-	cfg, err := v1Config(i.inspect)
-	if err != nil {
-		return nil, err
+// trackDaemonLayers records that the layers of inspect can be read from its image in the daemon
+func (i *Image) trackDaemonLayers(inspect types.ImageInspect) {
+	if i.daemonLayers == nil {
+		i.daemonLayers = map[string]string{}
 	}
-	return json.Marshal(cfg)
+	for _, diffID := range inspect.RootFS.Layers {
+		i.daemonLayers[diffID] = inspect.ID
+	}
+}
+
+// ociLayer reports the OCI media type for a layer
+type ociLayer struct {
+	v1.Layer
+}
+
+func (l *ociLayer) MediaType() (ggcrtypes.MediaType, error) {
+	return ggcrtypes.OCILayer, nil
 }
 
 func (i *Image) Delete() error {
@@ -513,31 +1783,98 @@ func (i *Image) Delete() error {
 	return err
 }
 
-func (i *Image) downloadImageOnce(imageName string) error {
+// Close removes any temporary files created for the image, such as layers downloaded by GetLayer or ReuseLayer.
+// Callers should defer Close once they are done with the image. Once closed, methods that read or write layer files,
+// such as Save, GetLayer, ReuseLayer and AddLayerFromReader, return an error.
+func (i *Image) Close() error {
+	i.closed = true
 	var err error
-	i.downloadOnce.Do(func() {
-		var fsimg *FileSystemLocalImage
-		fsimg, err = downloadImage(i.docker, imageName)
-		i.prevImage = fsimg
-	})
+	for imageName := range i.downloads {
+		if rmErr := i.removeDownload(imageName); rmErr != nil && err == nil {
+			err = rmErr
+		}
+	}
+	for _, path := range i.tempLayers {
+		if rmErr := os.Remove(path); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+			err = rmErr
+		}
+	}
+	i.tempLayers = nil
 	return err
 }
 
-func downloadImage(docker client.CommonAPIClient, imageName string) (*FileSystemLocalImage, error) {
-	ctx := context.Background()
+// checkOpen returns an error once the image is closed, since the files its layers are read from may be removed
+func (i *Image) checkOpen() error {
+	if i.closed {
+		return fmt.Errorf("image '%s' is closed", i.repoName)
+	}
+	return nil
+}
+
+// removeDownload removes the files exported from the daemon for imageName, if any. Layers reused from those files
+// are read from the daemon image again once they are needed, so the image can still be saved.
+func (i *Image) removeDownload(imageName string) error {
+	fsimg, ok := i.downloads[imageName]
+	if !ok {
+		return nil
+	}
+	delete(i.downloads, imageName)
+	for idx, layerPath := range i.layerPaths {
+		if !strings.HasPrefix(layerPath, fsimg.dir+string(filepath.Separator)) {
+			continue
+		}
+		if i.daemonLayers == nil {
+			i.daemonLayers = map[string]string{}
+		}
+		i.daemonLayers[i.inspect.RootFS.Layers[idx]] = fsimg.imageID
+		i.layerPaths[idx] = ""
+	}
+	return os.RemoveAll(fsimg.dir)
+}
 
-	imageReader, err := docker.ImageSave(ctx, []string{imageName})
+// downloadImageOnce downloads imageName from the daemon, reusing any earlier download of the same name
+func (i *Image) downloadImageOnce(imageName string) (*FileSystemLocalImage, error) {
+	if err := i.checkOpen(); err != nil {
+		return nil, err
+	}
+	if fsimg, ok := i.downloads[imageName]; ok {
+		return fsimg, nil
+	}
+	fsimg, err := downloadImage(i.docker, imageName, i.stdout)
 	if err != nil {
 		return nil, err
 	}
-	defer ensureReaderClosed(imageReader)
+	i.downloads[imageName] = fsimg
+	return fsimg, nil
+}
+
+// downloadImage reads the manifest and config of imageName from the daemon. Layers are only extracted once they are
+// requested through layerFile. Each export from the daemon is announced on stdout, if it is not nil.
+func downloadImage(docker client.CommonAPIClient, imageName string, stdout io.Writer) (*FileSystemLocalImage, error) {
+	inspect, _, err := docker.ImageInspectWithRaw(context.Background(), imageName)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil, imgutil.NotFoundError{Message: err.Error()}
+		}
+		return nil, errors.Wrapf(err, "inspect image '%s'", imageName)
+	}
 
 	tmpDir, err := ioutil.TempDir("", "imgutil.local.image.")
 	if err != nil {
 		return nil, errors.Wrap(err, "local reuse-layer create temp dir")
 	}
+	fsimg := &FileSystemLocalImage{
+		dir:       tmpDir,
+		imageName: imageName,
+		imageID:   inspect.ID,
+		docker:    docker,
+		stdout:    stdout,
+	}
 
-	err = untar(imageReader, tmpDir)
+	// symlinks are extracted up front so that layers that link to the file of an identical layer can be resolved
+	err = fsimg.extract(func(hdr *tar.Header) bool {
+		return hdr.Typeflag == tar.TypeSymlink || !strings.Contains(path.Clean(hdr.Name), "/")
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -560,7 +1897,11 @@ func downloadImage(docker client.CommonAPIClient, imageName string) (*FileSystem
 		return nil, fmt.Errorf("manifest.json had unexpected number of entries: %d", len(manifest))
 	}
 
-	df, err := os.Open(filepath.Join(tmpDir, manifest[0].Config))
+	configPath, err := fsimg.layerFile(manifest[0].Config)
+	if err != nil {
+		return nil, err
+	}
+	df, err := os.Open(configPath)
 	if err != nil {
 		return nil, err
 	}
@@ -580,41 +1921,130 @@ func downloadImage(docker client.CommonAPIClient, imageName string) (*FileSystem
 		return nil, fmt.Errorf("layers and diff IDs do not match, there are %d layers and %d diffIDs", len(manifest[0].Layers), len(details.RootFS.DiffIDs))
 	}
 
-	layersMap := make(map[string]string, len(manifest[0].Layers))
+	fsimg.layers = manifest[0].Layers
+	fsimg.diffIDs = details.RootFS.DiffIDs
+	fsimg.layersMap = make(map[string]string, len(manifest[0].Layers))
 	for i, diffID := range details.RootFS.DiffIDs {
 		layerID := manifest[0].Layers[i]
-		layersMap[diffID] = layerID
+		fsimg.layersMap[diffID] = layerID
 	}
+	return fsimg, nil
+}
 
-	return &FileSystemLocalImage{
-		dir:       tmpDir,
-		layersMap: layersMap,
-	}, nil
+// layerFile returns the path to the file at name in the image's `docker save` archive, extracting it from the
+// daemon if it has not been extracted yet
+func (f *FileSystemLocalImage) layerFile(name string) (string, error) {
+	paths, err := f.layerFiles(name)
+	if err != nil {
+		return "", err
+	}
+	return paths[0], nil
 }
 
-func addTextToTar(tw *tar.Writer, name string, contents []byte) error {
-	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}
-	if err := tw.WriteHeader(hdr); err != nil {
+// layerFiles is like layerFile, but extracts all the files at names that have not been extracted yet from a single
+// export
+func (f *FileSystemLocalImage) layerFiles(names ...string) ([]string, error) {
+	paths := make([]string, len(names))
+	missing := map[string]bool{}
+	for idx, name := range names {
+		// follow symlinks to other entries in the archive, which may not have been extracted yet
+		for hops := 0; hops < 10; hops++ {
+			filePath := filepath.Join(f.dir, filepath.FromSlash(name))
+			if !withinDir(f.dir, filePath) {
+				return nil, fmt.Errorf("tar entry '%s' is outside of the destination directory", name)
+			}
+			target, err := os.Readlink(filePath)
+			if err != nil {
+				break
+			}
+			name = path.Join(path.Dir(name), filepath.ToSlash(target))
+		}
+
+		filePath := filepath.Join(f.dir, filepath.FromSlash(name))
+		if !withinDir(f.dir, filePath) {
+			return nil, fmt.Errorf("tar entry '%s' is outside of the destination directory", name)
+		}
+		if ok, err := resolvesWithinDir(f.dir, filePath); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("tar entry '%s' is outside of the destination directory", name)
+		}
+		if _, err := os.Stat(filePath); err != nil {
+			missing[path.Clean(name)] = true
+		}
+		paths[idx] = filePath
+	}
+
+	if len(missing) > 0 {
+		err := f.extract(func(hdr *tar.Header) bool {
+			return missing[path.Clean(hdr.Name)]
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for idx, filePath := range paths {
+		if _, err := os.Stat(filePath); err != nil {
+			return nil, fmt.Errorf("image '%s' does not contain file '%s'", f.imageName, names[idx])
+		}
+	}
+	return paths, nil
+}
+
+// extract saves the image from the daemon and extracts the entries matched by include to the image's dir
+func (f *FileSystemLocalImage) extract(include func(hdr *tar.Header) bool) error {
+	if f.stdout != nil {
+		fmt.Fprintf(f.stdout, "Exporting image '%s' from the daemon\n", f.imageName)
+	}
+	imageReader, err := f.docker.ImageSave(context.Background(), []string{f.imageID})
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return imgutil.NotFoundError{Message: err.Error()}
+		}
 		return err
 	}
-	_, err := tw.Write(contents)
-	return err
+	defer daemon.EnsureReaderClosed(imageReader)
+
+	return untarEntries(imageReader, f.dir, include)
 }
 
-func addFileToTar(tw *tar.Writer, name string, contents *os.File) error {
+func addFileToTar(tw *tar.Writer, name string, contents *os.File, onWrite func(int64)) error {
 	fi, err := contents.Stat()
 	if err != nil {
 		return err
 	}
-	hdr := &tar.Header{Name: name, Mode: 0644, Size: fi.Size()}
+	hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: fi.Size(), ModTime: imgutil.NormalizedDateTime}
 	if err := tw.WriteHeader(hdr); err != nil {
 		return err
 	}
-	_, err = io.Copy(tw, contents)
+	var w io.Writer = tw
+	if onWrite != nil {
+		w = &callbackWriter{w: tw, onWrite: onWrite}
+	}
+	_, err = io.Copy(w, contents)
 	return err
 }
 
+// callbackWriter reports the size of each successful write to onWrite
+type callbackWriter struct {
+	w       io.Writer
+	onWrite func(int64)
+}
+
+func (c *callbackWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if n > 0 {
+		c.onWrite(int64(n))
+	}
+	return n, err
+}
+
 func untar(r io.Reader, dest string) error {
+	return untarEntries(r, dest, func(*tar.Header) bool { return true })
+}
+
+// untarEntries extracts the entries of the tar read from r that are matched by include
+func untarEntries(r io.Reader, dest string, include func(hdr *tar.Header) bool) error {
 	tr := tar.NewReader(r)
 	for {
 		hdr, err := tr.Next()
@@ -625,8 +2055,26 @@ func untar(r io.Reader, dest string) error {
 		if err != nil {
 			return err
 		}
+		if !include(hdr) {
+			continue
+		}
 
 		path := filepath.Join(dest, hdr.Name)
+		if !withinDir(dest, path) {
+			return fmt.Errorf("tar entry '%s' is outside of the destination directory", hdr.Name)
+		}
+		// symlinks extracted earlier can lead outside of dest even though the path is inside it lexically
+		if ok, err := resolvesWithinDir(dest, path); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("tar entry '%s' is outside of the destination directory", hdr.Name)
+		}
+		// replace rather than write through a symlink extracted earlier at the same path
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
 
 		switch hdr.Typeflag {
 		case tar.TypeDir:
@@ -634,11 +2082,8 @@ func untar(r io.Reader, dest string) error {
 				return err
 			}
 		case tar.TypeReg, tar.TypeRegA:
-			_, err := os.Stat(filepath.Dir(path))
-			if os.IsNotExist(err) {
-				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-					return err
-				}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
 			}
 
 			fh, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, hdr.FileInfo().Mode())
@@ -651,15 +2096,75 @@ func untar(r io.Reader, dest string) error {
 			}
 			fh.Close()
 		case tar.TypeSymlink:
+			target := hdr.Linkname
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			if !withinDir(dest, target) {
+				return fmt.Errorf("tar entry '%s' links to '%s', which is outside of the destination directory", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
 			if err := os.Symlink(hdr.Linkname, path); err != nil {
 				return err
 			}
+		case tar.TypeLink:
+			// hardlink targets are relative to the root of the archive and must already be extracted
+			target := filepath.Join(dest, hdr.Linkname)
+			if !withinDir(dest, target) {
+				return fmt.Errorf("tar entry '%s' links to '%s', which is outside of the destination directory", hdr.Name, hdr.Linkname)
+			}
+			if ok, err := resolvesWithinDir(dest, target); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("tar entry '%s' links to '%s', which is outside of the destination directory", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.Link(target, path); err != nil {
+				return err
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			// device files and named pipes can't be created without privileges and are never needed to read
+			// image layers, so they are skipped
+			continue
 		default:
 			return fmt.Errorf("unknown file type in tar %d", hdr.Typeflag)
 		}
 	}
 }
 
+// withinDir reports whether path is dir or a path inside it
+func withinDir(dir, path string) bool {
+	dir = filepath.Clean(dir)
+	path = filepath.Clean(path)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// resolvesWithinDir reports whether the parent of path, which must be inside dir lexically, is still inside dir once
+// the symlinks of its closest existing ancestor are resolved
+func resolvesWithinDir(dir, path string) (bool, error) {
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false, err
+	}
+	ancestor := filepath.Dir(filepath.Clean(path))
+	for withinDir(dir, ancestor) {
+		if _, err := os.Lstat(ancestor); err == nil {
+			resolved, err := filepath.EvalSymlinks(ancestor)
+			if err != nil {
+				// a dangling symlink could be created outside of dir later on
+				return false, nil
+			}
+			return withinDir(resolvedDir, resolved), nil
+		}
+		ancestor = filepath.Dir(ancestor)
+	}
+	return false, nil
+}
+
 func inspectOptionalImage(docker client.CommonAPIClient, imageName string) (types.ImageInspect, error) {
 	var (
 		err     error
@@ -677,6 +2182,42 @@ func inspectOptionalImage(docker client.CommonAPIClient, imageName string) (type
 	return inspect, nil
 }
 
+// imageHistory returns the history of the image of inspect in the daemon, oldest entry first, or an empty entry per
+// layer if the image is not in the daemon. The daemon does not report which entries created a layer, so entries
+// without a size are taken to be instructions that did not; when that does not account for every layer, the
+// history cannot be matched to the layers and an empty entry per layer is returned as well.
+func imageHistory(docker client.CommonAPIClient, inspect types.ImageInspect) ([]v1.History, error) {
+	emptyHistory := make([]v1.History, len(inspect.RootFS.Layers))
+	if inspect.ID == "" {
+		return emptyHistory, nil
+	}
+	items, err := docker.ImageHistory(context.Background(), inspect.ID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get history of image '%s'", inspect.ID)
+	}
+
+	history := make([]v1.History, 0, len(items))
+	layers := 0
+	for idx := len(items) - 1; idx >= 0; idx-- {
+		entry := v1.History{
+			CreatedBy:  items[idx].CreatedBy,
+			Comment:    items[idx].Comment,
+			EmptyLayer: items[idx].Size == 0,
+		}
+		if created := time.Unix(items[idx].Created, 0).UTC(); !created.IsZero() {
+			entry.Created = v1.Time{Time: created}
+		}
+		if !entry.EmptyLayer {
+			layers++
+		}
+		history = append(history, entry)
+	}
+	if layers != len(inspect.RootFS.Layers) {
+		return emptyHistory, nil
+	}
+	return history, nil
+}
+
 func defaultInspect(docker client.CommonAPIClient) (types.ImageInspect, error) {
 	daemonInfo, err := docker.Info(context.Background())
 	if err != nil {
@@ -690,12 +2231,19 @@ func defaultInspect(docker client.CommonAPIClient) (types.ImageInspect, error) {
 	}, nil
 }
 
-func v1Config(inspect types.ImageInspect) (v1.ConfigFile, error) {
-	history := make([]v1.History, len(inspect.RootFS.Layers))
+func v1Config(inspect types.ImageInspect, layerHistory []v1.History, createdAt time.Time) (v1.ConfigFile, error) {
+	history := imgutil.NormalizedHistory(layerHistory, len(inspect.RootFS.Layers))
 	for i := range history {
-		// zero history
+		// zero history, keeping only recorded provenance
+		created := history[i].Created
+		if created.IsZero() {
+			created = v1.Time{Time: createdAt}
+		}
 		history[i] = v1.History{
-			Created: v1.Time{Time: imgutil.NormalizedDateTime},
+			Created:    created,
+			CreatedBy:  history[i].CreatedBy,
+			Comment:    history[i].Comment,
+			EmptyLayer: history[i].EmptyLayer,
 		}
 	}
 	diffIDs := make([]v1.Hash, len(inspect.RootFS.Layers))
@@ -706,12 +2254,12 @@ func v1Config(inspect types.ImageInspect) (v1.ConfigFile, error) {
 		}
 		diffIDs[i] = hash
 	}
-	exposedPorts := make(map[string]struct{}, len(inspect.Config.ExposedPorts))
-	for key, val := range inspect.Config.ExposedPorts {
-		exposedPorts[string(key)] = val
-	}
 	var config v1.Config
 	if inspect.Config != nil {
+		exposedPorts := make(map[string]struct{}, len(inspect.Config.ExposedPorts))
+		for key, val := range inspect.Config.ExposedPorts {
+			exposedPorts[string(key)] = val
+		}
 		var healthcheck *v1.HealthConfig
 		if inspect.Config.Healthcheck != nil {
 			healthcheck = &v1.HealthConfig{
@@ -749,11 +2297,15 @@ func v1Config(inspect types.ImageInspect) (v1.ConfigFile, error) {
 			Shell:           inspect.Config.Shell,
 		}
 	}
+	osType := inspect.Os
+	if osType == "" {
+		osType = "linux"
+	}
 	return v1.ConfigFile{
 		Architecture: inspect.Architecture,
-		Created:      v1.Time{Time: imgutil.NormalizedDateTime},
+		Created:      v1.Time{Time: createdAt},
 		History:      history,
-		OS:           inspect.Os,
+		OS:           osType,
 		OSVersion:    inspect.OsVersion,
 		RootFS: v1.RootFS{
 			Type:    "layers",
@@ -763,24 +2315,49 @@ func v1Config(inspect types.ImageInspect) (v1.ConfigFile, error) {
 	}, nil
 }
 
-func checkResponseError(r io.Reader) error {
-	decoder := json.NewDecoder(r)
-	var jsonMessage jsonmessage.JSONMessage
-	if err := decoder.Decode(&jsonMessage); err != nil {
-		return errors.Wrapf(err, "parsing daemon response")
+// containerConfig is the inverse of the runtime config conversion in v1Config
+func containerConfig(cfg *v1.Config) *container.Config {
+	var exposedPorts nat.PortSet
+	if cfg.ExposedPorts != nil {
+		exposedPorts = make(nat.PortSet, len(cfg.ExposedPorts))
+		for key, val := range cfg.ExposedPorts {
+			exposedPorts[nat.Port(key)] = val
+		}
 	}
-
-	if jsonMessage.Error != nil {
-		return errors.Wrap(jsonMessage.Error, "embedded daemon response")
+	var healthcheck *container.HealthConfig
+	if cfg.Healthcheck != nil {
+		healthcheck = &container.HealthConfig{
+			Test:        cfg.Healthcheck.Test,
+			Interval:    cfg.Healthcheck.Interval,
+			Timeout:     cfg.Healthcheck.Timeout,
+			StartPeriod: cfg.Healthcheck.StartPeriod,
+			Retries:     cfg.Healthcheck.Retries,
+		}
 	}
-	return nil
-}
-
-// ensureReaderClosed drains and closes and reader, returning the first error
-func ensureReaderClosed(r io.ReadCloser) error {
-	_, err := io.Copy(ioutil.Discard, r)
-	if closeErr := r.Close(); closeErr != nil && err == nil {
-		err = closeErr
+	return &container.Config{
+		AttachStderr:    cfg.AttachStderr,
+		AttachStdin:     cfg.AttachStdin,
+		AttachStdout:    cfg.AttachStdout,
+		Cmd:             cfg.Cmd,
+		Healthcheck:     healthcheck,
+		Domainname:      cfg.Domainname,
+		Entrypoint:      cfg.Entrypoint,
+		Env:             cfg.Env,
+		Hostname:        cfg.Hostname,
+		Image:           cfg.Image,
+		Labels:          cfg.Labels,
+		OnBuild:         cfg.OnBuild,
+		OpenStdin:       cfg.OpenStdin,
+		StdinOnce:       cfg.StdinOnce,
+		Tty:             cfg.Tty,
+		User:            cfg.User,
+		Volumes:         cfg.Volumes,
+		WorkingDir:      cfg.WorkingDir,
+		ExposedPorts:    exposedPorts,
+		ArgsEscaped:     cfg.ArgsEscaped,
+		NetworkDisabled: cfg.NetworkDisabled,
+		MacAddress:      cfg.MacAddress,
+		StopSignal:      cfg.StopSignal,
+		Shell:           cfg.Shell,
 	}
-	return err
 }
@@ -2,22 +2,35 @@ package local_test
 
 import (
 	"archive/tar"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
 	"github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/fakes"
 	"github.com/buildpacks/imgutil/local"
 	h "github.com/buildpacks/imgutil/testhelpers"
 )
@@ -141,6 +154,24 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 
 					h.AssertEq(t, img.Found(), true)
 				})
+
+				it("keeps the history of the base image", func() {
+					baseHistory, err := dockerClient.ImageHistory(context.TODO(), runnableBaseImageName)
+					h.AssertNil(t, err)
+
+					img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.Save())
+					defer h.DockerRmi(dockerClient, img.Name())
+
+					history, err := dockerClient.ImageHistory(context.TODO(), img.Name())
+					h.AssertNil(t, err)
+					h.AssertEq(t, len(history), len(baseHistory))
+					for idx := range history {
+						h.AssertEq(t, history[idx].CreatedBy, baseHistory[idx].CreatedBy)
+						h.AssertEq(t, history[idx].Size, baseHistory[idx].Size)
+					}
+				})
 			})
 
 			when("base image and daemon architecture do not match", func() {
@@ -186,6 +217,18 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 		})
+
+		when("#WithStrictValidation", func() {
+			it("accepts fully specified image names", func() {
+				_, err := local.NewImage(newTestImageName()+":some-tag", dockerClient, local.WithStrictValidation())
+				h.AssertNil(t, err)
+			})
+
+			it("rejects image names that rely on defaults", func() {
+				_, err := local.NewImage("some-image", dockerClient, local.WithStrictValidation())
+				h.AssertError(t, err, "invalid image name 'some-image'")
+			})
+		})
 	})
 
 	when("#Labels", func() {
@@ -308,6 +351,7 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertNil(t, err)
 
 				h.AssertNil(t, existingImage.SetEnv("MY_VAR", "my_val"))
+				h.AssertNil(t, existingImage.SetEnv("CONNECTION", "host=db;port=5"))
 				h.AssertNil(t, existingImage.Save())
 			})
 
@@ -324,6 +368,15 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, val, "my_val")
 			})
 
+			it("preserves values containing '='", func() {
+				img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+
+				val, err := img.Env("CONNECTION")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "host=db;port=5")
+			})
+
 			it("returns an empty string for a missing label", func() {
 				img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
 				h.AssertNil(t, err)
@@ -332,6 +385,21 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertNil(t, err)
 				h.AssertEq(t, val, "")
 			})
+
+			it("lists all variables", func() {
+				img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+
+				envVars, err := img.EnvVars()
+				h.AssertNil(t, err)
+				h.AssertContains(t, envVars, "MY_VAR=my_val", "CONNECTION=host=db;port=5")
+
+				envMap, err := img.EnvMap()
+				h.AssertNil(t, err)
+				h.AssertEq(t, envMap["MY_VAR"], "my_val")
+				h.AssertEq(t, envMap["CONNECTION"], "host=db;port=5")
+				h.AssertEq(t, len(envMap), len(envVars))
+			})
 		})
 
 		when("image NOT exists", func() {
@@ -357,6 +425,79 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#Registry, #Repository and #Tag", func() {
+		it("returns the components of the image name", func() {
+			img, err := local.NewImage("busybox", dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, img.Registry(), "index.docker.io")
+			h.AssertEq(t, img.Repository(), "library/busybox")
+			tag, err := img.Tag()
+			h.AssertNil(t, err)
+			h.AssertEq(t, tag, "latest")
+		})
+	})
+
+	when("#NewImageFromEnv", func() {
+		it("connects to the daemon configured by the environment", func() {
+			img, err := local.NewImageFromEnv(newTestImageName(), local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, img.Found(), true)
+		})
+	})
+
+	when("#NewDockerClient", func() {
+		it("negotiates the API version with the daemon", func() {
+			dockerClient, err := local.NewDockerClient()
+			h.AssertNil(t, err)
+
+			_, err = dockerClient.Info(context.TODO())
+			h.AssertNil(t, err)
+
+			version, err := dockerClient.ServerVersion(context.TODO())
+			h.AssertNil(t, err)
+			h.AssertEq(t, versions.LessThan(version.APIVersion, dockerClient.ClientVersion()), false)
+		})
+	})
+
+	when("#Clone", func() {
+		var (
+			repoName  = newTestImageName()
+			cloneName = newTestImageName()
+		)
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName, cloneName))
+		})
+
+		it("returns a copy that is modified and saved independently", func() {
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("mykey", "original"))
+
+			clone, err := img.Clone(cloneName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, clone.Name(), cloneName)
+			h.AssertNil(t, clone.SetLabel("mykey", "clone"))
+			h.AssertNil(t, clone.SetEnv("CLONE_ONLY", "true"))
+
+			label, err := img.Label("mykey")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "original")
+			env, err := img.Env("CLONE_ONLY")
+			h.AssertNil(t, err)
+			h.AssertEq(t, env, "")
+
+			h.AssertNil(t, img.Save())
+			h.AssertNil(t, clone.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), cloneName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.Config.Labels["mykey"], "clone")
+		})
+	})
+
 	when("#CreatedAt", func() {
 		it("returns the containers created at time", func() {
 			img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
@@ -430,6 +571,109 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#Dirty", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("reports modifications that have not been saved", func() {
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+			dirty, err := img.(*local.Image).Dirty()
+			h.AssertNil(t, err)
+			h.AssertEq(t, dirty, false)
+
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+			dirty, err = img.(*local.Image).Dirty()
+			h.AssertNil(t, err)
+			h.AssertEq(t, dirty, true)
+
+			h.AssertNil(t, img.Save())
+			dirty, err = img.(*local.Image).Dirty()
+			h.AssertNil(t, err)
+			h.AssertEq(t, dirty, false)
+		})
+	})
+
+	when("#RepoDigest", func() {
+		it("returns the repository and digest the image was pulled with", func() {
+			img, err := local.NewImage(runnableBaseImageName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			repoDigest, err := img.(*local.Image).RepoDigest()
+			h.AssertNil(t, err)
+
+			ref, err := name.ParseReference(runnableBaseImageName, name.WeakValidation)
+			h.AssertNil(t, err)
+			h.AssertEq(t, strings.HasPrefix(repoDigest, ref.Context().Name()+"@sha256:"), true)
+		})
+
+		it("returns an empty string for images that were never pulled or pushed", func() {
+			repoName := newTestImageName()
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.Save())
+			defer h.DockerRmi(dockerClient, repoName)
+
+			img, err = local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			repoDigest, err := img.(*local.Image).RepoDigest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, repoDigest, "")
+		})
+	})
+
+	when("#ConfigDigest", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("returns the image ID the image is saved with", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+
+			digest, err := img.(*local.Image).ConfigDigest()
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+			h.AssertEq(t, digest, h.ImageID(t, repoName))
+		})
+	})
+
+	when("#WithCreatedAt", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("saves the image with the provided creation time", func() {
+			createdAt := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+			img, err := local.NewImage(repoName, dockerClient, local.WithCreatedAt(createdAt))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.Created, createdAt.Format(time.RFC3339))
+
+			sameImg, err := local.NewImage(repoName, dockerClient, local.WithCreatedAt(createdAt))
+			h.AssertNil(t, err)
+			h.AssertNil(t, sameImg.SetLabel("mykey", "myvalue"))
+			digest, err := sameImg.(*local.Image).ConfigDigest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, digest, inspect.ID)
+		})
+	})
+
 	when("#SetLabel", func() {
 		var (
 			img           imgutil.Image
@@ -498,6 +742,69 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#SetLabels", func() {
+		it("merges all labels into the existing labels", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetLabel("existing-key", "existing-val"))
+			h.AssertNil(t, img.SetLabels(map[string]string{
+				"some-key":  "some-val",
+				"other-key": "other-val",
+			}))
+
+			labels, err := img.Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, labels, map[string]string{
+				"existing-key": "existing-val",
+				"some-key":     "some-val",
+				"other-key":    "other-val",
+			})
+		})
+	})
+
+	when("#SetLabelsFromFile", func() {
+		it("merges the labels in the file into the existing labels", func() {
+			labelsFile, err := ioutil.TempFile("", "labels")
+			h.AssertNil(t, err)
+			defer os.Remove(labelsFile.Name())
+			_, err = labelsFile.WriteString("# some comment\nsome-key=some-val\n\nother-key=other=val\n")
+			h.AssertNil(t, err)
+			h.AssertNil(t, labelsFile.Close())
+
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetLabel("existing-key", "existing-val"))
+			h.AssertNil(t, img.SetLabelsFromFile(labelsFile.Name()))
+
+			labels, err := img.Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, labels, map[string]string{
+				"existing-key": "existing-val",
+				"some-key":     "some-val",
+				"other-key":    "other=val",
+			})
+		})
+	})
+
+	when("#SetAnnotation #Annotations", func() {
+		it("stores annotations as labels", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetAnnotation("org.opencontainers.image.source", "https://example.com/repo"))
+
+			annotations, err := img.Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, annotations["org.opencontainers.image.source"], "https://example.com/repo")
+
+			label, err := img.Label("org.opencontainers.image.source")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "https://example.com/repo")
+		})
+	})
+
 	when("#RemoveLabel", func() {
 		var (
 			img           imgutil.Image
@@ -595,6 +902,14 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 
 				h.AssertContains(t, inspect.Config.Env, "ENV_KEY=SOME_OTHER_VAL")
 				h.AssertDoesNotContain(t, inspect.Config.Env, "ENV_KEY=SOME_VAL")
+
+				var entries int
+				for _, envVar := range inspect.Config.Env {
+					if strings.HasPrefix(envVar, "ENV_KEY=") {
+						entries++
+					}
+				}
+				h.AssertEq(t, entries, 1)
 			})
 
 			when("windows", func() {
@@ -636,41 +951,56 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
-	when("#SetWorkingDir", func() {
+	when("#RemoveEnv", func() {
 		var repoName = newTestImageName()
 
 		it.After(func() {
 			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
 		})
 
-		it("sets the environment", func() {
+		it("removes the variable", func() {
 			img, err := local.NewImage(repoName, dockerClient)
 			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetEnv("ENV_KEY", "ENV_VAL"))
+			h.AssertNil(t, img.SetEnv("OTHER_KEY", "OTHER_VAL"))
 
-			err = img.SetWorkingDir("/some/work/dir")
-			h.AssertNil(t, err)
-
+			h.AssertNil(t, img.RemoveEnv("ENV_KEY"))
 			h.AssertNil(t, img.Save())
 
 			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
 			h.AssertNil(t, err)
+			h.AssertDoesNotContain(t, inspect.Config.Env, "ENV_KEY=ENV_VAL")
+			h.AssertContains(t, inspect.Config.Env, "OTHER_KEY=OTHER_VAL")
+		})
 
-			h.AssertEq(t, inspect.Config.WorkingDir, "/some/work/dir")
+		when("the key does not exist", func() {
+			it("does nothing", func() {
+				img, err := local.NewImage(repoName, dockerClient)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.SetEnv("OTHER_KEY", "OTHER_VAL"))
+
+				h.AssertNil(t, img.RemoveEnv("MISSING_KEY"))
+				h.AssertNil(t, img.Save())
+
+				inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+				h.AssertNil(t, err)
+				h.AssertContains(t, inspect.Config.Env, "OTHER_KEY=OTHER_VAL")
+			})
 		})
 	})
 
-	when("#SetEntrypoint", func() {
+	when("#SetWorkingDir", func() {
 		var repoName = newTestImageName()
 
 		it.After(func() {
 			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
 		})
 
-		it("sets the entrypoint", func() {
+		it("sets the environment", func() {
 			img, err := local.NewImage(repoName, dockerClient)
 			h.AssertNil(t, err)
 
-			err = img.SetEntrypoint("some", "entrypoint")
+			err = img.SetWorkingDir("/some/work/dir")
 			h.AssertNil(t, err)
 
 			h.AssertNil(t, img.Save())
@@ -678,87 +1008,386 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
 			h.AssertNil(t, err)
 
-			h.AssertEq(t, []string(inspect.Config.Entrypoint), []string{"some", "entrypoint"})
+			h.AssertEq(t, inspect.Config.WorkingDir, "/some/work/dir")
 		})
 	})
 
-	when("#SetCmd", func() {
+	when("#WorkingDir", func() {
 		var repoName = newTestImageName()
 
+		it.Before(func() {
+			existingImage, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, existingImage.SetWorkingDir("/some/work/dir"))
+			h.AssertNil(t, existingImage.Save())
+		})
+
 		it.After(func() {
 			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
 		})
 
-		it("sets the cmd", func() {
-			img, err := local.NewImage(repoName, dockerClient)
+		it("returns the working dir", func() {
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
 			h.AssertNil(t, err)
 
-			err = img.SetCmd("some", "cmd")
+			dir, err := img.WorkingDir()
+			h.AssertNil(t, err)
+			h.AssertEq(t, dir, "/some/work/dir")
+		})
+	})
+
+	when("#SetStopSignal #StopSignal", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("sets and returns the stop signal", func() {
+			img, err := local.NewImage(repoName, dockerClient)
 			h.AssertNil(t, err)
 
+			h.AssertNil(t, img.SetStopSignal("SIGINT"))
 			h.AssertNil(t, img.Save())
 
 			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
 			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.Config.StopSignal, "SIGINT")
 
-			h.AssertEq(t, []string(inspect.Config.Cmd), []string{"some", "cmd"})
+			img, err = local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			sig, err := img.StopSignal()
+			h.AssertNil(t, err)
+			h.AssertEq(t, sig, "SIGINT")
 		})
 	})
 
-	when("#SetOS", func() {
+	when("#SetOnBuild #OnBuild #SetShell #Shell", func() {
 		var repoName = newTestImageName()
 
 		it.After(func() {
 			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
 		})
 
-		it("allows noop sets for values that match the daemon", func() {
+		it("sets and returns the onbuild triggers and shell", func() {
 			img, err := local.NewImage(repoName, dockerClient)
 			h.AssertNil(t, err)
 
-			err = img.SetOS("fakeos")
-			h.AssertError(t, err, "invalid os: must match the daemon")
-
-			err = img.SetOS(daemonOS)
-			h.AssertNil(t, err)
-
+			h.AssertNil(t, img.SetOnBuild("RUN echo some-trigger", "ENV SOME_KEY=some-val"))
+			h.AssertNil(t, img.SetShell("/bin/bash", "-c"))
 			h.AssertNil(t, img.Save())
 
 			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
 			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.Config.OnBuild, []string{"RUN echo some-trigger", "ENV SOME_KEY=some-val"})
+			h.AssertEq(t, []string(inspect.Config.Shell), []string{"/bin/bash", "-c"})
 
-			h.AssertEq(t, inspect.Os, daemonOS)
+			img, err = local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			onBuild, err := img.OnBuild()
+			h.AssertNil(t, err)
+			h.AssertEq(t, onBuild, []string{"RUN echo some-trigger", "ENV SOME_KEY=some-val"})
+			shell, err := img.Shell()
+			h.AssertNil(t, err)
+			h.AssertEq(t, shell, []string{"/bin/bash", "-c"})
 		})
 	})
 
-	when("#SetOSVersion #SetArchitecture", func() {
+	when("#SetUser #User", func() {
 		var repoName = newTestImageName()
 
 		it.After(func() {
 			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
 		})
 
-		it("sets the os.version/arch", func() {
+		it("sets the user", func() {
 			img, err := local.NewImage(repoName, dockerClient)
 			h.AssertNil(t, err)
 
-			err = img.SetOSVersion("1.2.3.4")
+			h.AssertNil(t, img.SetUser("1000:1000"))
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
 			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.Config.User, "1000:1000")
 
-			err = img.SetArchitecture("arm64")
+			savedImg, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			user, err := savedImg.User()
+			h.AssertNil(t, err)
+			h.AssertEq(t, user, "1000:1000")
+		})
+	})
+
+	when("#SetExposedPort #RemoveExposedPort #ExposedPorts", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("sets and removes exposed ports", func() {
+			img, err := local.NewImage(repoName, dockerClient)
 			h.AssertNil(t, err)
 
+			h.AssertNil(t, img.SetExposedPort("8080/tcp"))
+			h.AssertNil(t, img.SetExposedPort("53/udp"))
+			h.AssertNil(t, img.RemoveExposedPort("53/udp"))
 			h.AssertNil(t, img.Save())
 
 			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
 			h.AssertNil(t, err)
+			h.AssertEq(t, len(inspect.Config.ExposedPorts), 1)
+			_, ok := inspect.Config.ExposedPorts["8080/tcp"]
+			h.AssertEq(t, ok, true)
 
-			h.AssertEq(t, inspect.OsVersion, "1.2.3.4")
-			h.AssertEq(t, inspect.Architecture, "arm64")
+			savedImg, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			ports, err := savedImg.ExposedPorts()
+			h.AssertNil(t, err)
+			h.AssertEq(t, ports, map[string]struct{}{"8080/tcp": {}})
 		})
 	})
 
-	when("#Rebase", func() {
+	when("#SetVolume #RemoveVolume #Volumes", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("sets and removes volumes", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetVolume("/data"))
+			h.AssertNil(t, img.SetVolume("/data"))
+			h.AssertNil(t, img.SetVolume("/cache"))
+			h.AssertNil(t, img.RemoveVolume("/cache"))
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.Config.Volumes, map[string]struct{}{"/data": {}})
+
+			savedImg, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			volumes, err := savedImg.Volumes()
+			h.AssertNil(t, err)
+			h.AssertEq(t, volumes, map[string]struct{}{"/data": {}})
+		})
+	})
+
+	when("#SetHealthcheck #Healthcheck", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("sets the healthcheck", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			healthcheck := &imgutil.HealthConfig{
+				Test:     []string{"CMD", "true"},
+				Interval: 30 * time.Second,
+				Timeout:  5 * time.Second,
+				Retries:  3,
+			}
+			h.AssertNil(t, img.SetHealthcheck(healthcheck))
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.Config.Healthcheck.Test, []string{"CMD", "true"})
+
+			savedImg, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			actual, err := savedImg.Healthcheck()
+			h.AssertNil(t, err)
+			h.AssertEq(t, actual, healthcheck)
+		})
+
+		it("clears the healthcheck when given nil", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetHealthcheck(&imgutil.HealthConfig{Test: []string{"CMD", "true"}}))
+			h.AssertNil(t, img.SetHealthcheck(nil))
+			h.AssertNil(t, img.Save())
+
+			actual, err := img.Healthcheck()
+			h.AssertNil(t, err)
+			h.AssertEq(t, actual == nil, true)
+		})
+	})
+
+	when("#SetEntrypoint", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("sets the entrypoint", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			err = img.SetEntrypoint("some", "entrypoint")
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, []string(inspect.Config.Entrypoint), []string{"some", "entrypoint"})
+		})
+	})
+
+	when("#SetCmd", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("sets the cmd", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			err = img.SetCmd("some", "cmd")
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, []string(inspect.Config.Cmd), []string{"some", "cmd"})
+		})
+	})
+
+	when("#ClearEntrypoint #ClearCmd", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("removes the entrypoint and cmd", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetEntrypoint("some", "entrypoint"))
+			h.AssertNil(t, img.SetCmd("some", "cmd"))
+
+			h.AssertNil(t, img.ClearEntrypoint())
+			h.AssertNil(t, img.ClearCmd())
+			h.AssertNil(t, img.Save())
+
+			_, raw, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, strings.Contains(string(raw), `"Entrypoint":[`), false)
+			h.AssertEq(t, strings.Contains(string(raw), `"Cmd":[`), false)
+		})
+	})
+
+	when("#SetConfig", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("replaces the config, including fields without a typed setter", func() {
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.(*local.Image).SetConfig(&v1.Config{
+				Cmd:     []string{"some", "cmd"},
+				Env:     []string{"SOME_KEY=some-val"},
+				OnBuild: []string{"RUN echo some-trigger"},
+			}))
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, []string(inspect.Config.Cmd), []string{"some", "cmd"})
+			h.AssertEq(t, inspect.Config.Env, []string{"SOME_KEY=some-val"})
+			h.AssertEq(t, inspect.Config.OnBuild, []string{"RUN echo some-trigger"})
+			h.AssertEq(t, len(inspect.Config.Labels), 0)
+		})
+
+		it("returns an error when the config is nil", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertError(t, img.(*local.Image).SetConfig(nil), "config must not be nil")
+		})
+	})
+
+	when("#SetOS", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("allows noop sets for values that match the daemon", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			err = img.SetOS("fakeos")
+			h.AssertError(t, err, "invalid os: must match the daemon")
+
+			err = img.SetOS(daemonOS)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, inspect.Os, daemonOS)
+		})
+	})
+
+	when("#SetOSVersion #SetArchitecture", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("sets the os.version/arch", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			err = img.SetOSVersion("1.2.3.4")
+			h.AssertNil(t, err)
+
+			err = img.SetArchitecture("arm64")
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, inspect.OsVersion, "1.2.3.4")
+			h.AssertEq(t, inspect.Architecture, "arm64")
+		})
+	})
+
+	when("#Rebase", func() {
 		when("image exists", func() {
 			var (
 				repoName                              = newTestImageName()
@@ -902,6 +1531,41 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, afterInspect.OsVersion, beforeInspect.OsVersion)
 				h.AssertEq(t, afterInspect.Architecture, beforeInspect.Architecture)
 			})
+
+			when("the image has layers that are not in the daemon", func() {
+				it("returns an error", func() {
+					layerPath, err := h.CreateSingleFileLayerTar("/unsaved.txt", "unsaved", daemonOS)
+					h.AssertNil(t, err)
+					defer os.Remove(layerPath)
+
+					img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.AddLayer(layerPath))
+					newBaseImg, err := local.NewImage(newBase, dockerClient, local.FromBaseImage(newBase))
+					h.AssertNil(t, err)
+
+					err = img.Rebase(oldTopLayer, newBaseImg)
+					h.AssertError(t, err, fmt.Sprintf("layers of '%s' do not match the image in the daemon", repoName))
+				})
+			})
+
+			it("switches to a base from another backend", func() {
+				foreignBaseLayerPath, err := h.CreateSingleFileLayerTar("/foreign-base.txt", "foreign-base", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(foreignBaseLayerPath)
+
+				foreignBase := fakes.NewImage("foreign-base", "", nil)
+				h.AssertNil(t, foreignBase.AddLayer(foreignBaseLayerPath))
+
+				img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.Rebase(oldTopLayer, foreignBase))
+				h.AssertNil(t, img.Save())
+
+				afterInspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+				h.AssertNil(t, err)
+				h.AssertEq(t, afterInspect.RootFS.Layers, []string{h.FileDiffID(t, foreignBaseLayerPath), imgLayer1DiffID, imgLayer2DiffID})
+			})
 		})
 	})
 
@@ -919,44 +1583,267 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				)
 				h.AssertNil(t, err)
 
-				layer1Path, err := h.CreateSingleFileLayerTar("/newfile.txt", "old-base", daemonOS)
-				h.AssertNil(t, err)
-				layer2Path, err := h.CreateSingleFileLayerTar("/otherfile.txt", "text-old-base", daemonOS)
-				h.AssertNil(t, err)
+				layer1Path, err := h.CreateSingleFileLayerTar("/newfile.txt", "old-base", daemonOS)
+				h.AssertNil(t, err)
+				layer2Path, err := h.CreateSingleFileLayerTar("/otherfile.txt", "text-old-base", daemonOS)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, existingImage.AddLayer(layer1Path))
+				h.AssertNil(t, existingImage.AddLayer(layer2Path))
+
+				h.AssertNil(t, existingImage.Save())
+
+				inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+				h.AssertNil(t, err)
+				expectedTopLayer = inspect.RootFS.Layers[len(inspect.RootFS.Layers)-1]
+			})
+
+			it.After(func() {
+				h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+			})
+
+			it("returns the digest for the top layer (useful for rebasing)", func() {
+				img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+
+				actualTopLayer, err := img.TopLayer()
+				h.AssertNil(t, err)
+
+				h.AssertEq(t, actualTopLayer, expectedTopLayer)
+			})
+		})
+
+		when("image has no layers", func() {
+			it("returns error", func() {
+				img, err := local.NewImage(newTestImageName(), dockerClient)
+				h.AssertNil(t, err)
+
+				_, err = img.TopLayer()
+				h.AssertError(t, err, "has no layers")
+			})
+		})
+	})
+
+	when("#NumberOfLayers", func() {
+		it("returns the number of layers", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+
+			count, err := img.NumberOfLayers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, count, 0)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			count, err = img.NumberOfLayers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, count, 1)
+		})
+	})
+
+	when("#LayerDiffIDs", func() {
+		it("lists every layer", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), runnableBaseImageName)
+			h.AssertNil(t, err)
+
+			diffIDs, err := img.LayerDiffIDs()
+			h.AssertNil(t, err)
+			h.AssertEq(t, diffIDs, append(inspect.RootFS.Layers, h.FileDiffID(t, layerPath)))
+		})
+	})
+
+	when("#Squash", func() {
+		var (
+			repoName    = newTestImageName()
+			skipCleanup bool
+		)
+
+		it.After(func() {
+			if !skipCleanup {
+				h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+			}
+		})
+
+		it("replaces the layers with a single layer of the resulting filesystem", func() {
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			for _, layer := range []struct{ path, contents string }{
+				{"/file.txt", "file"},
+				{"/.wh.file.txt", ""},
+			} {
+				layerPath, err := h.CreateSingleFileLayerTar(layer.path, layer.contents, daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+				h.AssertNil(t, img.AddLayer(layerPath))
+			}
+
+			h.AssertNil(t, img.Squash())
+
+			count, err := img.NumberOfLayers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, count, 1)
+
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(inspect.RootFS.Layers), 1)
+
+			history, err := dockerClient.ImageHistory(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(history), 1)
+			h.AssertEq(t, history[0].CreatedBy, imgutil.SquashedCreatedBy)
+		})
+
+		it("keeps the files of the resulting filesystem, including hardlinks to files of lower layers", func() {
+			if daemonOS == "windows" {
+				skipCleanup = true
+				t.Skip("linux test")
+			}
+
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			for _, headers := range [][]*tar.Header{
+				{{Name: "/file.txt", Typeflag: tar.TypeReg}, {Name: "/target.txt", Typeflag: tar.TypeReg}},
+				{{Name: "/.wh.file.txt", Typeflag: tar.TypeReg}},
+				{{Name: "/link.txt", Typeflag: tar.TypeLink, Linkname: "/target.txt"}},
+			} {
+				layerPath := layerTarWithHeaders(t, headers...)
+				defer os.Remove(layerPath)
+				h.AssertNil(t, img.AddLayer(layerPath))
+			}
+
+			h.AssertNil(t, img.Squash())
+			h.AssertNil(t, img.Save())
+
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			rc, err := img.GetLayer(topLayer)
+			h.AssertNil(t, err)
+			defer rc.Close()
+
+			entries := map[string]string{}
+			tr := tar.NewReader(rc)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				h.AssertNil(t, err)
+				entries[hdr.Name] = hdr.Linkname
+			}
+			h.AssertEq(t, entries, map[string]string{"/target.txt": "", "/link.txt": "/target.txt"})
+		})
+	})
+
+	when("#NormalizeHistory", func() {
+		it("keeps one history entry per layer", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath, imgutil.WithCreatedBy("some-command")))
+
+			h.AssertNil(t, img.NormalizeHistory())
+
+			var configFile v1.ConfigFile
+			rawConfig, err := img.RawConfig()
+			h.AssertNil(t, err)
+			h.AssertNil(t, json.Unmarshal(rawConfig, &configFile))
+			h.AssertEq(t, len(configFile.History), len(configFile.RootFS.DiffIDs))
+			h.AssertEq(t, configFile.History[len(configFile.History)-1].CreatedBy, "some-command")
+		})
+	})
+
+	when("#Size", func() {
+		it("returns the sum of the layer and config sizes", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+
+			emptySize, err := img.Size()
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
 
-				h.AssertNil(t, existingImage.AddLayer(layer1Path))
-				h.AssertNil(t, existingImage.AddLayer(layer2Path))
+			fi, err := os.Stat(layerPath)
+			h.AssertNil(t, err)
 
-				h.AssertNil(t, existingImage.Save())
+			size, err := img.Size()
+			h.AssertNil(t, err)
+			h.AssertEq(t, size > emptySize+fi.Size()-1, true)
+		})
+	})
 
-				inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
-				h.AssertNil(t, err)
-				expectedTopLayer = inspect.RootFS.Layers[len(inspect.RootFS.Layers)-1]
-			})
+	when("#RawConfig", func() {
+		var repoName = newTestImageName()
 
-			it.After(func() {
-				h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
-			})
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
 
-			it("returns the digest for the top layer (useful for rebasing)", func() {
-				img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
-				h.AssertNil(t, err)
+		it("returns the config the image is saved with", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
 
-				actualTopLayer, err := img.TopLayer()
-				h.AssertNil(t, err)
+			rawConfig, err := img.RawConfig()
+			h.AssertNil(t, err)
 
-				h.AssertEq(t, actualTopLayer, expectedTopLayer)
-			})
+			var configFile v1.ConfigFile
+			h.AssertNil(t, json.Unmarshal(rawConfig, &configFile))
+			h.AssertEq(t, configFile.Config.Labels["mykey"], "myvalue")
+
+			h.AssertNil(t, img.Save())
+			id, err := img.Identifier()
+			h.AssertNil(t, err)
+			h.AssertEq(t, fmt.Sprintf("%x", sha256.Sum256(rawConfig)), strings.TrimPrefix(id.String(), "sha256:"))
 		})
+	})
 
-		when("image has no layers", func() {
-			it("returns error", func() {
-				img, err := local.NewImage(newTestImageName(), dockerClient)
-				h.AssertNil(t, err)
+	when("#ConfigFile", func() {
+		it("returns the config the image is saved with, unaffected by later changes", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
 
-				_, err = img.TopLayer()
-				h.AssertError(t, err, "has no layers")
-			})
+			layerPath, layerDiffID := h.SingleFileLayerTar(t, "/new-layer.txt", "new-layer", daemonOS)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			configFile, err := img.ConfigFile()
+			h.AssertNil(t, err)
+			h.AssertEq(t, configFile.OS, daemonOS)
+			h.AssertEq(t, configFile.Config.Labels["mykey"], "myvalue")
+			h.AssertEq(t, configFile.RootFS.DiffIDs[len(configFile.RootFS.DiffIDs)-1].String(), layerDiffID)
+
+			rawConfig, err := img.RawConfig()
+			h.AssertNil(t, err)
+			var rawConfigFile v1.ConfigFile
+			h.AssertNil(t, json.Unmarshal(rawConfig, &rawConfigFile))
+			h.AssertEq(t, rawConfigFile.Config, configFile.Config)
+
+			configFile.Config.Labels["mykey"] = "othervalue"
+			label, err := img.Label("mykey")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "myvalue")
 		})
 	})
 
@@ -993,6 +1880,25 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
+		when("the layer is gzipped", func() {
+			it("adds the decompressed layer", func() {
+				img, err := local.NewImage(newTestImageName(), dockerClient)
+				h.AssertNil(t, err)
+
+				layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+				gzippedPath := gzipFile(t, layerPath)
+				defer os.Remove(gzippedPath)
+
+				h.AssertNil(t, img.AddLayer(gzippedPath))
+
+				diffIDs, err := img.LayerDiffIDs()
+				h.AssertNil(t, err)
+				h.AssertEq(t, diffIDs[len(diffIDs)-1], h.FileDiffID(t, layerPath))
+			})
+		})
+
 		when("base image exists", func() {
 			var (
 				repoName      = newTestImageName()
@@ -1043,6 +1949,135 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, newLayerDiffID, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-1])
 			})
 		})
+
+		when("layer options are provided", func() {
+			it("records them in the history of the layer", func() {
+				img, err := local.NewImage(newTestImageName(), dockerClient)
+				h.AssertNil(t, err)
+
+				layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+
+				createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+				h.AssertNil(t, img.AddLayer(layerPath, imgutil.WithCreatedBy("some-command"), imgutil.WithLayerCreatedAt(createdAt)))
+
+				configFile, err := img.ConfigFile()
+				h.AssertNil(t, err)
+				history := configFile.History[len(configFile.History)-1]
+				h.AssertEq(t, history.CreatedBy, "some-command")
+				h.AssertEq(t, history.Created.UTC(), createdAt)
+			})
+
+			it("saves them in the image history", func() {
+				repoName := newTestImageName()
+
+				img, err := local.NewImage(repoName, dockerClient)
+				h.AssertNil(t, err)
+
+				// windows daemons *always* require a valid windows base layer
+				if daemonOS == "windows" {
+					windowsBaseLayer := h.WindowsBaseLayer(t)
+					h.AssertNil(t, img.AddLayer(windowsBaseLayer))
+					defer os.Remove(windowsBaseLayer)
+				}
+
+				layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+
+				h.AssertNil(t, img.AddLayer(layerPath, imgutil.WithCreatedBy("some-command")))
+				h.AssertNil(t, img.Save())
+				defer h.DockerRmi(dockerClient, repoName)
+
+				inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+				h.AssertNil(t, err)
+
+				history, err := dockerClient.ImageHistory(context.TODO(), repoName)
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(history), len(inspect.RootFS.Layers))
+				h.AssertEq(t, history[0].CreatedBy, "some-command")
+				h.AssertEq(t, history[0].Created, imgutil.NormalizedDateTime.Unix())
+			})
+		})
+	})
+
+	when("#AddLayerFromReader", func() {
+		it("appends a layer", func() {
+			repoName := newTestImageName()
+
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			// windows daemons *always* require a valid windows base layer
+			if daemonOS == "windows" {
+				windowsBaseLayer := h.WindowsBaseLayer(t)
+				h.AssertNil(t, img.AddLayer(windowsBaseLayer))
+				defer os.Remove(windowsBaseLayer)
+			}
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+
+			f, err := os.Open(layerPath)
+			h.AssertNil(t, err)
+			defer f.Close()
+
+			h.AssertNil(t, img.AddLayerFromReader(f))
+			h.AssertNil(t, img.Save())
+			defer h.DockerRmi(dockerClient, repoName)
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-1], h.FileDiffID(t, layerPath))
+		})
+	})
+
+	when("#AddLayers", func() {
+		it("appends the layers in order", func() {
+			repoName := newTestImageName()
+
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			var (
+				paths   []string
+				diffIDs []string
+			)
+			for idx := 0; idx < 5; idx++ {
+				layerPath, layerDiffID := h.SingleFileLayerTar(t, fmt.Sprintf("/layer-%d.txt", idx), fmt.Sprintf("layer-%d", idx), daemonOS)
+				defer os.Remove(layerPath)
+				paths = append(paths, layerPath)
+				diffIDs = append(diffIDs, layerDiffID)
+			}
+			gzippedPath := gzipFile(t, paths[2])
+			defer os.Remove(gzippedPath)
+			paths[2] = gzippedPath
+
+			h.AssertNil(t, img.(*local.Image).AddLayers(paths...))
+			h.AssertNil(t, img.Save())
+			defer h.DockerRmi(dockerClient, repoName)
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-len(diffIDs):], diffIDs)
+		})
+
+		it("adds no layers when a layer cannot be read", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+
+			layerPath, _ := h.SingleFileLayerTar(t, "/layer.txt", "layer", daemonOS)
+			defer os.Remove(layerPath)
+
+			err = img.(*local.Image).AddLayers(layerPath, "/does-not-exist.tar")
+			h.AssertError(t, err, "open layer: /does-not-exist.tar")
+
+			numLayers, err := img.NumberOfLayers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, numLayers, 0)
+		})
 	})
 
 	when("#AddLayerWithDiffID", func() {
@@ -1098,6 +2133,84 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 			h.AssertEq(t, oldLayerDiffID, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-2])
 			h.AssertEq(t, newLayerDiffID, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-1])
 		})
+
+		when("the layer is gzipped", func() {
+			it("returns an error", func() {
+				img, err := local.NewImage(newTestImageName(), dockerClient)
+				h.AssertNil(t, err)
+
+				layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+				gzippedPath := gzipFile(t, layerPath)
+				defer os.Remove(gzippedPath)
+
+				err = img.AddLayerWithDiffID(gzippedPath, h.FileDiffID(t, gzippedPath))
+				h.AssertError(t, err, fmt.Sprintf("layer '%s' is gzip compressed", gzippedPath))
+			})
+		})
+
+		when("#WithDiffIDValidation", func() {
+			it("accepts a matching diff ID", func() {
+				img, err := local.NewImage(newTestImageName(), dockerClient, local.WithDiffIDValidation())
+				h.AssertNil(t, err)
+
+				layerPath, layerDiffID := h.SingleFileLayerTar(t, "/new-layer.txt", "new-layer", daemonOS)
+				defer os.Remove(layerPath)
+
+				h.AssertNil(t, img.AddLayerWithDiffID(layerPath, layerDiffID))
+
+				topLayer, err := img.TopLayer()
+				h.AssertNil(t, err)
+				h.AssertEq(t, topLayer, layerDiffID)
+			})
+
+			it("returns an error for a diff ID that does not match the layer", func() {
+				img, err := local.NewImage(newTestImageName(), dockerClient, local.WithDiffIDValidation())
+				h.AssertNil(t, err)
+
+				layerPath, layerDiffID := h.SingleFileLayerTar(t, "/new-layer.txt", "new-layer", daemonOS)
+				defer os.Remove(layerPath)
+				otherLayerPath, otherLayerDiffID := h.SingleFileLayerTar(t, "/other-layer.txt", "other-layer", daemonOS)
+				defer os.Remove(otherLayerPath)
+
+				err = img.AddLayerWithDiffID(layerPath, otherLayerDiffID)
+				h.AssertError(t, err, fmt.Sprintf("layer '%s' has diff ID '%s', not '%s'", layerPath, layerDiffID, otherLayerDiffID))
+			})
+		})
+	})
+
+	when("#AddLayerFromImage", func() {
+		it("adds the layer from an image of another backend", func() {
+			repoName := newTestImageName()
+			layerPath, layerDiffID := h.SingleFileLayerTar(t, "/layer.txt", "transplanted", daemonOS)
+			defer os.Remove(layerPath)
+
+			src := fakes.NewImage("some-image", "", nil)
+			h.AssertNil(t, src.AddLayer(layerPath))
+
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.AddLayerFromImage(src, layerDiffID))
+			h.AssertNil(t, img.Save())
+			defer h.DockerRmi(dockerClient, repoName)
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-1], layerDiffID)
+		})
+
+		it("returns an error when the layer does not match the diff ID", func() {
+			layerPath, _ := h.SingleFileLayerTar(t, "/layer.txt", "transplanted", daemonOS)
+			defer os.Remove(layerPath)
+
+			src := fakes.NewImage("some-image", "", nil)
+			h.AssertNil(t, src.AddLayerWithDiffID(layerPath, "sha256:not-the-diff-id"))
+
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+			h.AssertError(t, img.AddLayerFromImage(src, "sha256:not-the-diff-id"), "layer 'sha256:not-the-diff-id' from image 'some-image' has diff ID")
+		})
 	})
 
 	when("#GetLayer", func() {
@@ -1152,6 +2265,18 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 					}
 					h.AssertEq(t, string(contents), "file-contents")
 				})
+
+				it("accepts a diff ID without the sha256 prefix", func() {
+					img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+					h.AssertNil(t, err)
+
+					topLayer, err := img.TopLayer()
+					h.AssertNil(t, err)
+
+					r, err := img.GetLayer(strings.TrimPrefix(topLayer, "sha256:"))
+					h.AssertNil(t, err)
+					h.AssertNil(t, r.Close())
+				})
 			})
 
 			when("the layer does not exist", func() {
@@ -1169,15 +2294,116 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
-		when("image does NOT exist", func() {
-			it("returns error", func() {
-				image, err := local.NewImage(newTestImageName(), dockerClient)
-				h.AssertNil(t, err)
+		when("image does NOT exist", func() {
+			it("returns error", func() {
+				image, err := local.NewImage(newTestImageName(), dockerClient)
+				h.AssertNil(t, err)
+
+				readCloser, err := image.GetLayer(h.RandString(10))
+				h.AssertNil(t, readCloser)
+				h.AssertError(t, err, "No such image")
+				h.AssertEq(t, errors.Is(err, imgutil.ErrImageNotFound), true)
+			})
+		})
+	})
+
+	when("#GetCompressedLayer", func() {
+		it("returns the gzipped layer tar", func() {
+			repoName := newTestImageName()
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			layerPath, layerDiffID := h.SingleFileLayerTar(t, "/file.txt", "file-contents", daemonOS)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+			h.AssertNil(t, img.Save())
+			defer h.DockerRmi(dockerClient, repoName)
+
+			img, err = local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			rc, err := img.GetCompressedLayer(layerDiffID)
+			h.AssertNil(t, err)
+			defer rc.Close()
+
+			gzr, err := gzip.NewReader(rc)
+			h.AssertNil(t, err)
+			uncompressed, err := ioutil.ReadAll(gzr)
+			h.AssertNil(t, err)
+
+			expected, err := ioutil.ReadFile(layerPath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, uncompressed, expected)
+		})
+	})
+
+	when("#GetLayerByDigest", func() {
+		it("returns the uncompressed layer with the compressed digest", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			layer, err := tarball.LayerFromFile(layerPath)
+			h.AssertNil(t, err)
+			digest, err := layer.Digest()
+			h.AssertNil(t, err)
+
+			rc, err := img.(*local.Image).GetLayerByDigest(digest.String())
+			h.AssertNil(t, err)
+			defer rc.Close()
+			contents, err := ioutil.ReadAll(rc)
+			h.AssertNil(t, err)
+
+			expected, err := ioutil.ReadFile(layerPath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, contents, expected)
+		})
+
+		it("returns an error for unknown digests", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			digest := "sha256:" + strings.Repeat("0", 64)
+			_, err = img.(*local.Image).GetLayerByDigest(digest)
+			h.AssertError(t, err, fmt.Sprintf("does not contain layer with digest '%s'", digest))
+		})
+	})
+
+	when("#Close", func() {
+		var repoName = newTestImageName()
+
+		it.Before(func() {
+			existingImage, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+			h.AssertNil(t, existingImage.Save())
+		})
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("removes downloaded layers and makes later layer reads and saves return an error", func() {
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
 
-				readCloser, err := image.GetLayer(h.RandString(10))
-				h.AssertNil(t, readCloser)
-				h.AssertError(t, err, "No such image")
-			})
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+
+			r, err := img.GetLayer(topLayer)
+			h.AssertNil(t, err)
+			h.AssertNil(t, r.Close())
+
+			h.AssertNil(t, img.(*local.Image).Close())
+
+			_, err = img.GetLayer(topLayer)
+			h.AssertError(t, err, fmt.Sprintf("image '%s' is closed", repoName))
+			h.AssertError(t, img.Save(), fmt.Sprintf("image '%s' is closed", repoName))
+
+			h.AssertNil(t, img.(*local.Image).Close())
 		})
 	})
 
@@ -1250,6 +2476,55 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 			h.AssertEq(t, prevLayer2SHA, reusedLayer2SHA)
 		})
 
+		it("reuses a layer given by its diff ID with or without the algorithm prefix", func() {
+			img, err := local.NewImage(
+				repoName,
+				dockerClient,
+				local.WithPreviousImage(prevName),
+				local.FromBaseImage(runnableBaseImageName),
+			)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.ReuseLayer(strings.TrimPrefix(prevLayer1SHA, "sha256:")))
+			h.AssertNil(t, img.ReuseLayer(prevLayer2SHA))
+
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-2:], []string{prevLayer1SHA, prevLayer2SHA})
+		})
+
+		it("reuses a layer after layers were read from the image itself", func() {
+			existingImage, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+			h.AssertNil(t, existingImage.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+
+			img, err := local.NewImage(
+				repoName,
+				dockerClient,
+				local.WithPreviousImage(prevName),
+				local.FromBaseImage(runnableBaseImageName),
+			)
+			h.AssertNil(t, err)
+
+			rc, err := img.GetLayer(inspect.RootFS.Layers[0])
+			h.AssertNil(t, err)
+			h.AssertNil(t, rc.Close())
+
+			h.AssertNil(t, img.ReuseLayer(prevLayer2SHA))
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err = dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-1], prevLayer2SHA)
+		})
+
 		it("does not download the old image if layers are directly above (performance)", func() {
 			img, err := local.NewImage(
 				repoName,
@@ -1385,7 +2660,7 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 						h.AssertEq(t, ok, true)
 						h.AssertEq(t, len(saveErr.Errors), 1)
 						h.AssertEq(t, saveErr.Errors[0].ImageName, failingName)
-						h.AssertError(t, saveErr.Errors[0].Cause, "invalid reference format")
+						h.AssertError(t, saveErr.Errors[0].Cause, "tag can only contain the runes")
 
 						for _, n := range successfulRepoNames {
 							_, _, err = dockerClient.ImageInspectWithRaw(context.TODO(), n)
@@ -1396,6 +2671,119 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
+		when("#WithSaveProgress", func() {
+			it("reports the cumulative bytes written", func() {
+				repoName := newTestImageName()
+
+				var reported []int64
+				img, err := local.NewImage(repoName, dockerClient, local.WithSaveProgress(func(bytesWritten int64) {
+					reported = append(reported, bytesWritten)
+				}))
+				h.AssertNil(t, err)
+
+				// windows daemons *always* require a valid windows base layer
+				if daemonOS == "windows" {
+					windowsBaseLayer := h.WindowsBaseLayer(t)
+					h.AssertNil(t, img.AddLayer(windowsBaseLayer))
+					defer os.Remove(windowsBaseLayer)
+				}
+
+				layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+				h.AssertNil(t, img.AddLayer(layerPath))
+
+				h.AssertNil(t, img.Save())
+				defer h.DockerRmi(dockerClient, repoName)
+
+				fi, err := os.Stat(layerPath)
+				h.AssertNil(t, err)
+
+				h.AssertEq(t, len(reported) > 0, true)
+				for idx := 1; idx < len(reported); idx++ {
+					h.AssertEq(t, reported[idx] > reported[idx-1], true)
+				}
+				h.AssertEq(t, reported[len(reported)-1] >= fi.Size(), true)
+			})
+		})
+
+		when("#SaveCtx", func() {
+			it("returns an error when the context is cancelled", func() {
+				repoName := newTestImageName()
+
+				img, err := local.NewImage(repoName, dockerClient)
+				h.AssertNil(t, err)
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				err = img.(*local.Image).SaveCtx(ctx)
+				h.AssertError(t, err, fmt.Sprintf("failed to write image to the following tags: [%s:", repoName))
+				h.AssertError(t, err, context.Canceled.Error())
+			})
+
+			it("can save the image again after the context is cancelled", func() {
+				prevName := newTestImageName()
+				repoName := newTestImageName()
+
+				prevImage, err := local.NewImage(prevName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+				h.AssertNil(t, err)
+
+				layerPath, err := h.CreateSingleFileLayerTar("/layer.txt", "old-layer", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+
+				h.AssertNil(t, prevImage.AddLayer(layerPath))
+				h.AssertNil(t, prevImage.Save())
+				defer h.DockerRmi(dockerClient, repoName, prevName)
+
+				prevInspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), prevName)
+				h.AssertNil(t, err)
+				prevLayerSHA := prevInspect.RootFS.Layers[len(prevInspect.RootFS.Layers)-1]
+
+				img, err := local.NewImage(
+					repoName,
+					dockerClient,
+					local.WithPreviousImage(prevName),
+					local.FromBaseImage(runnableBaseImageName),
+				)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.ReuseLayer(prevLayerSHA))
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				h.AssertError(t, img.(*local.Image).SaveCtx(ctx), context.Canceled.Error())
+
+				h.AssertNil(t, img.Save())
+
+				inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+				h.AssertNil(t, err)
+				h.AssertEq(t, inspect.RootFS.Layers[len(inspect.RootFS.Layers)-1], prevLayerSHA)
+			})
+		})
+
+		when("#WithOperationTimeout", func() {
+			it("returns an error when the daemon does not respond in time", func() {
+				repoName := newTestImageName()
+
+				img, err := local.NewImage(
+					repoName,
+					&unresponsiveLoadDocker{CommonAPIClient: dockerClient},
+					local.WithOperationTimeout(100*time.Millisecond),
+				)
+				h.AssertNil(t, err)
+
+				err = img.Save()
+				h.AssertError(t, err, fmt.Sprintf("failed to write image to the following tags: [%s:", repoName))
+				h.AssertError(t, err, context.DeadlineExceeded.Error())
+			})
+
+			it("returns an error for a negative timeout", func() {
+				_, err := local.NewImage(newTestImageName(), dockerClient, local.WithOperationTimeout(-time.Second))
+				h.AssertError(t, err, "operation timeout must not be negative, got -1s")
+			})
+		})
+
 		when("invalid image content for daemon", func() {
 			it("returns errors from daemon", func() {
 				repoName := newTestImageName()
@@ -1418,6 +2806,210 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertError(t, err, "daemon response")
 			})
 		})
+
+		when("a layer file is missing", func() {
+			it("returns an error before loading the image", func() {
+				repoName := newTestImageName()
+
+				img, err := local.NewImage(repoName, dockerClient)
+				h.AssertNil(t, err)
+
+				layerPath, layerDiffID := h.SingleFileLayerTar(t, "/file.txt", "contents", daemonOS)
+				h.AssertNil(t, img.AddLayer(layerPath))
+				h.AssertNil(t, os.Remove(layerPath))
+
+				err = img.Save()
+				h.AssertError(t, err, fmt.Sprintf("layer file missing for layer '%s'", layerDiffID))
+				h.AssertError(t, err, layerPath)
+				h.AssertEq(t, img.Found(), false)
+			})
+		})
+	})
+
+	when("#SaveToWriter", func() {
+		it("writes a complete docker save archive without loading it", func() {
+			repoName := newTestImageName()
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			archive, err := ioutil.TempFile("", "local-docker-archive")
+			h.AssertNil(t, err)
+			defer os.Remove(archive.Name())
+			defer archive.Close()
+
+			h.AssertNil(t, img.(*local.Image).SaveToWriter(archive))
+
+			tag, err := name.NewTag(repoName, name.WeakValidation)
+			h.AssertNil(t, err)
+			archiveImage, err := tarball.ImageFromPath(archive.Name(), &tag)
+			h.AssertNil(t, err)
+
+			configFile, err := archiveImage.ConfigFile()
+			h.AssertNil(t, err)
+			h.AssertEq(t, configFile.Config.Labels["mykey"], "myvalue")
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), runnableBaseImageName)
+			h.AssertNil(t, err)
+
+			layers, err := archiveImage.Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(layers), len(inspect.RootFS.Layers)+1)
+			for _, layer := range layers {
+				_, err := layer.Digest()
+				h.AssertNil(t, err)
+			}
+
+			_, _, err = dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertEq(t, client.IsErrNotFound(err), true)
+		})
+	})
+
+	when("#SaveToOCI", func() {
+		it("writes an OCI image layout", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			layoutDir, err := ioutil.TempDir("", "local-oci-layout")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(layoutDir)
+
+			h.AssertNil(t, img.(*local.Image).SaveToOCI(layoutDir))
+
+			index, err := layout.ImageIndexFromPath(layoutDir)
+			h.AssertNil(t, err)
+			indexManifest, err := index.IndexManifest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(indexManifest.Manifests), 1)
+
+			ociImage, err := index.Image(indexManifest.Manifests[0].Digest)
+			h.AssertNil(t, err)
+
+			configFile, err := ociImage.ConfigFile()
+			h.AssertNil(t, err)
+			h.AssertEq(t, configFile.Config.Labels["mykey"], "myvalue")
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), runnableBaseImageName)
+			h.AssertNil(t, err)
+
+			layers, err := ociImage.Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(layers), len(inspect.RootFS.Layers)+1)
+
+			topDiffID, err := layers[len(layers)-1].DiffID()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topDiffID.String(), h.FileDiffID(t, layerPath))
+		})
+	})
+
+	when("#SaveToOCIArchive", func() {
+		it("writes an OCI image layout to a single tar", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			archive, err := ioutil.TempFile("", "local-oci-archive")
+			h.AssertNil(t, err)
+			h.AssertNil(t, archive.Close())
+			defer os.Remove(archive.Name())
+
+			h.AssertNil(t, img.(*local.Image).SaveToOCIArchive(archive.Name()))
+
+			f, err := os.Open(archive.Name())
+			h.AssertNil(t, err)
+			defer f.Close()
+
+			archiveDir, err := ioutil.TempDir("", "local-oci-archive-layout")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(archiveDir)
+
+			tr := tar.NewReader(f)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				h.AssertNil(t, err)
+				h.AssertEq(t, hdr.ModTime.Equal(imgutil.NormalizedDateTime), true)
+
+				target := filepath.Join(archiveDir, filepath.FromSlash(hdr.Name))
+				if hdr.Typeflag == tar.TypeDir {
+					h.AssertNil(t, os.MkdirAll(target, 0755))
+					continue
+				}
+				contents, err := ioutil.ReadAll(tr)
+				h.AssertNil(t, err)
+				h.AssertNil(t, ioutil.WriteFile(target, contents, 0644))
+			}
+
+			layoutDir, err := ioutil.TempDir("", "local-oci-layout")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(layoutDir)
+			h.AssertNil(t, img.(*local.Image).SaveToOCI(layoutDir))
+
+			archiveImage := ociLayoutImage(t, archiveDir)
+			layoutImage := ociLayoutImage(t, layoutDir)
+
+			archiveManifest, err := archiveImage.RawManifest()
+			h.AssertNil(t, err)
+			layoutManifest, err := layoutImage.RawManifest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(archiveManifest), string(layoutManifest))
+
+			archiveConfig, err := archiveImage.RawConfigFile()
+			h.AssertNil(t, err)
+			layoutConfig, err := layoutImage.RawConfigFile()
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(archiveConfig), string(layoutConfig))
+
+			layers, err := archiveImage.Layers()
+			h.AssertNil(t, err)
+			topDiffID, err := layers[len(layers)-1].DiffID()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topDiffID.String(), h.FileDiffID(t, layerPath))
+		})
+	})
+
+	when("#CopyToRegistry", func() {
+		it("pushes the image to the registry", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			repoName := newTestImageName()
+			digest, err := img.(*local.Image).CopyToRegistry(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertEq(t, strings.HasPrefix(digest, "sha256:"), true)
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName+"@"+digest)
+			h.AssertEq(t, configFile.Config.Labels["mykey"], "myvalue")
+
+			layers := h.FetchManifestLayers(t, repoName)
+			h.AssertEq(t, layers[len(layers)-1], h.FileDiffID(t, layerPath))
+		})
 	})
 
 	when("#Found", func() {
@@ -1516,3 +3108,112 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 }
+
+// layerTarWithHeaders writes a layer tar holding an empty entry for each of headers, and returns its path
+func layerTarWithHeaders(t *testing.T, headers ...*tar.Header) string {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "layer-tar-with-headers")
+	h.AssertNil(t, err)
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, hdr := range headers {
+		hdr.Mode = 0644
+		h.AssertNil(t, tw.WriteHeader(hdr))
+	}
+	h.AssertNil(t, tw.Close())
+	return f.Name()
+}
+
+// unresponsiveLoadDocker never responds to image loads, until the context of the load is done
+type unresponsiveLoadDocker struct {
+	client.CommonAPIClient
+}
+
+func (d *unresponsiveLoadDocker) ImageLoad(ctx context.Context, _ io.Reader, _ bool) (types.ImageLoadResponse, error) {
+	<-ctx.Done()
+	return types.ImageLoadResponse{}, ctx.Err()
+}
+
+// gzipFile writes a gzipped copy of the file at path and returns the path to the copy
+func gzipFile(t *testing.T, path string) string {
+	t.Helper()
+
+	src, err := os.Open(path)
+	h.AssertNil(t, err)
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	h.AssertNil(t, err)
+	defer dst.Close()
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	h.AssertNil(t, err)
+	h.AssertNil(t, gz.Close())
+	return dst.Name()
+}
+
+// ociLayoutImage returns the only image in the OCI image layout at dir
+func ociLayoutImage(t *testing.T, dir string) v1.Image {
+	t.Helper()
+
+	index, err := layout.ImageIndexFromPath(dir)
+	h.AssertNil(t, err)
+	indexManifest, err := index.IndexManifest()
+	h.AssertNil(t, err)
+	h.AssertEq(t, len(indexManifest.Manifests), 1)
+
+	image, err := index.Image(indexManifest.Manifests[0].Digest)
+	h.AssertNil(t, err)
+	return image
+}
+
+// BenchmarkAddLayers compares adding layers one at a time with AddLayer to hashing them concurrently with AddLayers
+func BenchmarkAddLayers(b *testing.B) {
+	dockerClient, err := local.NewDockerClient()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var paths []string
+	for idx := 0; idx < 16; idx++ {
+		layerPath, err := h.CreateSingleFileLayerTar("/layer.txt", strings.Repeat(strconv.Itoa(idx), 16*1024*1024), "linux")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer os.Remove(layerPath)
+		paths = append(paths, layerPath)
+	}
+
+	b.Run("AddLayer", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			b.StopTimer()
+			img, err := local.NewImage("benchmark-add-layers", dockerClient)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			for _, path := range paths {
+				if err := img.AddLayer(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("AddLayers", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			b.StopTimer()
+			img, err := local.NewImage("benchmark-add-layers", dockerClient)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			if err := img.(*local.Image).AddLayers(paths...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,554 @@
+package local
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/internal/daemon"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestTar(t *testing.T) {
+	spec.Run(t, "tar", testTar, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testTar(t *testing.T, when spec.G, it spec.S) {
+	when("#addTextToTar #addFileToTar", func() {
+		it("writes identical archives for identical inputs", func() {
+			f, err := ioutil.TempFile("", "add-file-to-tar")
+			h.AssertNil(t, err)
+			defer os.Remove(f.Name())
+			defer f.Close()
+			_, err = f.Write([]byte("some-layer"))
+			h.AssertNil(t, err)
+
+			var archives [][]byte
+			for n := 0; n < 2; n++ {
+				_, err = f.Seek(0, io.SeekStart)
+				h.AssertNil(t, err)
+
+				buf := &bytes.Buffer{}
+				tw := tar.NewWriter(buf)
+				h.AssertNil(t, daemon.AddTextToTar(tw, "some-config.json", []byte("{}")))
+				h.AssertNil(t, addFileToTar(tw, "some-layer.tar", f, nil))
+				h.AssertNil(t, tw.Close())
+				archives = append(archives, buf.Bytes())
+			}
+			h.AssertEq(t, bytes.Equal(archives[0], archives[1]), true)
+
+			tr := tar.NewReader(bytes.NewReader(archives[0]))
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				h.AssertNil(t, err)
+				h.AssertEq(t, hdr.Typeflag, byte(tar.TypeReg))
+				h.AssertEq(t, hdr.ModTime.Equal(imgutil.NormalizedDateTime), true)
+			}
+		})
+	})
+
+	when("#downloadImage", func() {
+		var docker *saveOnlyDocker
+
+		it.Before(func() {
+			docker = &saveOnlyDocker{archive: archiveWithFiles(t, map[string]string{
+				"layer-1/layer.tar": "layer-1-contents",
+				"config.json":       `{"rootfs": {"diff_ids": ["sha256:layer-1", "sha256:layer-2"]}}`,
+				"manifest.json":     `[{"Config": "config.json", "Layers": ["layer-1/layer.tar", "layer-2/layer.tar"]}]`,
+			}, &tar.Header{Name: "layer-2/layer.tar", Typeflag: tar.TypeSymlink, Linkname: "../layer-1/layer.tar"})}
+		})
+
+		it("announces each export on stdout", func() {
+			stdout := &bytes.Buffer{}
+			fsimg, err := downloadImage(docker, "some-image", stdout)
+			h.AssertNil(t, err)
+			defer os.RemoveAll(fsimg.dir)
+
+			_, err = fsimg.layerFile("layer-1/layer.tar")
+			h.AssertNil(t, err)
+			h.AssertEq(t, stdout.String(), strings.Repeat("Exporting image 'some-image' from the daemon\n", 2))
+		})
+
+		it("only extracts layers once they are requested", func() {
+			fsimg, err := downloadImage(docker, "some-image", nil)
+			h.AssertNil(t, err)
+			defer os.RemoveAll(fsimg.dir)
+
+			h.AssertEq(t, fsimg.layersMap, map[string]string{"sha256:layer-1": "layer-1/layer.tar", "sha256:layer-2": "layer-2/layer.tar"})
+			_, err = os.Stat(filepath.Join(fsimg.dir, "layer-1", "layer.tar"))
+			h.AssertEq(t, os.IsNotExist(err), true)
+
+			layerPath, err := fsimg.layerFile("layer-2/layer.tar")
+			h.AssertNil(t, err)
+			h.AssertEq(t, layerPath, filepath.Join(fsimg.dir, "layer-1", "layer.tar"))
+
+			contents, err := ioutil.ReadFile(layerPath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "layer-1-contents")
+			h.AssertEq(t, docker.saves, 2)
+		})
+
+		it("extracts all the requested layers with a single export of the image ID", func() {
+			docker.archive = archiveWithFiles(t, map[string]string{
+				"layer-1/layer.tar": "layer-1-contents",
+				"layer-2/layer.tar": "layer-2-contents",
+				"config.json":       `{"rootfs": {"diff_ids": ["sha256:layer-1", "sha256:layer-2"]}}`,
+				"manifest.json":     `[{"Config": "config.json", "Layers": ["layer-1/layer.tar", "layer-2/layer.tar"]}]`,
+			})
+			fsimg, err := downloadImage(docker, "some-image", nil)
+			h.AssertNil(t, err)
+			defer os.RemoveAll(fsimg.dir)
+
+			layerPaths, err := fsimg.layerFiles("layer-1/layer.tar", "layer-2/layer.tar")
+			h.AssertNil(t, err)
+			for idx, layerPath := range layerPaths {
+				contents, err := ioutil.ReadFile(layerPath)
+				h.AssertNil(t, err)
+				h.AssertEq(t, string(contents), fmt.Sprintf("layer-%d-contents", idx+1))
+			}
+			h.AssertEq(t, docker.savedNames, []string{"sha256:some-image-id", "sha256:some-image-id"})
+		})
+	})
+
+	when("#layerPath", func() {
+		var docker *daemonImagesDocker
+
+		it.Before(func() {
+			docker = &daemonImagesDocker{
+				inspects: map[string]types.ImageInspect{
+					"some-base-image":  {ID: "sha256:base-id", RootFS: types.RootFS{Layers: []string{"sha256:base-layer"}}},
+					"some-image":       {ID: "sha256:prev-id", RootFS: types.RootFS{Layers: []string{"sha256:base-layer", "sha256:app-layer"}}},
+					"some-other-image": {ID: "sha256:other-id", RootFS: types.RootFS{Layers: []string{"sha256:app-layer"}}},
+				},
+				archives: map[string][]byte{
+					"sha256:base-id": archiveWithFiles(t, map[string]string{
+						"base-layer/layer.tar": "base-layer-contents",
+						"config.json":          `{"rootfs": {"diff_ids": ["sha256:base-layer"]}}`,
+						"manifest.json":        `[{"Config": "config.json", "Layers": ["base-layer/layer.tar"]}]`,
+					}),
+					"sha256:prev-id": archiveWithFiles(t, map[string]string{
+						"base-layer/layer.tar": "base-layer-contents",
+						"app-layer/layer.tar":  "app-layer-contents",
+						"config.json":          `{"rootfs": {"diff_ids": ["sha256:base-layer", "sha256:app-layer"]}}`,
+						"manifest.json":        `[{"Config": "config.json", "Layers": ["base-layer/layer.tar", "app-layer/layer.tar"]}]`,
+					}),
+					"sha256:other-id": archiveWithFiles(t, map[string]string{
+						"app-layer/layer.tar": "app-layer-contents",
+						"config.json":         `{"rootfs": {"diff_ids": ["sha256:app-layer"]}}`,
+						"manifest.json":       `[{"Config": "config.json", "Layers": ["app-layer/layer.tar"]}]`,
+					}),
+				},
+			}
+		})
+
+		assertLayerContents := func(img *Image, idx int, expected string) {
+			t.Helper()
+			layerPath, err := img.layerPath(idx)
+			h.AssertNil(t, err)
+			contents, err := ioutil.ReadFile(layerPath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), expected)
+		}
+
+		it("reads base layers from the base image and easily reused layers from the image being replaced", func() {
+			image, err := NewImage("some-image", docker, FromBaseImage("some-base-image"))
+			h.AssertNil(t, err)
+			img := image.(*Image)
+			defer img.Close()
+			img.Rename("some-image")
+			h.AssertNil(t, img.ReuseLayer("sha256:app-layer"))
+
+			assertLayerContents(img, 0, "base-layer-contents")
+			assertLayerContents(img, 1, "app-layer-contents")
+		})
+
+		it("reads easily reused layers of an image without a base", func() {
+			img := &Image{
+				repoName:  "some-image",
+				docker:    docker,
+				inspect:   types.ImageInspect{Config: &container.Config{}},
+				downloads: map[string]*FileSystemLocalImage{},
+			}
+			defer img.Close()
+			img.Rename("some-other-image")
+			h.AssertNil(t, img.ReuseLayer("sha256:app-layer"))
+
+			assertLayerContents(img, 0, "app-layer-contents")
+		})
+	})
+
+	when("#SaveToWriter", func() {
+		it("round trips the os and architecture of a windows/amd64 image", func() {
+			img := &Image{
+				repoName:  "some-image",
+				inspect:   types.ImageInspect{Os: "windows", Architecture: "amd64", Config: &container.Config{}},
+				createdAt: imgutil.NormalizedDateTime,
+			}
+
+			f, err := ioutil.TempFile("", "save-to-writer")
+			h.AssertNil(t, err)
+			defer os.Remove(f.Name())
+			h.AssertNil(t, img.SaveToWriter(f))
+			h.AssertNil(t, f.Close())
+
+			saved, err := tarball.ImageFromPath(f.Name(), nil)
+			h.AssertNil(t, err)
+			configFile, err := saved.ConfigFile()
+			h.AssertNil(t, err)
+			h.AssertEq(t, configFile.OS, "windows")
+			h.AssertEq(t, configFile.Architecture, "amd64")
+		})
+	})
+
+	when("#config", func() {
+		it("returns a not found error from getters and setters when the image has no config", func() {
+			img := &Image{repoName: "some-image"}
+
+			_, err := img.Labels()
+			h.AssertEq(t, errors.Is(err, imgutil.ErrImageNotFound), true)
+			h.AssertEq(t, errors.Is(img.SetLabel("some-key", "some-val"), imgutil.ErrImageNotFound), true)
+			h.AssertEq(t, errors.Is(img.SetEnv("some-key", "some-val"), imgutil.ErrImageNotFound), true)
+			h.AssertEq(t, errors.Is(img.SetCmd("some-cmd"), imgutil.ErrImageNotFound), true)
+		})
+	})
+
+	when("#Close", func() {
+		it("makes later calls that read or write layer files return an error", func() {
+			img := &Image{
+				repoName:  "some-image",
+				inspect:   types.ImageInspect{Config: &container.Config{}},
+				downloads: map[string]*FileSystemLocalImage{},
+				createdAt: imgutil.NormalizedDateTime,
+			}
+			h.AssertNil(t, img.Close())
+
+			h.AssertError(t, img.Save(), "image 'some-image' is closed")
+			_, err := img.GetLayer("sha256:some-layer")
+			h.AssertError(t, err, "image 'some-image' is closed")
+			h.AssertError(t, img.AddLayerFromReader(strings.NewReader("some-layer")), "image 'some-image' is closed")
+		})
+	})
+
+	when("#SaveCtx", func() {
+		it("removes the files of the previous image when the context is cancelled", func() {
+			docker := &cancelledLoadDocker{saveOnlyDocker: &saveOnlyDocker{archive: archiveWithFiles(t, map[string]string{
+				"config.json":   `{"rootfs": {"diff_ids": []}}`,
+				"manifest.json": `[{"Config": "config.json", "Layers": []}]`,
+			})}}
+			img := &Image{
+				repoName:  "some-image",
+				docker:    docker,
+				inspect:   types.ImageInspect{Config: &container.Config{}},
+				downloads: map[string]*FileSystemLocalImage{},
+				prevName:  "some-previous-image",
+				createdAt: imgutil.NormalizedDateTime,
+			}
+			prevImage, err := img.downloadImageOnce("some-previous-image")
+			h.AssertNil(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err = img.SaveCtx(ctx)
+			h.AssertError(t, err, context.Canceled.Error())
+
+			_, err = os.Stat(prevImage.dir)
+			h.AssertEq(t, os.IsNotExist(err), true)
+		})
+		it("can save the image again after the context is cancelled", func() {
+			layerPath, err := h.CreateSingleFileLayerTar("/some-file.txt", "some-contents", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			layerContents, err := ioutil.ReadFile(layerPath)
+			h.AssertNil(t, err)
+			diffID := h.FileDiffID(t, layerPath)
+
+			docker := &cancellableLoadDocker{saveOnlyDocker: &saveOnlyDocker{archive: archiveWithFiles(t, map[string]string{
+				"layer-1/layer.tar": string(layerContents),
+				"config.json":       fmt.Sprintf(`{"rootfs": {"diff_ids": [%q]}}`, diffID),
+				"manifest.json":     `[{"Config": "config.json", "Layers": ["layer-1/layer.tar"]}]`,
+			})}}
+			img := &Image{
+				repoName:  "some-image",
+				docker:    docker,
+				inspect:   types.ImageInspect{Config: &container.Config{}},
+				downloads: map[string]*FileSystemLocalImage{},
+				prevName:  "some-previous-image",
+				createdAt: imgutil.NormalizedDateTime,
+			}
+			h.AssertNil(t, img.ReuseLayer(diffID))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err = img.SaveCtx(ctx)
+			h.AssertError(t, err, context.Canceled.Error())
+
+			h.AssertNil(t, img.Save())
+			h.AssertEq(t, docker.loads, 1)
+
+			rc, err := img.GetLayer(diffID)
+			h.AssertNil(t, err)
+			defer rc.Close()
+			contents, err := ioutil.ReadAll(rc)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), string(layerContents))
+		})
+	})
+
+	when("#WithOperationTimeout", func() {
+		it("bounds reading the base image given before it", func() {
+			_, err := NewImage(
+				"some-image",
+				&unresponsiveInspectDocker{},
+				FromBaseImage("some-base-image"),
+				WithOperationTimeout(10*time.Millisecond),
+			)
+			h.AssertError(t, err, context.DeadlineExceeded.Error())
+		})
+
+		it("bounds reading the history of the base image", func() {
+			_, err := NewImage(
+				"some-image",
+				&unresponsiveHistoryDocker{},
+				FromBaseImage("some-base-image"),
+				WithOperationTimeout(10*time.Millisecond),
+			)
+			h.AssertError(t, err, context.DeadlineExceeded.Error())
+		})
+	})
+
+	when("#imageHistory", func() {
+		var inspect types.ImageInspect
+
+		it.Before(func() {
+			inspect = types.ImageInspect{ID: "sha256:some-id", RootFS: types.RootFS{Layers: []string{"sha256:layer-1", "sha256:layer-2"}}}
+		})
+
+		it("returns the history oldest entry first, marking entries without a size as empty layers", func() {
+			docker := &daemonImagesDocker{histories: map[string][]image.HistoryResponseItem{
+				"sha256:some-id": {
+					{CreatedBy: "some-cmd", Created: 3},
+					{CreatedBy: "some-layer-2", Created: 2, Size: 20},
+					{CreatedBy: "some-layer-1", Created: 1, Size: 10, Comment: "some-comment"},
+				},
+			}}
+
+			history, err := imageHistory(docker, inspect)
+			h.AssertNil(t, err)
+			h.AssertEq(t, history, []v1.History{
+				{CreatedBy: "some-layer-1", Created: v1.Time{Time: time.Unix(1, 0).UTC()}, Comment: "some-comment"},
+				{CreatedBy: "some-layer-2", Created: v1.Time{Time: time.Unix(2, 0).UTC()}},
+				{CreatedBy: "some-cmd", Created: v1.Time{Time: time.Unix(3, 0).UTC()}, EmptyLayer: true},
+			})
+		})
+
+		it("returns an empty entry per layer when the sizes do not account for every layer", func() {
+			docker := &daemonImagesDocker{histories: map[string][]image.HistoryResponseItem{
+				"sha256:some-id": {
+					{CreatedBy: "some-empty-layer-2"},
+					{CreatedBy: "some-layer-1", Size: 10},
+				},
+			}}
+
+			history, err := imageHistory(docker, inspect)
+			h.AssertNil(t, err)
+			h.AssertEq(t, history, []v1.History{{}, {}})
+		})
+
+		it("returns an empty entry per layer for images not in the daemon", func() {
+			inspect.ID = ""
+
+			history, err := imageHistory(&daemonImagesDocker{}, inspect)
+			h.AssertNil(t, err)
+			h.AssertEq(t, history, []v1.History{{}, {}})
+		})
+	})
+
+	when("#v1Config", func() {
+		it("keeps the entries of instructions that did not create a layer", func() {
+			inspect := types.ImageInspect{
+				Config: &container.Config{},
+				RootFS: types.RootFS{Layers: []string{
+					"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+				}},
+			}
+			layerCreated := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			configFile, err := v1Config(inspect, []v1.History{
+				{CreatedBy: "some-trimmed-layer"},
+				{CreatedBy: "some-layer", Created: v1.Time{Time: layerCreated}},
+				{CreatedBy: "some-cmd", EmptyLayer: true},
+			}, imgutil.NormalizedDateTime)
+			h.AssertNil(t, err)
+			h.AssertEq(t, configFile.History, []v1.History{
+				{CreatedBy: "some-layer", Created: v1.Time{Time: layerCreated}},
+				{CreatedBy: "some-cmd", Created: v1.Time{Time: imgutil.NormalizedDateTime}, EmptyLayer: true},
+			})
+		})
+	})
+
+	when("#containerConfig", func() {
+		it("converts a runtime config that converts back unchanged", func() {
+			cfg := v1.Config{
+				Cmd:          []string{"some", "cmd"},
+				Entrypoint:   []string{"some-entrypoint"},
+				Env:          []string{"SOME_KEY=some-val"},
+				Labels:       map[string]string{"some-label": "some-val"},
+				OnBuild:      []string{"RUN echo some-trigger"},
+				Shell:        []string{"/bin/bash", "-c"},
+				ArgsEscaped:  true,
+				ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+				Healthcheck:  &v1.HealthConfig{Test: []string{"CMD", "true"}, Retries: 3},
+				StopSignal:   "SIGKILL",
+				User:         "some-user",
+				Volumes:      map[string]struct{}{"/some-volume": {}},
+				WorkingDir:   "/some-dir",
+			}
+
+			configFile, err := v1Config(types.ImageInspect{Config: containerConfig(&cfg)}, nil, time.Time{})
+			h.AssertNil(t, err)
+			h.AssertEq(t, configFile.Config, cfg)
+		})
+	})
+}
+
+// saveOnlyDocker is a docker client that only supports inspecting and exporting a single image, whose ID is
+// sha256:some-image-id, recording the names each export is requested for
+type saveOnlyDocker struct {
+	client.CommonAPIClient
+	archive    []byte
+	saves      int
+	savedNames []string
+}
+
+func (d *saveOnlyDocker) ImageInspectWithRaw(context.Context, string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{ID: "sha256:some-image-id"}, nil, nil
+}
+
+func (d *saveOnlyDocker) ImageSave(_ context.Context, imageNames []string) (io.ReadCloser, error) {
+	d.saves++
+	d.savedNames = append(d.savedNames, imageNames...)
+	return ioutil.NopCloser(bytes.NewReader(d.archive)), nil
+}
+
+// daemonImagesDocker inspects the images it holds by name or ID, and exports them by ID
+type daemonImagesDocker struct {
+	client.CommonAPIClient
+	inspects  map[string]types.ImageInspect
+	archives  map[string][]byte
+	histories map[string][]image.HistoryResponseItem
+}
+
+func (d *daemonImagesDocker) ImageInspectWithRaw(_ context.Context, imageName string) (types.ImageInspect, []byte, error) {
+	if inspect, ok := d.inspects[imageName]; ok {
+		return inspect, nil, nil
+	}
+	for _, inspect := range d.inspects {
+		if inspect.ID == imageName {
+			return inspect, nil, nil
+		}
+	}
+	return types.ImageInspect{}, nil, fmt.Errorf("no such image: %s", imageName)
+}
+
+func (d *daemonImagesDocker) ImageHistory(_ context.Context, imageID string) ([]image.HistoryResponseItem, error) {
+	return d.histories[imageID], nil
+}
+
+func (d *daemonImagesDocker) ImageSave(_ context.Context, imageNames []string) (io.ReadCloser, error) {
+	archive, ok := d.archives[imageNames[0]]
+	if !ok {
+		return nil, fmt.Errorf("no such image: %s", imageNames[0])
+	}
+	return ioutil.NopCloser(bytes.NewReader(archive)), nil
+}
+
+// cancelledLoadDocker is like saveOnlyDocker, but fails each ImageLoad with the error of its context
+type cancelledLoadDocker struct {
+	*saveOnlyDocker
+}
+
+func (d *cancelledLoadDocker) ImageLoad(ctx context.Context, _ io.Reader, _ bool) (types.ImageLoadResponse, error) {
+	return types.ImageLoadResponse{}, ctx.Err()
+}
+
+// cancellableLoadDocker is like saveOnlyDocker, but fails each ImageLoad with the error of its context, or reads
+// the whole archive when the context is not done
+type cancellableLoadDocker struct {
+	*saveOnlyDocker
+	loads int
+}
+
+func (d *cancellableLoadDocker) ImageLoad(ctx context.Context, r io.Reader, _ bool) (types.ImageLoadResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+	d.loads++
+	return types.ImageLoadResponse{
+		Body: ioutil.NopCloser(strings.NewReader(`{"stream":"Loaded image: some-image:latest\n"}` + "\n")),
+	}, nil
+}
+
+// unresponsiveInspectDocker is a docker client whose ImageInspectWithRaw only returns once its context is done
+type unresponsiveInspectDocker struct {
+	client.CommonAPIClient
+}
+
+func (d *unresponsiveInspectDocker) ImageInspectWithRaw(ctx context.Context, _ string) (types.ImageInspect, []byte, error) {
+	<-ctx.Done()
+	return types.ImageInspect{}, nil, ctx.Err()
+}
+
+// unresponsiveHistoryDocker is a docker client that inspects any image, but whose ImageHistory only returns once its
+// context is done
+type unresponsiveHistoryDocker struct {
+	client.CommonAPIClient
+}
+
+func (d *unresponsiveHistoryDocker) ImageInspectWithRaw(context.Context, string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{ID: "sha256:some-image-id"}, nil, nil
+}
+
+func (d *unresponsiveHistoryDocker) ImageHistory(ctx context.Context, _ string) ([]image.HistoryResponseItem, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func archiveWithFiles(t *testing.T, files map[string]string, headers ...*tar.Header) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for name, contents := range files {
+		h.AssertNil(t, daemon.AddTextToTar(tw, name, []byte(contents)))
+	}
+	for _, hdr := range headers {
+		h.AssertNil(t, tw.WriteHeader(hdr))
+	}
+	h.AssertNil(t, tw.Close())
+	return buf.Bytes()
+}
@@ -0,0 +1,74 @@
+package local
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+// timeoutClient bounds each call the image makes to the daemon by timeout. Calls that stream a response stay bounded
+// until the response is closed.
+type timeoutClient struct {
+	client.CommonAPIClient
+	timeout time.Duration
+}
+
+func (c *timeoutClient) Info(ctx context.Context) (types.Info, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.CommonAPIClient.Info(ctx)
+}
+
+func (c *timeoutClient) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.CommonAPIClient.ImageInspectWithRaw(ctx, image)
+}
+
+func (c *timeoutClient) ImageHistory(ctx context.Context, image string) ([]image.HistoryResponseItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.CommonAPIClient.ImageHistory(ctx, image)
+}
+
+func (c *timeoutClient) ImageRemove(ctx context.Context, image string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.CommonAPIClient.ImageRemove(ctx, image, options)
+}
+
+func (c *timeoutClient) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	res, err := c.CommonAPIClient.ImageLoad(ctx, input, quiet)
+	if err != nil {
+		cancel()
+		return res, err
+	}
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+func (c *timeoutClient) ImageSave(ctx context.Context, images []string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	rc, err := c.CommonAPIClient.ImageSave(ctx, images)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelOnClose{ReadCloser: rc, cancel: cancel}, nil
+}
+
+// cancelOnClose releases the context of a streamed response once the response is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
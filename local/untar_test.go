@@ -0,0 +1,137 @@
+package local
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestUntar(t *testing.T) {
+	spec.Run(t, "untar", testUntar, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testUntar(t *testing.T, when spec.G, it spec.S) {
+	var (
+		parentDir string
+		dest      string
+	)
+
+	it.Before(func() {
+		var err error
+		parentDir, err = ioutil.TempDir("", "untar-test")
+		h.AssertNil(t, err)
+		dest = filepath.Join(parentDir, "dest")
+		h.AssertNil(t, os.Mkdir(dest, 0755))
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.RemoveAll(parentDir))
+	})
+
+	it("extracts entries into dest", func() {
+		h.AssertNil(t, untar(tarWithHeaders(t, &tar.Header{Name: "some-dir/some-file", Typeflag: tar.TypeReg, Mode: 0644}), dest))
+
+		_, err := os.Stat(filepath.Join(dest, "some-dir", "some-file"))
+		h.AssertNil(t, err)
+	})
+
+	it("rejects entries outside of dest", func() {
+		err := untar(tarWithHeaders(t, &tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}), dest)
+		h.AssertError(t, err, "tar entry '../evil' is outside of the destination directory")
+
+		_, err = os.Stat(filepath.Join(parentDir, "evil"))
+		h.AssertEq(t, os.IsNotExist(err), true)
+	})
+
+	it("rejects symlinks to paths outside of dest", func() {
+		err := untar(tarWithHeaders(t, &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}), dest)
+		h.AssertError(t, err, "tar entry 'link' links to '../../etc', which is outside of the destination directory")
+
+		err = untar(tarWithHeaders(t, &tar.Header{Name: "abs-link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}), dest)
+		h.AssertError(t, err, "which is outside of the destination directory")
+	})
+
+	it("rejects entries that escape dest through a chain of symlinks", func() {
+		err := untar(tarWithHeaders(t,
+			&tar.Header{Name: "s", Typeflag: tar.TypeSymlink, Linkname: "."},
+			&tar.Header{Name: "t", Typeflag: tar.TypeSymlink, Linkname: "s/.."},
+			&tar.Header{Name: "t/evil", Typeflag: tar.TypeReg, Mode: 0644},
+		), dest)
+		h.AssertError(t, err, "tar entry 't/evil' is outside of the destination directory")
+
+		_, err = os.Stat(filepath.Join(parentDir, "evil"))
+		h.AssertEq(t, os.IsNotExist(err), true)
+	})
+
+	it("replaces symlinks extracted earlier instead of writing through them", func() {
+		err := untar(tarWithHeaders(t,
+			&tar.Header{Name: "s", Typeflag: tar.TypeSymlink, Linkname: "."},
+			&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "s/../outside"},
+			&tar.Header{Name: "link", Typeflag: tar.TypeReg, Mode: 0644},
+		), dest)
+		h.AssertNil(t, err)
+
+		fi, err := os.Lstat(filepath.Join(dest, "link"))
+		h.AssertNil(t, err)
+		h.AssertEq(t, fi.Mode().IsRegular(), true)
+	})
+
+	it("allows symlinks to paths inside of dest", func() {
+		h.AssertNil(t, untar(tarWithHeaders(t,
+			&tar.Header{Name: "some-dir/", Typeflag: tar.TypeDir, Mode: 0755},
+			&tar.Header{Name: "some-dir/link", Typeflag: tar.TypeSymlink, Linkname: "../other-file"},
+		), dest))
+	})
+
+	it("extracts hardlinks to already extracted files", func() {
+		h.AssertNil(t, untar(tarWithHeaders(t,
+			&tar.Header{Name: "some-file", Typeflag: tar.TypeReg, Mode: 0644},
+			&tar.Header{Name: "missing-dir/hardlink", Typeflag: tar.TypeLink, Linkname: "some-file"},
+		), dest))
+
+		original, err := os.Stat(filepath.Join(dest, "some-file"))
+		h.AssertNil(t, err)
+		link, err := os.Stat(filepath.Join(dest, "missing-dir", "hardlink"))
+		h.AssertNil(t, err)
+		h.AssertEq(t, os.SameFile(original, link), true)
+	})
+
+	it("rejects hardlinks to paths outside of dest", func() {
+		err := untar(tarWithHeaders(t, &tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "../evil"}), dest)
+		h.AssertError(t, err, "tar entry 'hardlink' links to '../evil', which is outside of the destination directory")
+	})
+
+	it("skips device files and named pipes", func() {
+		h.AssertNil(t, untar(tarWithHeaders(t,
+			&tar.Header{Name: "some-char-device", Typeflag: tar.TypeChar, Mode: 0644},
+			&tar.Header{Name: "some-fifo", Typeflag: tar.TypeFifo, Mode: 0644},
+			&tar.Header{Name: "some-file", Typeflag: tar.TypeReg, Mode: 0644},
+		), dest))
+
+		_, err := os.Stat(filepath.Join(dest, "some-fifo"))
+		h.AssertEq(t, os.IsNotExist(err), true)
+		_, err = os.Stat(filepath.Join(dest, "some-file"))
+		h.AssertNil(t, err)
+	})
+}
+
+func tarWithHeaders(t *testing.T, headers ...*tar.Header) io.Reader {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range headers {
+		h.AssertNil(t, tw.WriteHeader(hdr))
+	}
+	h.AssertNil(t, tw.Close())
+	return buf
+}
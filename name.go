@@ -0,0 +1,37 @@
+package imgutil
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// RegistryFromName returns the registry of the image named repoName, defaulting to Docker Hub,
+// or an empty string if repoName is not a valid image name.
+func RegistryFromName(repoName string, opts ...name.Option) string {
+	ref, err := name.ParseReference(repoName, opts...)
+	if err != nil {
+		return ""
+	}
+	return ref.Context().RegistryStr()
+}
+
+// RepositoryFromName returns the repository of the image named repoName, without the registry,
+// or an empty string if repoName is not a valid image name.
+func RepositoryFromName(repoName string, opts ...name.Option) string {
+	ref, err := name.ParseReference(repoName, opts...)
+	if err != nil {
+		return ""
+	}
+	return ref.Context().RepositoryStr()
+}
+
+// TagFromName returns the tag of the image named repoName, defaulting to "latest", or its digest
+// when repoName is a digest reference.
+func TagFromName(repoName string, opts ...name.Option) (string, error) {
+	ref, err := name.ParseReference(repoName, opts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse reference for image '%s': %s", repoName, err)
+	}
+	return ref.Identifier(), nil
+}
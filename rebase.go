@@ -0,0 +1,93 @@
+package imgutil
+
+import "fmt"
+
+// RebaseResult describes the layers a rebase keeps, removes and adds, by diff ID and from the bottom layer to the top.
+type RebaseResult struct {
+	// KeptLayers are the layers of the image above the old base, which are kept on top of the new base.
+	KeptLayers []string
+	// RemovedLayers are the layers of the old base, up to and including the base top layer.
+	RemovedLayers []string
+	// NewBaseLayers are the layers of the new base.
+	NewBaseLayers []string
+}
+
+// RebaseOption configures how Rebase and RebasePlan find the layers of the old base in the image.
+type RebaseOption func(*rebaseOptions)
+
+type rebaseOptions struct {
+	oldBase Image
+}
+
+// WithOldBase has Rebase and RebasePlan check that the bottom layers of the image are the layers of oldBase, whose
+// top layer must be baseTopLayer, and replace exactly those layers. Without it, the old base ends at the first
+// occurrence of baseTopLayer in the image.
+func WithOldBase(oldBase Image) RebaseOption {
+	return func(o *rebaseOptions) {
+		o.oldBase = oldBase
+	}
+}
+
+// PlanRebase computes the result of rebasing image onto newBase, replacing the layers up to and including
+// baseTopLayer, without modifying either image. It returns an error if baseTopLayer is not in the image, or if the
+// image does not start with the layers of the old base given with WithOldBase.
+func PlanRebase(image Image, baseTopLayer string, newBase Image, opts ...RebaseOption) (*RebaseResult, error) {
+	var o rebaseOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	diffIDs, err := image.LayerDiffIDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layers for image '%s': %s", image.Name(), err)
+	}
+	newBaseDiffIDs, err := newBase.LayerDiffIDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layers for new base '%s': %s", newBase.Name(), err)
+	}
+
+	var baseLen int
+	if o.oldBase != nil {
+		baseLen, err = oldBaseLen(image.Name(), diffIDs, baseTopLayer, o.oldBase)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for idx, diffID := range diffIDs {
+			if diffID == baseTopLayer {
+				baseLen = idx + 1
+				break
+			}
+		}
+		if baseLen == 0 {
+			return nil, fmt.Errorf("'%s' not found in '%s' during rebase", baseTopLayer, image.Name())
+		}
+	}
+
+	return &RebaseResult{
+		KeptLayers:    diffIDs[baseLen:],
+		RemovedLayers: diffIDs[:baseLen],
+		NewBaseLayers: newBaseDiffIDs,
+	}, nil
+}
+
+// oldBaseLen returns the number of layers of oldBase after checking that they are the bottom layers of the image
+// named imageName, with diffIDs, and end in baseTopLayer
+func oldBaseLen(imageName string, diffIDs []string, baseTopLayer string, oldBase Image) (int, error) {
+	oldBaseDiffIDs, err := oldBase.LayerDiffIDs()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get layers for old base '%s': %s", oldBase.Name(), err)
+	}
+	if len(oldBaseDiffIDs) == 0 || oldBaseDiffIDs[len(oldBaseDiffIDs)-1] != baseTopLayer {
+		return 0, fmt.Errorf("'%s' is not the top layer of old base '%s'", baseTopLayer, oldBase.Name())
+	}
+	if len(oldBaseDiffIDs) > len(diffIDs) {
+		return 0, fmt.Errorf("'%s' does not start with the layers of old base '%s'", imageName, oldBase.Name())
+	}
+	for idx, diffID := range oldBaseDiffIDs {
+		if diffIDs[idx] != diffID {
+			return 0, fmt.Errorf("'%s' does not start with the layers of old base '%s': layer %d is '%s', not '%s'", imageName, oldBase.Name(), idx, diffIDs[idx], diffID)
+		}
+	}
+	return len(oldBaseDiffIDs), nil
+}
@@ -0,0 +1,153 @@
+package remote
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/internal/daemon"
+	"github.com/buildpacks/imgutil/local"
+)
+
+// CopyToDaemon loads the image into the docker daemon as `Name()` and returns it as a local image.
+// Layers are decompressed before they are streamed to the daemon.
+func (i *Image) CopyToDaemon(dockerClient client.CommonAPIClient) (imgutil.Image, error) {
+	if err := i.Commit(); err != nil {
+		return nil, err
+	}
+
+	tag, err := name.NewTag(i.repoName, i.nameOptions()...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "image '%s' must be referenced by tag to copy to daemon", i.repoName)
+	}
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	done := make(chan error, 1)
+	go func() {
+		res, err := dockerClient.ImageLoad(context.Background(), pr, true)
+		if err != nil {
+			// unblock any pending writes to the pipe
+			pr.CloseWithError(err)
+			done <- err
+			return
+		}
+
+		// only return response error after response is drained and closed
+		responseErr := daemon.CheckResponseError(res.Body, nil)
+		drainCloseErr := daemon.EnsureReaderClosed(res.Body)
+		if responseErr != nil {
+			done <- responseErr
+			return
+		}
+		done <- drainCloseErr
+	}()
+
+	if err := i.writeDockerArchive(pw, tag.Name()); err != nil {
+		pw.CloseWithError(err)
+		<-done
+		return nil, err
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		return nil, errors.Wrapf(err, "image load '%s'", i.repoName)
+	}
+
+	return local.NewImage(tag.Name(), dockerClient, local.FromBaseImage(tag.Name()))
+}
+
+// writeDockerArchive writes the image to w in the format read by `docker load`
+func (i *Image) writeDockerArchive(w io.Writer, repoTag string) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	configName, err := i.image.ConfigName()
+	if err != nil {
+		return errors.Wrap(err, "get config digest")
+	}
+	configFile, err := i.image.RawConfigFile()
+	if err != nil {
+		return errors.Wrap(err, "get config file")
+	}
+	configPath := configName.Hex + ".json"
+	if err := daemon.AddTextToTar(tw, configPath, configFile); err != nil {
+		return err
+	}
+
+	layers, err := i.image.Layers()
+	if err != nil {
+		return errors.Wrap(err, "get image layers")
+	}
+	var layerPaths []string
+	for _, layer := range layers {
+		layerPath, err := addLayerToTar(tw, layer)
+		if err != nil {
+			return err
+		}
+		layerPaths = append(layerPaths, layerPath)
+	}
+
+	manifest, err := json.Marshal([]map[string]interface{}{
+		{
+			"Config":   configPath,
+			"RepoTags": []string{repoTag},
+			"Layers":   layerPaths,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := daemon.AddTextToTar(tw, "manifest.json", manifest); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// addLayerToTar writes the uncompressed contents of layer to tw, returning the path it was written to.
+// The layer is spooled to disk first because tar headers need the uncompressed size up front.
+func addLayerToTar(tw *tar.Writer, layer v1.Layer) (string, error) {
+	diffID, err := layer.DiffID()
+	if err != nil {
+		return "", errors.Wrap(err, "get layer diff ID")
+	}
+
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return "", errors.Wrapf(err, "read layer '%s'", diffID)
+	}
+	defer rc.Close()
+
+	f, err := ioutil.TempFile("", "imgutil.remote.layer.")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, rc)
+	if err != nil {
+		return "", errors.Wrapf(err, "read layer '%s'", diffID)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	layerPath := diffID.Hex + "/layer.tar"
+	hdr := &tar.Header{Name: layerPath, Typeflag: tar.TypeReg, Mode: 0644, Size: size, ModTime: imgutil.NormalizedDateTime}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return "", err
+	}
+	return layerPath, nil
+}
@@ -0,0 +1,71 @@
+package remote
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+)
+
+// cachedLayer returns the layer with diffID from the layer cache, or nil if it is not cached.
+// Entries whose contents no longer match diffID are removed and treated as missing.
+func (i *Image) cachedLayer(diffID string) (v1.Layer, error) {
+	path := i.layerCachePath(diffID)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	layer, err := tarball.LayerFromFile(path)
+	if err == nil {
+		var cachedDiffID v1.Hash
+		if cachedDiffID, err = layer.DiffID(); err == nil && cachedDiffID.String() == diffID {
+			return layer, nil
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return nil, errors.Wrapf(err, "remove invalid cached layer '%s'", diffID)
+	}
+	return nil, nil
+}
+
+// cacheLayer writes the compressed contents of layer to the layer cache and returns the cached layer
+func (i *Image) cacheLayer(diffID string, layer v1.Layer) (v1.Layer, error) {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, errors.Wrapf(err, "read layer '%s'", diffID)
+	}
+	defer rc.Close()
+
+	f, err := ioutil.TempFile(i.layerCacheDir, "layer.")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, rc)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cache layer '%s'", diffID)
+	}
+
+	// rename so that concurrent processes never read a partially written entry
+	if err := os.Rename(f.Name(), i.layerCachePath(diffID)); err != nil {
+		return nil, errors.Wrapf(err, "cache layer '%s'", diffID)
+	}
+	return i.cachedLayer(diffID)
+}
+
+func (i *Image) layerCachePath(diffID string) string {
+	return filepath.Join(i.layerCacheDir, strings.Replace(diffID, ":", "-", 1)+".tar.gz")
+}
@@ -0,0 +1,164 @@
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Format selects the set of media types an image is saved with
+type Format string
+
+const (
+	// OCI saves the manifest, config and layers with OCI image media types
+	OCI Format = "oci"
+	// Docker saves the manifest, config and layers with Docker image manifest v2, schema 2 media types
+	Docker Format = "docker"
+)
+
+var formatMediaTypes = map[Format]types.MediaType{
+	OCI:    types.OCIManifestSchema1,
+	Docker: types.DockerManifestSchema2,
+}
+
+// mediaTypeSet holds the config and layer media types that go with a manifest media type
+type mediaTypeSet struct {
+	config types.MediaType
+	layers map[types.MediaType]types.MediaType
+}
+
+var manifestMediaTypes = map[types.MediaType]mediaTypeSet{
+	types.OCIManifestSchema1: {
+		config: types.OCIConfigJSON,
+		layers: map[types.MediaType]types.MediaType{
+			types.DockerLayer:             types.OCILayer,
+			types.DockerUncompressedLayer: types.OCIUncompressedLayer,
+			types.DockerForeignLayer:      types.OCIRestrictedLayer,
+		},
+	},
+	types.DockerManifestSchema2: {
+		config: types.DockerConfigJSON,
+		layers: map[types.MediaType]types.MediaType{
+			types.OCILayer:                       types.DockerLayer,
+			types.OCIUncompressedLayer:           types.DockerUncompressedLayer,
+			types.OCIRestrictedLayer:             types.DockerForeignLayer,
+			types.OCIUncompressedRestrictedLayer: types.DockerForeignLayer,
+		},
+	},
+}
+
+// convertMediaType returns image with its manifest, config and layers using the media types that go with mt.
+// The image is returned unchanged if it already uses them.
+func convertMediaType(image v1.Image, mt types.MediaType) (v1.Image, error) {
+	typeSet, ok := manifestMediaTypes[mt]
+	if !ok {
+		return nil, fmt.Errorf("unsupported manifest media type '%s'", mt)
+	}
+
+	current, err := image.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	converted := current == mt && manifest.Config.MediaType == typeSet.config
+	for _, desc := range manifest.Layers {
+		if _, ok := typeSet.layers[desc.MediaType]; ok {
+			converted = false
+		}
+	}
+	if converted {
+		return image, nil
+	}
+
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	typedLayers := make([]v1.Layer, len(layers))
+	for idx, layer := range layers {
+		layerType, err := layer.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if newType, ok := typeSet.layers[layerType]; ok {
+			layerType = newType
+		}
+		typedLayers[idx] = &mediaTypeLayer{Layer: layer, mediaType: layerType}
+		// base layers stay mountable from the repository they were read from
+		if mountable, ok := layer.(*remote.MountableLayer); ok {
+			typedLayers[idx] = &remote.MountableLayer{Layer: typedLayers[idx], Reference: mountable.Reference}
+		}
+	}
+
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	newImage, err := mutate.AppendLayers(mutate.MediaType(empty.Image, mt), typedLayers...)
+	if err != nil {
+		return nil, err
+	}
+	newImage, err = mutate.ConfigFile(newImage, configFile.DeepCopy())
+	if err != nil {
+		return nil, err
+	}
+	return &manifestImage{Image: newImage, configMediaType: typeSet.config, annotations: manifest.Annotations}, nil
+}
+
+// mediaTypeLayer overrides the media type reported by a layer
+type mediaTypeLayer struct {
+	v1.Layer
+	mediaType types.MediaType
+}
+
+func (l *mediaTypeLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// manifestImage overrides the config media type and annotations recorded in an image's manifest
+type manifestImage struct {
+	v1.Image
+	configMediaType types.MediaType
+	annotations     map[string]string
+}
+
+func (i *manifestImage) Manifest() (*v1.Manifest, error) {
+	manifest, err := i.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	manifest = manifest.DeepCopy()
+	if i.configMediaType != "" {
+		manifest.Config.MediaType = i.configMediaType
+	}
+	if i.annotations != nil {
+		manifest.Annotations = i.annotations
+	}
+	return manifest, nil
+}
+
+func (i *manifestImage) RawManifest() ([]byte, error) {
+	manifest, err := i.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(manifest)
+}
+
+func (i *manifestImage) Digest() (v1.Hash, error) {
+	return partial.Digest(i)
+}
+
+func (i *manifestImage) Size() (int64, error) {
+	return partial.Size(i)
+}
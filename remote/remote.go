@@ -1,10 +1,16 @@
 package remote
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -19,58 +25,256 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layer"
 )
 
+// ErrDeleteUnsupported is the cause of the error returned by Delete when the registry does not allow deletes.
+var ErrDeleteUnsupported = errors.New("registry does not support deleting images")
+
+// ErrUnauthorized is the cause of the error returned by CheckFound and Exists when the registry rejects the
+// credentials used.
+var ErrUnauthorized = errors.New("registry denied access to image")
+
 type Image struct {
-	keychain   authn.Keychain
-	repoName   string
-	image      v1.Image
-	prevLayers []v1.Layer
+	keychain          authn.Keychain
+	repoName          string
+	platform          v1.Platform
+	image             v1.Image
+	pendingConfig     *v1.Config
+	mediaType         types.MediaType
+	createdAt         time.Time
+	prevLayers        []v1.Layer
+	baseImageName     string
+	prevImageName     string
+	retries           int
+	backoff           time.Duration
+	registryMirrors   map[string]string
+	insecure          bool
+	strictValidation  bool
+	uploadConcurrency int
+	mountFrom         *name.Repository
+	layerCacheDir     string
+	layerOptions      []tarball.LayerOption
+	keychainRefresh   func() authn.Keychain
+	anonymousFallback bool
+	verifyAfterPush   bool
+	transport         http.RoundTripper
+	tempLayers        []string
+	// recordedHistory is the number of entries at the top of the history that were appended with the layers added
+	// to the image, which are the only entries Save keeps
+	recordedHistory int
 }
 
 type ImageOption func(*Image) (*Image, error)
 
 func WithPreviousImage(imageName string) ImageOption {
 	return func(r *Image) (*Image, error) {
-		var err error
+		r.prevImageName = imageName
+		return r, nil
+	}
+}
+
+func FromBaseImage(imageName string) ImageOption {
+	return func(r *Image) (*Image, error) {
+		r.baseImageName = imageName
+		return r, nil
+	}
+}
 
-		prevImage, err := newV1Image(r.keychain, imageName)
+// FromTarball initializes the image from a `docker save` tarball at path, which must contain a single image.
+func FromTarball(path string) ImageOption {
+	return func(r *Image) (*Image, error) {
+		image, err := tarball.ImageFromPath(path, nil)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrapf(err, "read image tarball '%s'", path)
 		}
+		r.image = image
+		r.baseImageName = ""
+		r.recordedHistory = 0
+		return r, nil
+	}
+}
 
-		prevLayers, err := prevImage.Layers()
+// WithRegistryMirror pulls and pushes images for the original registry through mirror, authenticating
+// with the credentials for original.
+func WithRegistryMirror(original, mirror string) ImageOption {
+	return func(r *Image) (*Image, error) {
+		registry, err := name.NewRegistry(original, name.WeakValidation)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to get layers for previous image with repo name '%s'", imageName)
+			return nil, errors.Wrapf(err, "parse registry '%s'", original)
+		}
+		if r.registryMirrors == nil {
+			r.registryMirrors = map[string]string{}
 		}
+		r.registryMirrors[registry.RegistryStr()] = mirror
+		return r, nil
+	}
+}
 
-		r.prevLayers = prevLayers
+// WithInsecure connects to registries over plain HTTP rather than HTTPS.
+func WithInsecure() ImageOption {
+	return func(r *Image) (*Image, error) {
+		r.insecure = true
 		return r, nil
 	}
 }
 
-func FromBaseImage(imageName string) ImageOption {
+// WithTransport uses rt for all requests to registries, for example to trust a custom CA.
+func WithTransport(rt http.RoundTripper) ImageOption {
+	return func(r *Image) (*Image, error) {
+		r.transport = rt
+		return r, nil
+	}
+}
+
+// WithStrictValidation requires image names to be fully specified, with no defaulting of registry, repository
+// or tag, and fails immediately if the image name is not.
+func WithStrictValidation() ImageOption {
+	return func(r *Image) (*Image, error) {
+		r.strictValidation = true
+		if _, err := name.ParseReference(r.repoName, r.nameOptions()...); err != nil {
+			return r, errors.Wrapf(err, "invalid image name '%s'", r.repoName)
+		}
+		return r, nil
+	}
+}
+
+// WithVerifyAfterPush makes Save pull the manifest of each image it pushes by digest and fail if the registry did
+// not store the manifest that was pushed, for example because a proxy or mirror altered it.
+func WithVerifyAfterPush() ImageOption {
+	return func(r *Image) (*Image, error) {
+		r.verifyAfterPush = true
+		return r, nil
+	}
+}
+
+// WithFormat saves the image with the media types of format, converting the manifest, config and layers of the base
+// image and of layers added later as SetMediaType does. By default the image keeps the media types of its base.
+func WithFormat(format Format) ImageOption {
+	return func(r *Image) (*Image, error) {
+		mt, ok := formatMediaTypes[format]
+		if !ok {
+			return r, fmt.Errorf("unsupported image format '%s'", format)
+		}
+		r.mediaType = mt
+		return r, nil
+	}
+}
+
+// WithCompressionLevel gzips the layers added by AddLayer, AddLayerFromReader and Squash at level, trading CPU time
+// for upload size. Valid levels range from gzip.HuffmanOnly (-2) to gzip.BestCompression (9). Level 0
+// (gzip.NoCompression) still writes gzip streams, but stores the contents uncompressed. By default layers are
+// compressed with gzip.BestSpeed.
+func WithCompressionLevel(level int) ImageOption {
+	return func(r *Image) (*Image, error) {
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return r, fmt.Errorf("compression level must be between %d and %d, got %d", gzip.HuffmanOnly, gzip.BestCompression, level)
+		}
+		r.layerOptions = []tarball.LayerOption{tarball.WithCompressionLevel(level)}
+		return r, nil
+	}
+}
+
+// WithUploadConcurrency limits Save to uploading n layers at a time, where 1 uploads them one after another.
+// By default all layers are uploaded at once.
+func WithUploadConcurrency(n int) ImageOption {
 	return func(r *Image) (*Image, error) {
-		var err error
+		if n < 1 {
+			return r, fmt.Errorf("upload concurrency must be at least 1, got %d", n)
+		}
+		r.uploadConcurrency = n
+		return r, nil
+	}
+}
 
-		r.image, err = newV1Image(r.keychain, imageName)
+// WithMountFrom makes Save mount the layers that the target repository does not have from repoName, another
+// repository in the same registry, instead of uploading them. Layers the registry cannot mount, for example because
+// repoName does not have them or the credentials cannot pull from it, are uploaded as usual. Layers of a base image
+// in the same registry are mounted from the base image's repository regardless.
+func WithMountFrom(repoName string) ImageOption {
+	return func(r *Image) (*Image, error) {
+		repo, err := name.NewRepository(repoName, r.nameOptions()...)
 		if err != nil {
-			return nil, err
+			return r, errors.Wrapf(err, "parse repository to mount from '%s'", repoName)
+		}
+		r.mountFrom = &repo
+		return r, nil
+	}
+}
+
+// WithLayerCache keeps layers reused from the previous image in dir, keyed by diff ID, so that later calls to
+// ReuseLayer, including from other processes, read them from disk instead of the registry. Cached layers are
+// verified against their diff ID before they are reused.
+func WithLayerCache(dir string) ImageOption {
+	return func(r *Image) (*Image, error) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrapf(err, "create layer cache '%s'", dir)
+		}
+		r.layerCacheDir = dir
+		return r, nil
+	}
+}
+
+// WithKeychainRefresh replaces the keychain with the one returned by refresh before Save retries a write, so that
+// long running processes can pick up rotated credentials. Save also retries once, without waiting, when the
+// registry rejects the credentials it used.
+func WithKeychainRefresh(refresh func() authn.Keychain) ImageOption {
+	return func(r *Image) (*Image, error) {
+		r.keychainRefresh = refresh
+		return r, nil
+	}
+}
+
+// WithAnonymousFallback retries reads anonymously when the registry rejects the credentials resolved from the
+// keychain, as docker does for public repositories.
+func WithAnonymousFallback() ImageOption {
+	return func(r *Image) (*Image, error) {
+		r.anonymousFallback = true
+		return r, nil
+	}
+}
+
+// WithCreatedAt sets the creation time recorded in the image config on Save, instead of imgutil.NormalizedDateTime.
+// Pass the time from SOURCE_DATE_EPOCH to make builds reproducible against a source date.
+func WithCreatedAt(createdAt time.Time) ImageOption {
+	return func(r *Image) (*Image, error) {
+		r.createdAt = createdAt
+		return r, nil
+	}
+}
+
+// WithRetry retries writing the image up to attempts times when the registry returns a transient error,
+// doubling backoff between each attempt.
+func WithRetry(attempts int, backoff time.Duration) ImageOption {
+	return func(r *Image) (*Image, error) {
+		if attempts < 1 {
+			return nil, fmt.Errorf("retry attempts must be at least 1, got %d", attempts)
 		}
+		r.retries = attempts - 1
+		r.backoff = backoff
 		return r, nil
 	}
 }
 
 func NewImage(repoName string, keychain authn.Keychain, ops ...ImageOption) (imgutil.Image, error) {
-	image, err := emptyImage()
+	return NewImageWithPlatform(repoName, keychain, defaultPlatform(), ops...)
+}
+
+// NewImageWithPlatform is like NewImage, but selects the child image matching platform
+// when a base or previous image name refers to a manifest list.
+func NewImageWithPlatform(repoName string, keychain authn.Keychain, platform v1.Platform, ops ...ImageOption) (imgutil.Image, error) {
+	image, err := emptyImage(platform)
 	if err != nil {
 		return nil, err
 	}
 
 	ri := &Image{
-		keychain: keychain,
-		repoName: repoName,
-		image:    image,
+		keychain:  keychain,
+		repoName:  repoName,
+		platform:  platform,
+		image:     image,
+		transport: http.DefaultTransport,
+		createdAt: imgutil.NormalizedDateTime,
 	}
 
 	for _, op := range ops {
@@ -80,21 +284,40 @@ func NewImage(repoName string, keychain authn.Keychain, ops ...ImageOption) (img
 		}
 	}
 
+	// the base and previous images are read once all options are applied, so that options configuring the
+	// connection take effect whatever their order
+	if ri.baseImageName != "" {
+		if ri.image, err = ri.newV1Image(ri.baseImageName); err != nil {
+			return nil, err
+		}
+	}
+
+	if ri.prevImageName != "" {
+		prevImage, err := ri.newV1Image(ri.prevImageName)
+		if err != nil {
+			return nil, err
+		}
+
+		if ri.prevLayers, err = prevImage.Layers(); err != nil {
+			return nil, errors.Wrapf(err, "failed to get layers for previous image with repo name '%s'", ri.prevImageName)
+		}
+	}
+
 	return ri, nil
 }
 
-func newV1Image(keychain authn.Keychain, repoName string) (v1.Image, error) {
-	ref, auth, err := referenceForRepoName(keychain, repoName)
+func (i *Image) newV1Image(repoName string) (v1.Image, error) {
+	ref, auth, err := i.referenceForRepoName(repoName)
 	if err != nil {
 		return nil, err
 	}
 
-	image, err := remote.Image(ref, remote.WithAuth(auth), remote.WithTransport(http.DefaultTransport))
+	image, err := i.fetchImage(ref, auth)
 	if err != nil {
 		if transportErr, ok := err.(*transport.Error); ok && len(transportErr.Errors) > 0 {
 			switch transportErr.StatusCode {
 			case http.StatusNotFound, http.StatusUnauthorized:
-				return emptyImage()
+				return emptyImage(i.platform)
 			}
 		}
 		return nil, fmt.Errorf("connect to repo store '%s': %s", repoName, err.Error())
@@ -103,10 +326,27 @@ func newV1Image(keychain authn.Keychain, repoName string) (v1.Image, error) {
 	return image, nil
 }
 
-func emptyImage() (v1.Image, error) {
-	cfg := &v1.ConfigFile{
+// fetchImage reads the image at ref, retrying without credentials when auth is rejected and anonymous fallback is enabled
+func (i *Image) fetchImage(ref name.Reference, auth authn.Authenticator) (v1.Image, error) {
+	image, err := remote.Image(ref, i.remoteOptions(auth)...)
+	if err != nil && i.anonymousFallback && auth != authn.Anonymous && isUnauthorized(err) {
+		return remote.Image(ref, i.remoteOptions(authn.Anonymous)...)
+	}
+	return image, err
+}
+
+func defaultPlatform() v1.Platform {
+	return v1.Platform{
 		OS:           "linux",
 		Architecture: "amd64",
+	}
+}
+
+func emptyImage(platform v1.Platform) (v1.Image, error) {
+	cfg := &v1.ConfigFile{
+		OS:           platform.OS,
+		OSVersion:    platform.OSVersion,
+		Architecture: platform.Architecture,
 		RootFS: v1.RootFS{
 			Type:    "layers",
 			DiffIDs: []v1.Hash{},
@@ -115,310 +355,1225 @@ func emptyImage() (v1.Image, error) {
 	return mutate.ConfigFile(empty.Image, cfg)
 }
 
-func referenceForRepoName(keychain authn.Keychain, ref string) (name.Reference, authn.Authenticator, error) {
+// referenceForRepoName returns the reference to connect to for ref, rewritten to a mirror when one is
+// configured for its registry, and the authenticator for the original registry.
+func (i *Image) referenceForRepoName(ref string) (name.Reference, authn.Authenticator, error) {
 	var auth authn.Authenticator
-	r, err := name.ParseReference(ref, name.WeakValidation)
+	r, err := name.ParseReference(ref, i.nameOptions()...)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	auth, err = keychain.Resolve(r.Context().Registry)
+	auth, err = i.keychain.Resolve(r.Context().Registry)
 	if err != nil {
 		return nil, nil, err
 	}
+
+	if mirror, ok := i.registryMirrors[r.Context().RegistryStr()]; ok {
+		if r, err = mirrorReference(r, mirror, i.nameOptions()...); err != nil {
+			return nil, nil, err
+		}
+	}
 	return r, auth, nil
 }
 
-func (i *Image) Label(key string) (string, error) {
-	cfg, err := i.image.ConfigFile()
-	if err != nil || cfg == nil {
-		return "", fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+func (i *Image) remoteOptions(auth authn.Authenticator) []remote.Option {
+	return []remote.Option{
+		remote.WithAuth(auth),
+		remote.WithTransport(i.transport),
+		remote.WithPlatform(i.platform),
 	}
-	labels := cfg.Config.Labels
-	return labels[key], nil
 }
 
-func (i *Image) Labels() (map[string]string, error) {
-	cfg, err := i.image.ConfigFile()
-	if err != nil || cfg == nil {
-		return nil, fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+func (i *Image) nameOptions() []name.Option {
+	opts := []name.Option{name.WeakValidation}
+	if i.strictValidation {
+		opts = []name.Option{name.StrictValidation}
+	}
+	if i.insecure {
+		opts = append(opts, name.Insecure)
 	}
-	return cfg.Config.Labels, nil
+	return opts
 }
 
-func (i *Image) Env(key string) (string, error) {
-	cfg, err := i.image.ConfigFile()
-	if err != nil || cfg == nil {
-		return "", fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+func mirrorReference(ref name.Reference, mirror string, opts ...name.Option) (name.Reference, error) {
+	repo, err := name.NewRepository(mirror+"/"+ref.Context().RepositoryStr(), opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse mirror reference for '%s'", ref.Name())
 	}
-	for _, envVar := range cfg.Config.Env {
-		parts := strings.Split(envVar, "=")
-		if parts[0] == key {
-			return parts[1], nil
-		}
+	if digest, ok := ref.(name.Digest); ok {
+		return repo.Digest(digest.DigestStr()), nil
 	}
-	return "", nil
+	return repo.Tag(ref.Identifier()), nil
 }
 
-func (i *Image) OS() (string, error) {
+// config returns the image's runtime config, including staged changes that have not yet been committed.
+func (i *Image) config() (*v1.Config, error) {
+	if i.pendingConfig != nil {
+		return i.pendingConfig, nil
+	}
 	cfg, err := i.image.ConfigFile()
-	if err != nil || cfg == nil || cfg.OS == "" {
-		return "", fmt.Errorf("failed to get OS from config file for image '%s'", i.repoName)
+	if err != nil {
+		if transportErr, ok := err.(*transport.Error); ok && transportErr.StatusCode == http.StatusNotFound {
+			return nil, imgutil.NotFoundError{Message: fmt.Sprintf("failed to get config file for image '%s': %s", i.repoName, err)}
+		}
+		return nil, errors.Wrapf(err, "failed to get config file for image '%s'", i.repoName)
 	}
-	return cfg.OS, nil
+	if cfg == nil {
+		return nil, imgutil.NotFoundError{Message: fmt.Sprintf("failed to get config file for image '%s'", i.repoName)}
+	}
+	return &cfg.Config, nil
 }
 
-func (i *Image) OSVersion() (string, error) {
-	cfg, err := i.image.ConfigFile()
-	if err != nil || cfg == nil {
-		return "", fmt.Errorf("failed to get OSVersion from config file for image '%s'", i.repoName)
+// stagedConfig returns a copy of the runtime config that setters modify in place until Commit is called.
+func (i *Image) stagedConfig() (*v1.Config, error) {
+	if i.pendingConfig == nil {
+		config, err := i.config()
+		if err != nil {
+			return nil, err
+		}
+		i.pendingConfig = config.DeepCopy()
 	}
-	return cfg.OSVersion, nil
+	return i.pendingConfig, nil
 }
 
-func (i *Image) Architecture() (string, error) {
-	cfg, err := i.image.ConfigFile()
-	if err != nil || cfg == nil || cfg.Architecture == "" {
-		return "", fmt.Errorf("failed to get Architecture from config file for image '%s'", i.repoName)
+// Commit applies staged config and media type changes to the underlying image. Save, Rebase and Identifier call
+// it automatically.
+func (i *Image) Commit() error {
+	if i.pendingConfig != nil {
+		image, err := mutate.Config(i.image, *i.pendingConfig)
+		if err != nil {
+			return errors.Wrap(err, "apply staged config")
+		}
+		i.image = image
+		i.pendingConfig = nil
 	}
-	return cfg.Architecture, nil
-}
 
-func (i *Image) Rename(name string) {
-	i.repoName = name
+	if i.mediaType != "" {
+		image, err := convertMediaType(i.image, i.mediaType)
+		if err != nil {
+			return errors.Wrapf(err, "convert image '%s' to media type '%s'", i.repoName, i.mediaType)
+		}
+		i.image = image
+	}
+	return nil
 }
 
-func (i *Image) Name() string {
-	return i.repoName
+// MediaType returns the manifest media type the image will be saved with.
+func (i *Image) MediaType() (types.MediaType, error) {
+	if i.mediaType != "" {
+		return i.mediaType, nil
+	}
+	return i.image.MediaType()
 }
 
-func (i *Image) Found() bool {
-	ref, auth, err := referenceForRepoName(i.keychain, i.repoName)
-	if err != nil {
-		return false
+// SetMediaType converts the image to types.OCIManifestSchema1 or types.DockerManifestSchema2 when it is next
+// committed, including the config and layer media types. Layers added afterwards are converted as well.
+func (i *Image) SetMediaType(mt types.MediaType) error {
+	if _, ok := manifestMediaTypes[mt]; !ok {
+		return fmt.Errorf("unsupported manifest media type '%s'", mt)
 	}
-	_, err = remote.Image(ref, remote.WithAuth(auth), remote.WithTransport(http.DefaultTransport))
-	return err == nil
+	i.mediaType = mt
+	return nil
 }
 
-func (i *Image) Identifier() (imgutil.Identifier, error) {
-	ref, err := name.ParseReference(i.repoName, name.WeakValidation)
+func (i *Image) Label(key string) (string, error) {
+	config, err := i.config()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse reference for image '%s': %s", i.repoName, err)
+		return "", err
 	}
+	labels := config.Labels
+	return labels[key], nil
+}
 
-	hash, err := i.image.Digest()
+func (i *Image) Labels() (map[string]string, error) {
+	config, err := i.config()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get digest for image '%s': %s", i.repoName, err)
+		return nil, err
 	}
-
-	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", ref.Context().Name(), hash.String()), name.WeakValidation)
-	if err != nil {
-		return nil, errors.Wrap(err, "creating digest reference")
+	copiedLabels := make(map[string]string, len(config.Labels))
+	for k, v := range config.Labels {
+		copiedLabels[k] = v
 	}
-
-	return DigestIdentifier{
-		Digest: digestRef,
-	}, nil
+	return copiedLabels, nil
 }
 
-func (i *Image) CreatedAt() (time.Time, error) {
-	configFile, err := i.image.ConfigFile()
+func (i *Image) Env(key string) (string, error) {
+	config, err := i.config()
 	if err != nil {
-		return time.Time{}, fmt.Errorf("failed to get createdAt time for image '%s': %s", i.repoName, err)
+		return "", err
 	}
-	return configFile.Created.UTC(), nil
+	for _, envVar := range config.Env {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) == 2 && parts[0] == key {
+			return parts[1], nil
+		}
+	}
+	return "", nil
 }
 
-func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
-	newBaseRemote, ok := newBase.(*Image)
-	if !ok {
-		return errors.New("expected new base to be a remote image")
+func (i *Image) EnvVars() ([]string, error) {
+	config, err := i.config()
+	if err != nil {
+		return nil, err
 	}
+	return append([]string{}, config.Env...), nil
+}
 
-	newImage, err := mutate.Rebase(i.image, &subImage{img: i.image, topDiffID: baseTopLayer}, newBaseRemote.image)
+func (i *Image) EnvMap() (map[string]string, error) {
+	config, err := i.config()
 	if err != nil {
-		return errors.Wrap(err, "rebase")
+		return nil, err
 	}
+	env := map[string]string{}
+	for _, envVar := range config.Env {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env, nil
+}
 
-	newImageConfig, err := newImage.ConfigFile()
+func (i *Image) WorkingDir() (string, error) {
+	config, err := i.config()
 	if err != nil {
-		return err
+		return "", err
 	}
+	return config.WorkingDir, nil
+}
 
-	newBaseRemoteConfig, err := newBaseRemote.image.ConfigFile()
+func (i *Image) StopSignal() (string, error) {
+	config, err := i.config()
 	if err != nil {
-		return err
+		return "", err
 	}
+	return config.StopSignal, nil
+}
 
-	newImageConfig.Architecture = newBaseRemoteConfig.Architecture
-	newImageConfig.OS = newBaseRemoteConfig.OS
-	newImageConfig.OSVersion = newBaseRemoteConfig.OSVersion
-
-	newImage, err = mutate.ConfigFile(newImage, newImageConfig)
+func (i *Image) OnBuild() ([]string, error) {
+	config, err := i.config()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	i.image = newImage
-	return nil
+	return config.OnBuild, nil
 }
 
-func (i *Image) SetLabel(key, val string) error {
-	configFile, err := i.image.ConfigFile()
+func (i *Image) Shell() ([]string, error) {
+	config, err := i.config()
 	if err != nil {
-		return err
-	}
-	config := *configFile.Config.DeepCopy()
-	if config.Labels == nil {
-		config.Labels = map[string]string{}
+		return nil, err
 	}
-	config.Labels[key] = val
-	i.image, err = mutate.Config(i.image, config)
-	return err
+	return config.Shell, nil
 }
 
-func (i *Image) RemoveLabel(key string) error {
-	cfg, err := i.image.ConfigFile()
-	if err != nil || cfg == nil {
-		return fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+func (i *Image) User() (string, error) {
+	config, err := i.config()
+	if err != nil {
+		return "", err
 	}
-	config := *cfg.Config.DeepCopy()
-	delete(config.Labels, key)
-	i.image, err = mutate.Config(i.image, config)
-	return err
+	return config.User, nil
 }
 
-func (i *Image) SetEnv(key, val string) error {
-	configFile, err := i.image.ConfigFile()
+func (i *Image) ExposedPorts() (map[string]struct{}, error) {
+	config, err := i.config()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	config := *configFile.Config.DeepCopy()
-	ignoreCase := configFile.OS == "windows"
-	for idx, e := range config.Env {
-		parts := strings.Split(e, "=")
-		foundKey := parts[0]
-		searchKey := key
-		if ignoreCase {
-			foundKey = strings.ToUpper(foundKey)
-			searchKey = strings.ToUpper(searchKey)
-		}
-		if foundKey == searchKey {
-			config.Env[idx] = fmt.Sprintf("%s=%s", key, val)
-			i.image, err = mutate.Config(i.image, config)
-			if err != nil {
-				return err
-			}
-			return nil
-		}
+	ports := make(map[string]struct{}, len(config.ExposedPorts))
+	for port := range config.ExposedPorts {
+		ports[port] = struct{}{}
 	}
-	config.Env = append(config.Env, fmt.Sprintf("%s=%s", key, val))
-	i.image, err = mutate.Config(i.image, config)
-	return err
+	return ports, nil
 }
 
-func (i *Image) SetWorkingDir(dir string) error {
-	configFile, err := i.image.ConfigFile()
+func (i *Image) Volumes() (map[string]struct{}, error) {
+	config, err := i.config()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	config := *configFile.Config.DeepCopy()
-	config.WorkingDir = dir
-	i.image, err = mutate.Config(i.image, config)
-	return err
+	volumes := make(map[string]struct{}, len(config.Volumes))
+	for path := range config.Volumes {
+		volumes[path] = struct{}{}
+	}
+	return volumes, nil
 }
 
-func (i *Image) SetEntrypoint(ep ...string) error {
-	configFile, err := i.image.ConfigFile()
+func (i *Image) Healthcheck() (*imgutil.HealthConfig, error) {
+	config, err := i.config()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	hc := config.Healthcheck
+	if hc == nil {
+		return nil, nil
+	}
+	return &imgutil.HealthConfig{
+		Test:        append([]string(nil), hc.Test...),
+		Interval:    hc.Interval,
+		Timeout:     hc.Timeout,
+		StartPeriod: hc.StartPeriod,
+		Retries:     hc.Retries,
+	}, nil
+}
+
+func (i *Image) OS() (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil || cfg.OS == "" {
+		return "", fmt.Errorf("failed to get OS from config file for image '%s'", i.repoName)
+	}
+	return cfg.OS, nil
+}
+
+func (i *Image) OSVersion() (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil {
+		return "", fmt.Errorf("failed to get OSVersion from config file for image '%s'", i.repoName)
+	}
+	return cfg.OSVersion, nil
+}
+
+func (i *Image) Architecture() (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil || cfg.Architecture == "" {
+		return "", fmt.Errorf("failed to get Architecture from config file for image '%s'", i.repoName)
+	}
+	return cfg.Architecture, nil
+}
+
+func (i *Image) Rename(name string) {
+	i.repoName = name
+}
+
+// Clone returns a copy of the image named newName, including any staged config changes.
+func (i *Image) Clone(newName string) (imgutil.Image, error) {
+	clone := *i
+	clone.repoName = newName
+	if i.pendingConfig != nil {
+		clone.pendingConfig = i.pendingConfig.DeepCopy()
+	}
+	clone.prevLayers = append([]v1.Layer(nil), i.prevLayers...)
+	clone.tempLayers = nil
+	if i.registryMirrors != nil {
+		clone.registryMirrors = make(map[string]string, len(i.registryMirrors))
+		for registry, mirror := range i.registryMirrors {
+			clone.registryMirrors[registry] = mirror
+		}
+	}
+	return &clone, nil
+}
+
+func (i *Image) Name() string {
+	return i.repoName
+}
+
+func (i *Image) Registry() string {
+	return imgutil.RegistryFromName(i.repoName, i.nameOptions()...)
+}
+
+func (i *Image) Repository() string {
+	return imgutil.RepositoryFromName(i.repoName, i.nameOptions()...)
+}
+
+func (i *Image) Tag() (string, error) {
+	return imgutil.TagFromName(i.repoName, i.nameOptions()...)
+}
+
+// Found reports whether the image exists in the registry. It returns false for any error, including denied access;
+// use CheckFound to tell those apart.
+func (i *Image) Found() bool {
+	found, _ := i.CheckFound()
+	return found
+}
+
+// CheckFound reports whether the image exists in the registry. Only a missing manifest or repository is reported
+// as false with a nil error. Rejected credentials return an error caused by ErrUnauthorized, and any other
+// failure to reach the registry is returned as is.
+func (i *Image) CheckFound() (bool, error) {
+	ref, auth, err := i.referenceForRepoName(i.repoName)
+	if err != nil {
+		return false, err
+	}
+	_, err = i.fetchImage(ref, auth)
+	if err == nil {
+		return true, nil
+	}
+
+	if transportErr, ok := err.(*transport.Error); ok {
+		for _, diagnostic := range transportErr.Errors {
+			switch diagnostic.Code {
+			case transport.ManifestUnknownErrorCode, transport.NameUnknownErrorCode:
+				return false, nil
+			case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+				return false, errors.Wrapf(ErrUnauthorized, "find image '%s'", i.repoName)
+			}
+		}
+		switch transportErr.StatusCode {
+		case http.StatusNotFound:
+			return false, nil
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return false, errors.Wrapf(ErrUnauthorized, "find image '%s'", i.repoName)
+		}
+	}
+	return false, errors.Wrapf(err, "find image '%s'", i.repoName)
+}
+
+// Exists reports whether the image exists in the registry, with the same errors as CheckFound. It only issues a HEAD
+// request for the manifest instead of downloading it, so it is the cheaper way to probe many references.
+func (i *Image) Exists() (bool, error) {
+	ref, auth, err := i.referenceForRepoName(i.repoName)
+	if err != nil {
+		return false, err
+	}
+	status, err := i.headManifest(ref, auth)
+	if err == nil && status == http.StatusUnauthorized && i.anonymousFallback && auth != authn.Anonymous {
+		status, err = i.headManifest(ref, authn.Anonymous)
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "find image '%s'", i.repoName)
+	}
+
+	switch status {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, errors.Wrapf(ErrUnauthorized, "find image '%s'", i.repoName)
+	}
+	return false, fmt.Errorf("find image '%s': unexpected status code %d", i.repoName, status)
+}
+
+// headManifest issues a HEAD request for the manifest of ref and returns the response status
+func (i *Image) headManifest(ref name.Reference, auth authn.Authenticator) (int, error) {
+	resp, err := i.requestManifest(http.MethodHead, ref, auth)
+	if err != nil {
+		if transportErr, ok := err.(*transport.Error); ok {
+			return transportErr.StatusCode, nil
+		}
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// requestManifest sends a request with method for the manifest of ref. The caller must close the response body.
+func (i *Image) requestManifest(method string, ref name.Reference, auth authn.Authenticator) (*http.Response, error) {
+	tr, err := transport.New(ref.Context().Registry, auth, i.transport, []string{ref.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, err
+	}
+
+	u := url.URL{
+		Scheme: ref.Context().Registry.Scheme(),
+		Host:   ref.Context().RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", ref.Context().RepositoryStr(), ref.Identifier()),
+	}
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		string(types.DockerManifestSchema2),
+		string(types.OCIManifestSchema1),
+		string(types.DockerManifestList),
+		string(types.OCIImageIndex),
+	}, ","))
+
+	return (&http.Client{Transport: tr}).Do(req)
+}
+
+func (i *Image) Identifier() (imgutil.Identifier, error) {
+	ref, err := name.ParseReference(i.repoName, i.nameOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference for image '%s': %s", i.repoName, err)
+	}
+
+	if err := i.Commit(); err != nil {
+		return nil, err
+	}
+
+	hash, err := i.image.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get digest for image '%s': %s", i.repoName, err)
+	}
+
+	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", ref.Context().Name(), hash.String()), name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating digest reference")
+	}
+
+	return DigestIdentifier{
+		Digest: digestRef,
+	}, nil
+}
+
+func (i *Image) CreatedAt() (time.Time, error) {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get createdAt time for image '%s': %s", i.repoName, err)
+	}
+	if configFile == nil {
+		return time.Time{}, imgutil.NotFoundError{Message: fmt.Sprintf("failed to get config file for image '%s'", i.repoName)}
+	}
+	return configFile.Created.UTC(), nil
+}
+
+// Rebase replaces the layers up to and including baseTopLayer with the layers of newBase. The new base may be
+// from any backend; layers of a base that is not a remote image are read through GetLayer.
+func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image, opts ...imgutil.RebaseOption) error {
+	plan, err := i.RebasePlan(baseTopLayer, newBase, opts...)
+	if err != nil {
+		return err
+	}
+	if err := i.Commit(); err != nil {
+		return err
+	}
+	newBaseImage, err := v1Image(newBase)
+	if err != nil {
+		return err
+	}
+
+	newImage, err := mutate.Rebase(i.image, &subImage{img: i.image, numLayers: len(plan.RemovedLayers)}, newBaseImage)
+	if err != nil {
+		return errors.Wrap(err, "rebase")
+	}
+
+	newImageConfig, err := newImage.ConfigFile()
+	if err != nil {
+		return err
+	}
+
+	newBaseConfig, err := newBaseImage.ConfigFile()
+	if err != nil {
+		return err
+	}
+
+	newImageConfig.Architecture = newBaseConfig.Architecture
+	newImageConfig.OS = newBaseConfig.OS
+	newImageConfig.OSVersion = newBaseConfig.OSVersion
+
+	newImage, err = mutate.ConfigFile(newImage, newImageConfig)
+	if err != nil {
+		return err
+	}
+
+	i.image = newImage
+	// the history of the new base replaces the entries of the removed layers
+	if i.recordedHistory > len(plan.KeptLayers) {
+		i.recordedHistory = len(plan.KeptLayers)
+	}
+	return nil
+}
+
+func (i *Image) RebasePlan(baseTopLayer string, newBase imgutil.Image, opts ...imgutil.RebaseOption) (*imgutil.RebaseResult, error) {
+	return imgutil.PlanRebase(i, baseTopLayer, newBase, opts...)
+}
+
+// v1Image returns image as a v1.Image. Images from other backends are read through the imgutil.Image interface.
+func v1Image(image imgutil.Image) (v1.Image, error) {
+	if remoteImage, ok := image.(*Image); ok {
+		if err := remoteImage.Commit(); err != nil {
+			return nil, err
+		}
+		return remoteImage.image, nil
+	}
+
+	var (
+		platform v1.Platform
+		err      error
+	)
+	if platform.OS, err = image.OS(); err != nil {
+		return nil, err
+	}
+	if platform.OSVersion, err = image.OSVersion(); err != nil {
+		return nil, err
+	}
+	if platform.Architecture, err = image.Architecture(); err != nil {
+		return nil, err
+	}
+	base, err := emptyImage(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	diffIDs, err := image.LayerDiffIDs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get layers of image '%s'", image.Name())
+	}
+	layers := make([]v1.Layer, len(diffIDs))
+	for idx, diffID := range diffIDs {
+		diffID := diffID
+		layers[idx], err = tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return image.GetLayer(diffID)
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "read layer '%s' of image '%s'", diffID, image.Name())
+		}
+	}
+	return mutate.AppendLayers(base, layers...)
+}
+
+func (i *Image) SetLabel(key, val string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	config.Labels[key] = val
+	return nil
+}
+
+func (i *Image) SetLabels(labels map[string]string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	for key, val := range labels {
+		config.Labels[key] = val
+	}
+	return nil
+}
+
+func (i *Image) SetLabelsFromFile(path string) error {
+	labels, err := imgutil.ReadLabelsFile(path)
+	if err != nil {
+		return err
+	}
+	return i.SetLabels(labels)
+}
+
+// Annotations returns the annotations on the image manifest.
+func (i *Image) Annotations() (map[string]string, error) {
+	manifest, err := i.image.Manifest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get manifest for image '%s'", i.repoName)
+	}
+	annotations := make(map[string]string, len(manifest.Annotations))
+	for k, v := range manifest.Annotations {
+		annotations[k] = v
+	}
+	return annotations, nil
+}
+
+// SetAnnotation sets an annotation on the image manifest. Annotations are carried through later changes to
+// the image but are dropped by Rebase.
+func (i *Image) SetAnnotation(key, val string) error {
+	annotations, err := i.Annotations()
+	if err != nil {
+		return err
+	}
+	annotations[key] = val
+	i.image = &manifestImage{Image: i.image, annotations: annotations}
+	return nil
+}
+
+func (i *Image) RemoveLabel(key string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get config file for image '%s'", i.repoName)
+	}
+	delete(config.Labels, key)
+	return nil
+}
+
+func (i *Image) SetEnv(key, val string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	ignoreCase := configFile.OS == "windows"
+	for idx, e := range config.Env {
+		parts := strings.SplitN(e, "=", 2)
+		foundKey := parts[0]
+		searchKey := key
+		if ignoreCase {
+			foundKey = strings.ToUpper(foundKey)
+			searchKey = strings.ToUpper(searchKey)
+		}
+		if foundKey == searchKey {
+			config.Env[idx] = fmt.Sprintf("%s=%s", key, val)
+			return nil
+		}
+	}
+	config.Env = append(config.Env, fmt.Sprintf("%s=%s", key, val))
+	return nil
+}
+
+func (i *Image) RemoveEnv(key string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	ignoreCase := configFile.OS == "windows"
+	var env []string
+	for _, e := range config.Env {
+		foundKey := strings.SplitN(e, "=", 2)[0]
+		if foundKey == key || (ignoreCase && strings.EqualFold(foundKey, key)) {
+			continue
+		}
+		env = append(env, e)
+	}
+	config.Env = env
+	return nil
+}
+
+func (i *Image) SetWorkingDir(dir string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	config.WorkingDir = dir
+	return nil
+}
+
+func (i *Image) SetStopSignal(sig string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	config.StopSignal = sig
+	return nil
+}
+
+func (i *Image) SetOnBuild(triggers ...string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	config.OnBuild = triggers
+	return nil
+}
+
+func (i *Image) SetShell(shell ...string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	config.Shell = shell
+	return nil
+}
+
+func (i *Image) SetUser(user string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	config.User = user
+	return nil
+}
+
+func (i *Image) SetExposedPort(port string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	if config.ExposedPorts == nil {
+		config.ExposedPorts = map[string]struct{}{}
+	}
+	config.ExposedPorts[port] = struct{}{}
+	return nil
+}
+
+func (i *Image) RemoveExposedPort(port string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	delete(config.ExposedPorts, port)
+	return nil
+}
+
+func (i *Image) SetVolume(path string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	if config.Volumes == nil {
+		config.Volumes = map[string]struct{}{}
+	}
+	config.Volumes[path] = struct{}{}
+	return nil
+}
+
+func (i *Image) RemoveVolume(path string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	delete(config.Volumes, path)
+	return nil
+}
+
+func (i *Image) SetHealthcheck(hc *imgutil.HealthConfig) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	config.Healthcheck = nil
+	if hc != nil {
+		config.Healthcheck = &v1.HealthConfig{
+			Test:        append([]string(nil), hc.Test...),
+			Interval:    hc.Interval,
+			Timeout:     hc.Timeout,
+			StartPeriod: hc.StartPeriod,
+			Retries:     hc.Retries,
+		}
+	}
+	return nil
+}
+
+func (i *Image) SetEntrypoint(ep ...string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
 	}
-	config := *configFile.Config.DeepCopy()
 	config.Entrypoint = ep
-	i.image, err = mutate.Config(i.image, config)
+	return nil
+}
+
+func (i *Image) SetCmd(cmd ...string) error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	config.Cmd = cmd
+	return nil
+}
+
+func (i *Image) ClearEntrypoint() error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	config.Entrypoint = nil
+	return nil
+}
+
+func (i *Image) ClearCmd() error {
+	config, err := i.stagedConfig()
+	if err != nil {
+		return err
+	}
+	config.Cmd = nil
+	return nil
+}
+
+// SetConfig replaces the image's runtime config with a copy of cfg, including fields that have no typed setter, such
+// as OnBuild and ArgsEscaped. Like the typed setters, the change is applied when the image is committed.
+func (i *Image) SetConfig(cfg *v1.Config) error {
+	if cfg == nil {
+		return errors.New("config must not be nil")
+	}
+	i.pendingConfig = cfg.DeepCopy()
+	return nil
+}
+
+func (i *Image) SetOS(osVal string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	configFile.OS = osVal
+	i.image, err = mutate.ConfigFile(i.image, configFile)
+	return err
+}
+
+func (i *Image) SetOSVersion(osVersion string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	configFile.OSVersion = osVersion
+	i.image, err = mutate.ConfigFile(i.image, configFile)
+	return err
+}
+
+func (i *Image) SetArchitecture(architecture string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	configFile.Architecture = architecture
+	i.image, err = mutate.ConfigFile(i.image, configFile)
 	return err
 }
 
-func (i *Image) SetCmd(cmd ...string) error {
-	configFile, err := i.image.ConfigFile()
+func (i *Image) TopLayer() (string, error) {
+	all, err := i.image.Layers()
+	if err != nil {
+		return "", err
+	}
+	if len(all) == 0 {
+		return "", fmt.Errorf("image %s has no layers", i.Name())
+	}
+	topLayer := all[len(all)-1]
+	hex, err := topLayer.DiffID()
+	if err != nil {
+		return "", err
+	}
+	return hex.String(), nil
+}
+
+func (i *Image) LayerDiffIDs() ([]string, error) {
+	layers, err := i.image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := make([]string, len(layers))
+	for idx, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, errors.Wrap(err, "get layer diff ID")
+		}
+		diffIDs[idx] = diffID.String()
+	}
+	return diffIDs, nil
+}
+
+// LayerDigests returns the compressed digest of every layer as listed in the manifest, from the bottom layer
+// to the top layer.
+func (i *Image) LayerDigests() ([]string, error) {
+	if err := i.Commit(); err != nil {
+		return nil, err
+	}
+
+	manifest, err := i.image.Manifest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get manifest for image '%s'", i.repoName)
+	}
+	digests := make([]string, len(manifest.Layers))
+	for idx, desc := range manifest.Layers {
+		digests[idx] = desc.Digest.String()
+	}
+	return digests, nil
+}
+
+func (i *Image) NumberOfLayers() (int, error) {
+	layers, err := i.image.Layers()
+	if err != nil {
+		return 0, err
+	}
+	return len(layers), nil
+}
+
+// Size returns the sum of the compressed layer sizes and the config blob size.
+func (i *Image) Size() (int64, error) {
+	if err := i.Commit(); err != nil {
+		return 0, err
+	}
+
+	manifest, err := i.image.Manifest()
+	if err != nil {
+		return 0, errors.Wrapf(err, "get manifest for image '%s'", i.repoName)
+	}
+
+	layers, err := i.image.Layers()
+	if err != nil {
+		return 0, err
+	}
+
+	size := manifest.Config.Size
+	for _, layer := range layers {
+		layerSize, err := layer.Size()
+		if err != nil {
+			return 0, errors.Wrap(err, "get layer size")
+		}
+		size += layerSize
+	}
+	return size, nil
+}
+
+func (i *Image) RawConfig() ([]byte, error) {
+	if err := i.Commit(); err != nil {
+		return nil, err
+	}
+	return i.image.RawConfigFile()
+}
+
+func (i *Image) ConfigFile() (*v1.ConfigFile, error) {
+	if err := i.Commit(); err != nil {
+		return nil, err
+	}
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get config file for image '%s'", i.repoName)
+	}
+	return cfg.DeepCopy(), nil
+}
+
+// Manifest returns the manifest the image would be saved with, including its config and layer descriptors
+// and annotations.
+func (i *Image) Manifest() (*v1.Manifest, error) {
+	if err := i.Commit(); err != nil {
+		return nil, err
+	}
+	return i.image.Manifest()
+}
+
+func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
+	layer, err := i.layerWithSha(sha)
+	if err != nil {
+		return nil, err
+	}
+	return layer.Uncompressed()
+}
+
+// GetCompressedLayer is like GetLayer, but returns the compressed contents of the layer, as stored in the registry.
+func (i *Image) GetCompressedLayer(sha string) (io.ReadCloser, error) {
+	layer, err := i.layerWithSha(sha)
+	if err != nil {
+		return nil, err
+	}
+	return layer.Compressed()
+}
+
+func (i *Image) layerWithSha(sha string) (v1.Layer, error) {
+	layers, err := i.image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := findLayerWithSha(layers, imgutil.NormalizeDiffID(sha))
+	if errors.Is(err, imgutil.ErrLayerNotFound) {
+		return nil, fmt.Errorf("image '%s' does not contain layer with diff ID '%s'", i.repoName, sha)
+	}
+	return layer, err
+}
+
+// GetLayerByDigest is like GetLayer, but finds the layer by the digest of its compressed contents, as listed in the
+// image manifest.
+func (i *Image) GetLayerByDigest(digest string) (io.ReadCloser, error) {
+	hash, err := v1.NewHash(digest)
 	if err != nil {
-		return err
+		return nil, errors.Wrapf(err, "parse layer digest '%s'", digest)
 	}
-	config := *configFile.Config.DeepCopy()
-	config.Cmd = cmd
-	i.image, err = mutate.Config(i.image, config)
-	return err
+	layer, err := i.image.LayerByDigest(hash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "image '%s' does not contain layer with digest '%s'", i.repoName, digest)
+	}
+	return layer.Uncompressed()
 }
 
-func (i *Image) SetOS(osVal string) error {
-	configFile, err := i.image.ConfigFile()
+func (i *Image) AddLayer(path string, opts ...imgutil.LayerOption) error {
+	layer, err := tarball.LayerFromFile(path, i.layerOptions...)
 	if err != nil {
 		return err
 	}
-	configFile.OS = osVal
-	i.image, err = mutate.ConfigFile(i.image, configFile)
-	return err
+
+	var history v1.History
+	for _, opt := range opts {
+		opt(&history)
+	}
+	if err := i.appendLayer(layer, history); err != nil {
+		return errors.Wrap(err, "add layer")
+	}
+	return nil
 }
 
-func (i *Image) SetOSVersion(osVersion string) error {
-	configFile, err := i.image.ConfigFile()
+// appendLayer adds layer to the top of the image, recording history for it
+func (i *Image) appendLayer(layer v1.Layer, history v1.History) error {
+	image, err := mutate.Append(i.image, mutate.Addendum{Layer: layer, History: history})
 	if err != nil {
 		return err
 	}
-	configFile.OSVersion = osVersion
-	i.image, err = mutate.ConfigFile(i.image, configFile)
-	return err
+	i.image = image
+	i.recordedHistory++
+	return nil
 }
 
-func (i *Image) SetArchitecture(architecture string) error {
-	configFile, err := i.image.ConfigFile()
+// AddLayerFromReader adds a layer from the tar contents of r, which are written to a temp file that Close removes
+// rather than held in memory.
+func (i *Image) AddLayerFromReader(r io.Reader) error {
+	path, err := i.writeTempLayer(func(f *os.File) error {
+		_, err := io.Copy(f, r)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "add layer")
+	}
+	layer, err := tarball.LayerFromFile(path, i.layerOptions...)
 	if err != nil {
 		return err
 	}
-	configFile.Architecture = architecture
-	i.image, err = mutate.ConfigFile(i.image, configFile)
-	return err
+	if err := i.appendLayer(layer, v1.History{}); err != nil {
+		return errors.Wrap(err, "add layer")
+	}
+	return nil
 }
 
-func (i *Image) TopLayer() (string, error) {
-	all, err := i.image.Layers()
+// writeTempLayer creates a temp file, which Close removes, and fills it with write
+func (i *Image) writeTempLayer(write func(f *os.File) error) (string, error) {
+	f, err := ioutil.TempFile("", "imgutil.remote.layer.")
 	if err != nil {
-		return "", err
+		return "", errors.Wrap(err, "create temp file")
 	}
-	if len(all) == 0 {
-		return "", fmt.Errorf("image %s has no layers", i.Name())
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
 	}
-	topLayer := all[len(all)-1]
-	hex, err := topLayer.DiffID()
 	if err != nil {
-		return "", err
+		os.Remove(f.Name())
+		return "", errors.Wrapf(err, "write layer: %s", f.Name())
 	}
-	return hex.String(), nil
+	i.tempLayers = append(i.tempLayers, f.Name())
+	return f.Name(), nil
 }
 
-func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
+// Close removes any temporary files created for the image, such as layers added by AddLayerFromReader or Squash.
+// Callers should defer Close once they are done with the image, since layers backed by those files can't be read
+// after it.
+func (i *Image) Close() error {
+	var err error
+	for _, path := range i.tempLayers {
+		if rmErr := os.Remove(path); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+			err = rmErr
+		}
+	}
+	i.tempLayers = nil
+	return err
+}
+
+// AddLayerFromImage adds the layer with diffID from src, which may be an image from any backend. Layers of remote
+// images are added as they are, so that they can be mounted from the source repository on save instead of being
+// downloaded and uploaded again; layers of other images are read through src.GetLayer.
+func (i *Image) AddLayerFromImage(src imgutil.Image, diffID string) error {
+	var (
+		layer v1.Layer
+		err   error
+	)
+	if srcImage, ok := src.(*Image); ok {
+		if layer, err = srcImage.layerWithSha(diffID); err != nil {
+			return err
+		}
+	} else {
+		layer, err = tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return src.GetLayer(diffID)
+		}, i.layerOptions...)
+		if err != nil {
+			return errors.Wrapf(err, "get layer '%s' from image '%s'", diffID, src.Name())
+		}
+		actual, err := layer.DiffID()
+		if err != nil {
+			return errors.Wrapf(err, "get layer '%s' from image '%s'", diffID, src.Name())
+		}
+		if actual.String() != diffID {
+			return fmt.Errorf("layer '%s' from image '%s' has diff ID '%s'", diffID, src.Name(), actual)
+		}
+	}
+
+	if err := i.appendLayer(layer, v1.History{}); err != nil {
+		return errors.Wrap(err, "add layer")
+	}
+	return nil
+}
+
+// Squash replaces the image's layers with a single layer holding the filesystem they produce together. The layer is
+// written to a temp file that Close removes, so that it is read from disk rather than held in memory.
+func (i *Image) Squash() error {
+	if err := i.Commit(); err != nil {
+		return err
+	}
+
 	layers, err := i.image.Layers()
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "get image layers")
+	}
+	if len(layers) == 0 {
+		return nil
 	}
 
-	layer, err := findLayerWithSha(layers, sha)
+	path, err := i.writeTempLayer(func(f *os.File) error {
+		return squashLayers(f, layers)
+	})
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "squash layers")
+	}
+	squashed, err := tarball.LayerFromFile(path, i.layerOptions...)
+	if err != nil {
+		return errors.Wrap(err, "squash layers")
 	}
 
-	return layer.Uncompressed()
-}
+	manifest, err := i.image.Manifest()
+	if err != nil {
+		return errors.Wrapf(err, "get manifest for image '%s'", i.repoName)
+	}
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	configFile = configFile.DeepCopy()
+	configFile.RootFS.DiffIDs = nil
+	configFile.History = nil
 
-func (i *Image) AddLayer(path string) error {
-	layer, err := tarball.LayerFromFile(path)
+	mediaType, err := i.image.MediaType()
 	if err != nil {
 		return err
 	}
-	i.image, err = mutate.AppendLayers(i.image, layer)
+	image, err := mutate.ConfigFile(mutate.MediaType(empty.Image, mediaType), configFile)
 	if err != nil {
-		return errors.Wrap(err, "add layer")
+		return err
+	}
+	image, err = mutate.Append(image, mutate.Addendum{
+		Layer:   squashed,
+		History: v1.History{CreatedBy: imgutil.SquashedCreatedBy},
+	})
+	if err != nil {
+		return err
+	}
+	i.image = &manifestImage{Image: image, configMediaType: manifest.Config.MediaType, annotations: manifest.Annotations}
+	i.recordedHistory = 1
+	return nil
+}
+
+// squashLayers writes the squashed filesystem of layers to w
+func squashLayers(w io.Writer, layers []v1.Layer) error {
+	var readers []io.Reader
+	for _, l := range layers {
+		rc, err := l.Uncompressed()
+		if err != nil {
+			return errors.Wrap(err, "read layer")
+		}
+		defer rc.Close()
+		readers = append(readers, rc)
+	}
+	return layer.Squash(w, readers...)
+}
+
+// NormalizeHistory pads or trims the image's history to one entry per layer, as registries require, not counting
+// entries for instructions that did not create a layer. Entries are matched to layers from the top, so that the
+// oldest entries are trimmed and empty entries are added for the bottom layers. Save normalizes the history as well.
+func (i *Image) NormalizeHistory() error {
+	if err := i.Commit(); err != nil {
+		return err
+	}
+
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return errors.Wrap(err, "get image config")
+	}
+	cfg = cfg.DeepCopy()
+	cfg.History = imgutil.NormalizedHistory(cfg.History, len(cfg.RootFS.DiffIDs))
+
+	i.image, err = mutate.ConfigFile(i.image, cfg)
+	if err != nil {
+		return errors.Wrap(err, "normalize history")
+	}
+	if i.recordedHistory > len(cfg.History) {
+		i.recordedHistory = len(cfg.History)
 	}
 	return nil
 }
@@ -429,15 +1584,49 @@ func (i *Image) AddLayerWithDiffID(path, diffID string) error {
 	return i.AddLayer(path)
 }
 
+// ReuseLayer reuses the layer with diff ID sha from the previous image. sha may be given with or without the
+// "sha256:" prefix.
 func (i *Image) ReuseLayer(sha string) error {
-	layer, err := findLayerWithSha(i.prevLayers, sha)
+	diffID := imgutil.NormalizeDiffID(sha)
+	_, err := v1.NewHash(diffID)
+	useCache := i.layerCacheDir != "" && err == nil
+	if useCache {
+		layer, err := i.cachedLayer(diffID)
+		if err != nil {
+			return err
+		}
+		if layer != nil {
+			return i.appendLayer(layer, v1.History{})
+		}
+	}
+
+	layer, err := findLayerWithSha(i.prevLayers, diffID)
 	if err != nil {
+		if errors.Is(err, imgutil.ErrLayerNotFound) {
+			return errors.Wrapf(imgutil.ErrLayerNotFound, `previous image did not have layer with diff id '%s'`, sha)
+		}
 		return err
 	}
-	i.image, err = mutate.AppendLayers(i.image, layer)
-	return err
+	if useCache {
+		cached, err := i.cacheLayer(diffID, layer)
+		if err != nil {
+			return err
+		}
+		if cached != nil {
+			layer = cached
+		}
+	}
+	return i.appendLayer(layer, v1.History{})
+}
+
+func (i *Image) ReuseLayerOrAdd(sha, path string) error {
+	if err := i.ReuseLayer(sha); !errors.Is(err, imgutil.ErrLayerNotFound) {
+		return err
+	}
+	return i.AddLayer(path)
 }
 
+// findLayerWithSha returns the layer matching diffID, or a layerNotFoundError if there is none
 func findLayerWithSha(layers []v1.Layer, diffID string) (v1.Layer, error) {
 	for _, layer := range layers {
 		dID, err := layer.DiffID()
@@ -448,15 +1637,74 @@ func findLayerWithSha(layers []v1.Layer, diffID string) (v1.Layer, error) {
 			return layer, nil
 		}
 	}
-	return nil, fmt.Errorf(`previous image did not have layer with diff id '%s'`, diffID)
+	return nil, layerNotFoundError{diffID: diffID}
 }
 
-func (i *Image) Save(additionalNames ...string) error {
-	var err error
+// layerNotFoundError reports that none of the layers searched by findLayerWithSha has diffID
+type layerNotFoundError struct {
+	diffID string
+}
+
+func (e layerNotFoundError) Error() string {
+	return fmt.Sprintf("no layer with diff ID '%s'", e.diffID)
+}
 
+// Is reports whether target is imgutil.ErrLayerNotFound, so that callers can check errors.Is(err, imgutil.ErrLayerNotFound).
+func (e layerNotFoundError) Is(target error) bool {
+	return target == imgutil.ErrLayerNotFound
+}
+
+func (i *Image) Save(additionalNames ...string) error {
 	allNames := append([]string{i.repoName}, additionalNames...)
 
-	i.image, err = mutate.CreatedAt(i.image, v1.Time{Time: imgutil.NormalizedDateTime})
+	if err := i.prepareSave(); err != nil {
+		return err
+	}
+
+	var diagnostics []imgutil.SaveDiagnostic
+	for _, n := range allNames {
+		if err := i.doSave(n); err != nil {
+			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
+		}
+	}
+	if len(diagnostics) > 0 {
+		return imgutil.SaveError{Errors: diagnostics}
+	}
+
+	return nil
+}
+
+// SaveByDigest saves the image to the repository of `Name()` by the digest of its manifest rather than by tag,
+// for registries that only accept immutable pushes, and returns the digest reference it was saved as.
+func (i *Image) SaveByDigest() (string, error) {
+	if err := i.prepareSave(); err != nil {
+		return "", err
+	}
+
+	ref, err := name.ParseReference(i.repoName, i.nameOptions()...)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse reference for image '%s': %s", i.repoName, err)
+	}
+	digest, err := i.image.Digest()
+	if err != nil {
+		return "", fmt.Errorf("failed to get digest for image '%s': %s", i.repoName, err)
+	}
+
+	digestName := ref.Context().Name() + "@" + digest.String()
+	if err := i.doSave(digestName); err != nil {
+		return "", errors.Wrapf(err, "save image '%s'", digestName)
+	}
+	return digestName, nil
+}
+
+// prepareSave commits staged changes and normalizes the creation time and history of the image
+func (i *Image) prepareSave() error {
+	if err := i.Commit(); err != nil {
+		return err
+	}
+
+	var err error
+	i.image, err = mutate.CreatedAt(i.image, v1.Time{Time: i.createdAt})
 	if err != nil {
 		return errors.Wrap(err, "set creation time")
 	}
@@ -471,10 +1719,22 @@ func (i *Image) Save(additionalNames ...string) error {
 	if err != nil {
 		return errors.Wrap(err, "get image layers")
 	}
-	cfg.History = make([]v1.History, len(layers))
-	for i := range cfg.History {
-		cfg.History[i] = v1.History{
-			Created: v1.Time{Time: imgutil.NormalizedDateTime},
+	// the history of the base is dropped, so that only the provenance recorded for the added layers is saved
+	recorded := i.recordedHistory
+	if recorded > len(cfg.History) {
+		recorded = len(cfg.History)
+	}
+	cfg.History = imgutil.NormalizedHistory(cfg.History[len(cfg.History)-recorded:], len(layers))
+	for idx := range cfg.History {
+		// zero history, keeping only recorded provenance
+		created := cfg.History[idx].Created.Time
+		if created.IsZero() {
+			created = i.createdAt
+		}
+		cfg.History[idx] = v1.History{
+			Created:    v1.Time{Time: created},
+			CreatedBy:  cfg.History[idx].CreatedBy,
+			EmptyLayer: cfg.History[idx].EmptyLayer,
 		}
 	}
 
@@ -484,26 +1744,182 @@ func (i *Image) Save(additionalNames ...string) error {
 	if err != nil {
 		return errors.Wrap(err, "zeroing history")
 	}
+	return nil
+}
 
-	var diagnostics []imgutil.SaveDiagnostic
-	for _, n := range allNames {
-		if err := i.doSave(n); err != nil {
-			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
+func (i *Image) doSave(imageName string) error {
+	ref, auth, err := i.referenceForRepoName(imageName)
+	if err != nil {
+		return err
+	}
+
+	var (
+		backoff   = i.backoff
+		refreshed = false
+	)
+	for attempt := 0; ; {
+		err = i.write(ref, auth)
+		switch {
+		case err == nil:
+			if i.verifyAfterPush {
+				return i.verifyPush(ref, auth)
+			}
+			return nil
+		case i.keychainRefresh != nil && !refreshed && isUnauthorized(err):
+			// the credentials may have expired since they were resolved, so try once more with a refreshed keychain
+			refreshed = true
+		case attempt < i.retries && isRetryable(err):
+			attempt++
+			time.Sleep(backoff)
+			backoff *= 2
+		default:
+			return err
+		}
+
+		if i.keychainRefresh != nil {
+			if keychain := i.keychainRefresh(); keychain != nil {
+				i.keychain = keychain
+			}
+			if ref, auth, err = i.referenceForRepoName(imageName); err != nil {
+				return err
+			}
 		}
 	}
-	if len(diagnostics) > 0 {
-		return imgutil.SaveError{Errors: diagnostics}
+}
+
+func (i *Image) write(ref name.Reference, auth authn.Authenticator) error {
+	image := i.image
+	if i.mountFrom != nil {
+		image = &mountableImage{Image: i.image, from: *i.mountFrom}
+	}
+	if i.uploadConcurrency > 0 {
+		if err := i.uploadLayers(ref.Context(), image, auth); err != nil {
+			return err
+		}
+	}
+	return remote.Write(ref, image, i.remoteOptions(auth)...)
+}
+
+// mountableImage marks the layers of an image as mountable from another repository, so that remote.Write asks
+// the registry to mount them before uploading them. Layers that are already mountable, such as the layers of a
+// remote base image, keep their source.
+type mountableImage struct {
+	v1.Image
+	from name.Repository
+}
+
+func (m *mountableImage) Layers() ([]v1.Layer, error) {
+	layers, err := m.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	mountable := make([]v1.Layer, len(layers))
+	for idx, layer := range layers {
+		if _, ok := layer.(*remote.MountableLayer); ok {
+			mountable[idx] = layer
+			continue
+		}
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, errors.Wrapf(err, "get digest of layer %d", idx)
+		}
+		mountable[idx] = &remote.MountableLayer{Layer: layer, Reference: m.from.Digest(digest.String())}
+	}
+	return mountable, nil
+}
+
+// verifyPush pulls the manifest of the image from the repository of ref by digest and checks that the registry
+// stored the manifest that was pushed.
+func (i *Image) verifyPush(ref name.Reference, auth authn.Authenticator) error {
+	digest, err := i.image.Digest()
+	if err != nil {
+		return errors.Wrapf(err, "get digest of image '%s'", ref.Name())
 	}
 
+	digestRef := ref.Context().Digest(digest.String())
+	resp, err := i.requestManifest(http.MethodGet, digestRef, auth)
+	if err != nil {
+		return errors.Wrapf(err, "verify push of '%s'", ref.Name())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verify push of '%s': registry responded to manifest '%s' with status code %d", ref.Name(), digestRef.Name(), resp.StatusCode)
+	}
+
+	stored, _, err := v1.SHA256(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "verify push of '%s'", ref.Name())
+	}
+	if stored != digest {
+		return fmt.Errorf("verify push of '%s': registry stored manifest with digest '%s', pushed '%s'", ref.Name(), stored, digest)
+	}
 	return nil
 }
 
-func (i *Image) doSave(imageName string) error {
-	ref, auth, err := referenceForRepoName(i.keychain, imageName)
+// uploadLayers uploads layer blobs with at most i.uploadConcurrency uploads in flight. remote.Write skips the
+// blobs that are already uploaded.
+func (i *Image) uploadLayers(repo name.Repository, image v1.Image, auth authn.Authenticator) error {
+	layers, err := image.Layers()
 	if err != nil {
-		return err
+		return errors.Wrap(err, "get image layers")
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, i.uploadConcurrency)
+		uploaded = map[v1.Hash]bool{}
+	)
+	for _, layer := range layers {
+		mt, err := layer.MediaType()
+		if err != nil {
+			return err
+		}
+		if !mt.IsDistributable() {
+			continue
+		}
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		if uploaded[digest] {
+			continue
+		}
+		uploaded[digest] = true
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(layer v1.Layer) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := remote.WriteLayer(repo, layer, i.remoteOptions(auth)...); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(layer)
 	}
-	return remote.Write(ref, i.image, remote.WithAuth(auth))
+	wg.Wait()
+	return firstErr
+}
+
+func isRetryable(err error) bool {
+	if transportErr, ok := err.(*transport.Error); ok {
+		return transportErr.StatusCode == http.StatusTooManyRequests || transportErr.StatusCode >= http.StatusInternalServerError
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+func isUnauthorized(err error) bool {
+	transportErr, ok := err.(*transport.Error)
+	return ok && transportErr.StatusCode == http.StatusUnauthorized
 }
 
 func (i *Image) Delete() error {
@@ -511,16 +1927,53 @@ func (i *Image) Delete() error {
 	if err != nil {
 		return err
 	}
-	ref, auth, err := referenceForRepoName(i.keychain, id.String())
+	ref, auth, err := i.referenceForRepoName(id.String())
 	if err != nil {
 		return err
 	}
-	return remote.Delete(ref, remote.WithAuth(auth))
+	if err := remote.Delete(ref, i.remoteOptions(auth)...); err != nil {
+		if transportErr, ok := err.(*transport.Error); ok {
+			switch transportErr.StatusCode {
+			case http.StatusMethodNotAllowed:
+				return errors.Wrapf(ErrDeleteUnsupported, "delete image '%s'", i.repoName)
+			case http.StatusNotFound:
+				return imgutil.NotFoundError{Message: fmt.Sprintf("delete image '%s': %s", i.repoName, err)}
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// ListTags returns the tags in the repository of repoName, which may also include a tag or digest. Registries that
+// paginate the tag list are read until the last page. Options that configure the connection, such as WithInsecure
+// and WithRegistryMirror, are honored.
+func ListTags(repoName string, keychain authn.Keychain, ops ...ImageOption) ([]string, error) {
+	img, err := NewImage(repoName, keychain, ops...)
+	if err != nil {
+		return nil, err
+	}
+	return img.(*Image).listTags()
+}
+
+func (i *Image) listTags() ([]string, error) {
+	ref, auth, err := i.referenceForRepoName(i.repoName)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := remote.List(ref.Context(), i.remoteOptions(auth)...)
+	if err != nil && i.anonymousFallback && auth != authn.Anonymous && isUnauthorized(err) {
+		tags, err = remote.List(ref.Context(), i.remoteOptions(authn.Anonymous)...)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "list tags for repository '%s'", ref.Context().Name())
+	}
+	return tags, nil
 }
 
 type subImage struct {
 	img       v1.Image
-	topDiffID string
+	numLayers int
 }
 
 func (si *subImage) Layers() ([]v1.Layer, error) {
@@ -528,16 +1981,10 @@ func (si *subImage) Layers() ([]v1.Layer, error) {
 	if err != nil {
 		return nil, err
 	}
-	for i, l := range all {
-		d, err := l.DiffID()
-		if err != nil {
-			return nil, err
-		}
-		if d.String() == si.topDiffID {
-			return all[0 : i+1], nil
-		}
+	if si.numLayers > len(all) {
+		return nil, errors.New("could not find base layer in image")
 	}
-	return nil, errors.New("could not find base layer in image")
+	return all[:si.numLayers], nil
 }
 func (si *subImage) BlobSet() (map[v1.Hash]struct{}, error)  { panic("Not Implemented") }
 func (si *subImage) MediaType() (types.MediaType, error)     { panic("Not Implemented") }
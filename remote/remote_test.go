@@ -1,18 +1,42 @@
 package remote_test
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/fakes"
 	"github.com/buildpacks/imgutil/remote"
 	h "github.com/buildpacks/imgutil/testhelpers"
 )
@@ -165,6 +189,36 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 						h.AssertNil(t, err)
 						defer readCloser.Close()
 					})
+
+					when("a platform is requested", func() {
+						it("returns the base image matching the platform", func() {
+							img, err := remote.NewImageWithPlatform(
+								repoName,
+								authn.DefaultKeychain,
+								v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+								remote.FromBaseImage("golang:1.13.8"),
+							)
+							h.AssertNil(t, err)
+
+							os, err := img.OS()
+							h.AssertNil(t, err)
+							h.AssertEq(t, os, "linux")
+
+							arch, err := img.Architecture()
+							h.AssertNil(t, err)
+							h.AssertEq(t, arch, "arm64")
+						})
+
+						it("returns an error when no image matches the platform", func() {
+							_, err := remote.NewImageWithPlatform(
+								repoName,
+								authn.DefaultKeychain,
+								v1.Platform{OS: "plan9", Architecture: "mips"},
+								remote.FromBaseImage("golang:1.13.8"),
+							)
+							h.AssertError(t, err, "no child with platform mips/plan9")
+						})
+					})
 				})
 			})
 
@@ -194,76 +248,110 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 		})
-	})
-
-	when("#Labels", func() {
-		when("image exists with labels", func() {
-			var repoName = newTestImageName()
 
-			it.Before(func() {
-				baseImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+		when("#FromTarball", func() {
+			it("reads the image from the tarball", func() {
+				layerPath, err := h.CreateSingleFileLayerTar("/some-layer.txt", "some-layer", "linux")
 				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
 
-				h.AssertNil(t, baseImage.SetLabel("mykey", "myvalue"))
-				h.AssertNil(t, baseImage.SetLabel("other", "data"))
-				h.AssertNil(t, baseImage.Save())
-			})
+				tag, err := name.NewTag(repoName, name.WeakValidation)
+				h.AssertNil(t, err)
 
-			it("returns all the labels", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				layer, err := tarball.LayerFromFile(layerPath)
+				h.AssertNil(t, err)
+				v1Image, err := mutate.AppendLayers(empty.Image, layer)
+				h.AssertNil(t, err)
+				v1Image, err = mutate.Config(v1Image, v1.Config{Labels: map[string]string{"mykey": "myvalue"}})
 				h.AssertNil(t, err)
 
-				labels, err := img.Labels()
+				tarFile, err := ioutil.TempFile("", "remote-image-tarball")
 				h.AssertNil(t, err)
-				h.AssertEq(t, labels["mykey"], "myvalue")
-				h.AssertEq(t, labels["other"], "data")
-			})
-		})
+				tarFile.Close()
+				defer os.Remove(tarFile.Name())
+				h.AssertNil(t, tarball.WriteToFile(tarFile.Name(), tag, v1Image))
 
-		when("image exists with no labels", func() {
-			var repoName = newTestImageName()
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromTarball(tarFile.Name()))
+				h.AssertNil(t, err)
 
-			it.Before(func() {
-				baseImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				label, err := img.Label("mykey")
 				h.AssertNil(t, err)
-				h.AssertNil(t, baseImage.Save())
-			})
+				h.AssertEq(t, label, "myvalue")
 
-			it("returns nil", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				topLayer, err := img.TopLayer()
 				h.AssertNil(t, err)
+				h.AssertEq(t, topLayer, h.FileDiffID(t, layerPath))
 
-				labels, err := img.Labels()
+				readCloser, err := img.GetLayer(topLayer)
 				h.AssertNil(t, err)
-				h.AssertEq(t, 0, len(labels))
+				defer readCloser.Close()
+			})
+
+			when("the tarball does not exist", func() {
+				it("returns an error", func() {
+					_, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromTarball("/does/not/exist.tar"))
+					h.AssertError(t, err, "read image tarball '/does/not/exist.tar'")
+				})
 			})
 		})
 
-		when("image NOT exists", func() {
-			it("returns nil", func() {
-				img, err := remote.NewImage(newTestImageName(), authn.DefaultKeychain)
+		when("#WithRegistryMirror", func() {
+			it("pushes and pulls through the mirror with the original registry's credentials", func() {
+				repoPath := "pack-image-test-" + h.RandString(10)
+				mirrorRegistry, err := name.NewRegistry("localhost:"+dockerRegistry.Port, name.WeakValidation)
 				h.AssertNil(t, err)
+				keychain := &recordingKeychain{target: mirrorRegistry}
 
-				labels, err := img.Labels()
+				img, err := remote.NewImage(
+					"registry.example.com/"+repoPath,
+					keychain,
+					remote.WithRegistryMirror("registry.example.com", mirrorRegistry.RegistryStr()),
+				)
 				h.AssertNil(t, err)
-				h.AssertEq(t, 0, len(labels))
+				h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+				h.AssertNil(t, img.Save())
+
+				configFile := h.FetchManifestImageConfigFile(t, dockerRegistry.RepoName(repoPath))
+				h.AssertEq(t, configFile.Config.Labels["mykey"], "myvalue")
+
+				mirroredImg, err := remote.NewImage(
+					"registry.example.com/"+repoPath,
+					keychain,
+					remote.WithRegistryMirror("registry.example.com", mirrorRegistry.RegistryStr()),
+					remote.FromBaseImage("registry.example.com/"+repoPath),
+				)
+				h.AssertNil(t, err)
+
+				label, err := mirroredImg.Label("mykey")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "myvalue")
+
+				h.AssertContains(t, keychain.resolved, "registry.example.com")
+				h.AssertDoesNotContain(t, keychain.resolved, mirrorRegistry.RegistryStr())
 			})
 		})
-	})
 
-	when("#Label", func() {
-		when("image exists", func() {
+		when("#WithAnonymousFallback", func() {
+			var (
+				keychain  = &staticKeychain{auth: &authn.Basic{Username: "expired", Password: "expired"}}
+				transport = &rejectCredentialsTransport{}
+			)
+
 			it.Before(func() {
-				baseImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
 				h.AssertNil(t, err)
-
-				h.AssertNil(t, baseImage.SetLabel("mykey", "myvalue"))
-				h.AssertNil(t, baseImage.SetLabel("other", "data"))
-				h.AssertNil(t, baseImage.Save())
+				h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+				h.AssertNil(t, img.Save())
 			})
 
-			it("returns the label value", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			it("reads the image anonymously when the credentials are rejected", func() {
+				img, err := remote.NewImage(
+					newTestImageName(),
+					keychain,
+					remote.WithTransport(transport),
+					remote.WithAnonymousFallback(),
+					remote.FromBaseImage(repoName),
+				)
 				h.AssertNil(t, err)
 
 				label, err := img.Label("mykey")
@@ -271,520 +359,2046 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, label, "myvalue")
 			})
 
-			it("returns an empty string for a missing label", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			it("does not fall back unless enabled", func() {
+				img, err := remote.NewImage(
+					repoName,
+					keychain,
+					remote.WithTransport(transport),
+				)
 				h.AssertNil(t, err)
 
-				label, err := img.Label("missing-label")
-				h.AssertNil(t, err)
-				h.AssertEq(t, label, "")
+				found, err := img.(*remote.Image).CheckFound()
+				h.AssertEq(t, found, false)
+				h.AssertEq(t, errors.Cause(err) == remote.ErrUnauthorized, true)
 			})
 		})
 
-		when("image is empty", func() {
-			it("returns an empty label", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
-				h.AssertNil(t, err)
+		when("#WithInsecure", func() {
+			// the registries are addressed by a name other than localhost, which go-containerregistry would reach
+			// over plain HTTP regardless of the option
+			var (
+				plainRegistry, tlsRegistry *h.DockerRegistry
+				transport                  *loopbackTransport
+			)
 
-				label, err := img.Label("some-label")
-				h.AssertNil(t, err)
-				h.AssertEq(t, label, "")
+			it.Before(func() {
+				plainRegistry = h.NewDockerRegistry()
+				plainRegistry.Start(t)
+				tlsRegistry = h.NewDockerRegistry(h.WithTLS())
+				tlsRegistry.Start(t)
+				transport = newLoopbackTransport(tlsRegistry.CertPool())
 			})
-		})
-	})
-
-	when("#Env", func() {
-		when("image exists", func() {
-			var baseImageName = newTestImageName()
 
-			it.Before(func() {
-				baseImage, err := remote.NewImage(baseImageName, authn.DefaultKeychain)
-				h.AssertNil(t, err)
-				h.AssertNil(t, baseImage.SetEnv("MY_VAR", "my_val"))
-				h.AssertNil(t, baseImage.Save())
+			it.After(func() {
+				plainRegistry.Stop(t)
+				tlsRegistry.Stop(t)
 			})
 
-			it("returns the label value", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
-				h.AssertNil(t, err)
+			it("saves and reads the image over plain HTTP", func() {
+				repoName := "registry.imgutil.test:" + plainRegistry.Port + "/pack-image-test-" + h.RandString(10)
 
-				val, err := img.Env("MY_VAR")
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithTransport(transport), remote.WithInsecure())
 				h.AssertNil(t, err)
-				h.AssertEq(t, val, "my_val")
-			})
+				h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+				h.AssertNil(t, img.Save())
 
-			it("returns an empty string for a missing label", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+				savedImg, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithTransport(transport),
+					remote.WithInsecure(),
+					remote.FromBaseImage(repoName),
+				)
 				h.AssertNil(t, err)
+				h.AssertEq(t, savedImg.Found(), true)
 
-				val, err := img.Env("MISSING_VAR")
+				label, err := savedImg.Label("mykey")
 				h.AssertNil(t, err)
-				h.AssertEq(t, val, "")
+				h.AssertEq(t, label, "myvalue")
+				h.AssertEq(t, transport.schemes()["http"], true)
 			})
-		})
 
-		when("image is empty", func() {
-			it("returns an empty string", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			it("connects over HTTPS when the option is not given", func() {
+				img, err := remote.NewImage(
+					"registry.imgutil.test:"+plainRegistry.Port+"/pack-image-test-"+h.RandString(10),
+					authn.DefaultKeychain,
+					remote.WithTransport(transport),
+				)
 				h.AssertNil(t, err)
+				h.AssertError(t, img.Save(), "server gave HTTP response to HTTPS client")
 
-				val, err := img.Env("SOME_VAR")
+				img, err = remote.NewImage(
+					"registry.imgutil.test:"+tlsRegistry.Port+"/pack-image-test-"+h.RandString(10),
+					authn.DefaultKeychain,
+					remote.WithTransport(transport),
+				)
 				h.AssertNil(t, err)
-				h.AssertEq(t, val, "")
+				h.AssertNil(t, img.Save())
+				h.AssertEq(t, transport.schemes(), map[string]bool{"https": true})
 			})
 		})
-	})
-
-	when("#Name", func() {
-		it("always returns the original name", func() {
-			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
-			h.AssertNil(t, err)
-			h.AssertEq(t, img.Name(), repoName)
-		})
-	})
-
-	when("#CreatedAt", func() {
-		const reference = "busybox@sha256:f79f7a10302c402c052973e3fa42be0344ae6453245669783a9e16da3d56d5b4"
-		it("returns the containers created at time", func() {
-			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(reference))
-			h.AssertNil(t, err)
-
-			expectedTime := time.Date(2019, 4, 2, 23, 32, 10, 727183061, time.UTC)
-
-			createdTime, err := img.CreatedAt()
-
-			h.AssertNil(t, err)
-			h.AssertEq(t, createdTime, expectedTime)
-		})
-	})
 
-	when("#Identifier", func() {
-		it("returns a digest reference", func() {
-			img, err := remote.NewImage(
-				repoName+":some-tag",
-				authn.DefaultKeychain,
-				remote.FromBaseImage("busybox@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5"),
-			)
-			h.AssertNil(t, err)
+		when("#WithTransport", func() {
+			it("uses the transport for registry requests", func() {
+				transport := &countingTransport{}
 
-			identifier, err := img.Identifier()
-			h.AssertNil(t, err)
-			h.AssertEq(t, identifier.String(), repoName+"@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5")
-		})
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithTransport(transport))
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.Save())
+				h.AssertEq(t, img.Found(), true)
 
-		it("accurately parses the reference for an image with a sha", func() {
-			img, err := remote.NewImage(
-				repoName+"@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5",
-				authn.DefaultKeychain,
-				remote.FromBaseImage("busybox@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5"),
-			)
-			h.AssertNil(t, err)
+				h.AssertEq(t, transport.requests > 0, true)
+			})
 
-			identifier, err := img.Identifier()
-			h.AssertNil(t, err)
-			h.AssertEq(t, identifier.String(), repoName+"@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5")
-		})
+			it("uses the transport to read base and previous images given before it", func() {
+				baseImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, baseImage.SetLabel("mykey", "myvalue"))
+				h.AssertNil(t, baseImage.Save())
 
-		when("the image has been modified and saved", func() {
-			it("returns the new digest reference", func() {
+				transport := &countingTransport{}
 				img, err := remote.NewImage(
-					repoName+":some-tag",
+					newTestImageName(),
 					authn.DefaultKeychain,
-					remote.FromBaseImage("busybox@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5"),
+					remote.FromBaseImage(repoName),
+					remote.WithPreviousImage(repoName),
+					remote.WithTransport(transport),
 				)
 				h.AssertNil(t, err)
+				h.AssertEq(t, transport.requests > 0, true)
 
-				h.AssertNil(t, img.SetLabel("new", "label"))
+				label, err := img.Label("mykey")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "myvalue")
+			})
 
-				h.AssertNil(t, img.Save())
+			it("saves and reads through a TLS registry", func() {
+				tlsRegistry := h.NewDockerRegistry(h.WithTLS())
+				tlsRegistry.Start(t)
+				defer tlsRegistry.Stop(t)
 
-				id, err := img.Identifier()
+				// the registry is not addressed as localhost, which go-containerregistry would reach over plain HTTP
+				transport := newLoopbackTransport(tlsRegistry.CertPool())
+				repoName := "registry.imgutil.test:" + tlsRegistry.Port + "/pack-image-test-" + h.RandString(10)
+
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithTransport(transport))
 				h.AssertNil(t, err)
+				h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+				h.AssertNil(t, img.Save())
 
-				testImg, err := remote.NewImage(
-					"test",
+				savedImg, err := remote.NewImage(
+					repoName,
 					authn.DefaultKeychain,
-					remote.FromBaseImage(id.String()),
+					remote.WithTransport(transport),
+					remote.FromBaseImage(repoName),
 				)
 				h.AssertNil(t, err)
+				h.AssertEq(t, savedImg.Found(), true)
 
-				remoteLabel, err := testImg.Label("new")
+				label, err := savedImg.Label("mykey")
 				h.AssertNil(t, err)
-
-				h.AssertEq(t, remoteLabel, "label")
+				h.AssertEq(t, label, "myvalue")
+				h.AssertEq(t, transport.schemes(), map[string]bool{"https": true})
 			})
 		})
-	})
 
-	when("#SetLabel", func() {
-		when("image exists", func() {
-			it("sets label on img object", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+		when("#WithCompressionLevel", func() {
+			it("compresses added layers at the given level", func() {
+				layerPath, err := h.CreateSingleFileLayerTar("/file.txt", strings.Repeat("compressible ", 10000), "linux")
 				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
 
-				h.AssertNil(t, img.SetLabel("mykey", "new-val"))
-				label, err := img.Label("mykey")
-				h.AssertNil(t, err)
-				h.AssertEq(t, label, "new-val")
-			})
+				layerSize := func(level int) int64 {
+					img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithCompressionLevel(level))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.AddLayer(layerPath))
 
-			it("saves label", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
-				h.AssertNil(t, err)
+					manifest, err := img.(*remote.Image).Manifest()
+					h.AssertNil(t, err)
+					return manifest.Layers[0].Size
+				}
 
-				h.AssertNil(t, img.SetLabel("mykey", "new-val"))
+				fi, err := os.Stat(layerPath)
+				h.AssertNil(t, err)
+				h.AssertEq(t, layerSize(gzip.NoCompression) > fi.Size(), true)
+				h.AssertEq(t, layerSize(gzip.BestCompression) < fi.Size()/10, true)
+			})
 
-				h.AssertNil(t, img.Save())
+			when("the level is not a gzip level", func() {
+				it("returns an error", func() {
+					_, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithCompressionLevel(10))
+					h.AssertError(t, err, "compression level must be between -2 and 9, got 10")
+				})
+			})
+		})
 
-				testImg, err := remote.NewImage(
-					"test",
+		when("#WithUploadConcurrency", func() {
+			it("limits the number of layers uploaded at once", func() {
+				transport := &uploadTrackingTransport{}
+				img, err := remote.NewImage(
+					repoName,
 					authn.DefaultKeychain,
-					remote.FromBaseImage(repoName),
+					remote.WithTransport(transport),
+					remote.WithUploadConcurrency(1),
 				)
 				h.AssertNil(t, err)
 
-				remoteLabel, err := testImg.Label("mykey")
-				h.AssertNil(t, err)
+				for n := 0; n < 3; n++ {
+					layerPath, err := h.CreateSingleFileLayerTar(fmt.Sprintf("/layer-%d.txt", n), "some-content", "linux")
+					h.AssertNil(t, err)
+					defer os.Remove(layerPath)
+					h.AssertNil(t, img.AddLayer(layerPath))
+				}
 
-				h.AssertEq(t, remoteLabel, "new-val")
+				h.AssertNil(t, img.Save())
+				h.AssertEq(t, transport.maxInFlight, 1)
+				h.AssertEq(t, len(h.FetchManifestLayers(t, repoName)), 3)
+			})
+
+			it("requires at least one upload at a time", func() {
+				_, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithUploadConcurrency(0))
+				h.AssertError(t, err, "upload concurrency must be at least 1, got 0")
 			})
 		})
-	})
 
-	when("#RemoveLabel", func() {
-		when("image exists", func() {
-			var baseImageName = newTestImageName()
+		when("#WithMountFrom", func() {
+			var layerPath string
 
 			it.Before(func() {
-				baseImage, err := remote.NewImage(baseImageName, authn.DefaultKeychain)
+				var err error
+				layerPath, err = h.CreateSingleFileLayerTar("/some-layer.txt", "some-layer", "linux")
 				h.AssertNil(t, err)
-				h.AssertNil(t, baseImage.SetLabel("custom.label", "new-val"))
-				h.AssertNil(t, baseImage.Save())
 			})
 
-			it("removes label on img object", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+			it.After(func() {
+				os.Remove(layerPath)
+			})
+
+			it("mounts layers from the repository instead of uploading them", func() {
+				sourceName := newTestImageName()
+				source, err := remote.NewImage(sourceName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, source.AddLayer(layerPath))
+				h.AssertNil(t, source.Save())
+
+				transport := &mountTrackingTransport{}
+				img, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithTransport(transport),
+					remote.WithMountFrom(sourceName),
+				)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.AddLayer(layerPath))
+
+				h.AssertNil(t, img.Save())
+				h.AssertEq(t, transport.mounts, 1)
+				h.AssertEq(t, h.FetchManifestLayers(t, repoName), []string{h.FileDiffID(t, layerPath)})
+			})
+
+			it("uploads layers the registry cannot mount", func() {
+				transport := &mountTrackingTransport{}
+				img, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithTransport(transport),
+					remote.WithMountFrom(newTestImageName()),
+				)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.AddLayer(layerPath))
+
+				h.AssertNil(t, img.Save())
+				h.AssertEq(t, transport.mounts, 0)
+				h.AssertEq(t, h.FetchManifestLayers(t, repoName), []string{h.FileDiffID(t, layerPath)})
+			})
+
+			it("returns an error when the repository name is invalid", func() {
+				_, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithMountFrom("Not/Valid"))
+				h.AssertError(t, err, "parse repository to mount from 'Not/Valid'")
+			})
+		})
+
+		when("#WithStrictValidation", func() {
+			it("accepts fully specified image names", func() {
+				_, err := remote.NewImage(repoName+":some-tag", authn.DefaultKeychain, remote.WithStrictValidation())
+				h.AssertNil(t, err)
+			})
+
+			it("rejects image names that rely on defaults", func() {
+				_, err := remote.NewImage("some-image", authn.DefaultKeychain, remote.WithStrictValidation())
+				h.AssertError(t, err, "invalid image name 'some-image'")
+			})
+		})
+
+		when("#WithRetry", func() {
+			it("saves the image", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithRetry(3, time.Millisecond))
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.Save())
+				h.AssertEq(t, img.Found(), true)
+			})
+
+			when("the registry fails with a retryable status", func() {
+				var failingRegistry *h.DockerRegistry
+
+				it.Before(func() {
+					failingRegistry = h.NewDockerRegistry(h.WithFailFirst(2, http.StatusServiceUnavailable))
+					failingRegistry.Start(t)
+				})
+
+				it.After(func() {
+					failingRegistry.Stop(t)
+				})
+
+				it("retries until the image is saved", func() {
+					img, err := remote.NewImage(
+						failingRegistry.RepoName("pack-image-test-"+h.RandString(10)),
+						authn.DefaultKeychain,
+						remote.WithRetry(3, time.Millisecond),
+					)
+					h.AssertNil(t, err)
+
+					h.AssertNil(t, img.Save())
+					h.AssertEq(t, img.Found(), true)
+				})
+			})
+
+			when("the registry fails with a status that is not retryable", func() {
+				var failingRegistry *h.DockerRegistry
+
+				it.Before(func() {
+					failingRegistry = h.NewDockerRegistry(h.WithFailFirst(1, http.StatusBadRequest))
+					failingRegistry.Start(t)
+				})
+
+				it.After(func() {
+					failingRegistry.Stop(t)
+				})
+
+				it("returns the error without retrying", func() {
+					img, err := remote.NewImage(
+						failingRegistry.RepoName("pack-image-test-"+h.RandString(10)),
+						authn.DefaultKeychain,
+						remote.WithRetry(3, time.Millisecond),
+					)
+					h.AssertNil(t, err)
+
+					h.AssertError(t, img.Save(), "simulated failure with status 400")
+				})
+			})
+
+			when("attempts is less than 1", func() {
+				it("returns an error", func() {
+					_, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithRetry(0, time.Millisecond))
+					h.AssertError(t, err, "retry attempts must be at least 1, got 0")
+				})
+			})
+		})
+
+		when("#WithKeychainRefresh", func() {
+			it("refreshes the keychain and retries when the registry rejects the credentials", func() {
+				var refreshes int
+				img, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithTransport(&rejectFirstManifestTransport{}),
+					remote.WithKeychainRefresh(func() authn.Keychain {
+						refreshes++
+						return authn.DefaultKeychain
+					}),
+				)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.Save())
+				h.AssertEq(t, refreshes, 1)
+				h.AssertEq(t, img.Found(), true)
+			})
+		})
+
+		when("#WithVerifyAfterPush", func() {
+			it("saves the image when the registry stored the pushed manifest", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithVerifyAfterPush())
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.Save())
+				h.AssertEq(t, img.Found(), true)
+			})
+
+			it("returns an error when the registry stored a different manifest", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithTransport(&alterManifestTransport{}), remote.WithVerifyAfterPush())
+				h.AssertNil(t, err)
+
+				err = img.Save()
+				h.AssertError(t, err, "registry stored manifest with digest")
+			})
+		})
+
+		when("the registry requires bearer tokens", func() {
+			var (
+				tokenRegistry   *h.DockerRegistry
+				tokenConfigDir  string
+				dockerConfigDir string
+			)
+
+			it.Before(func() {
+				var err error
+				tokenConfigDir, err = ioutil.TempDir("", "test.docker.config.dir")
+				h.AssertNil(t, err)
+				tokenRegistry = h.NewDockerRegistry(h.WithTokenAuth(tokenConfigDir))
+				tokenRegistry.Start(t)
+
+				dockerConfigDir = os.Getenv("DOCKER_CONFIG")
+				os.Setenv("DOCKER_CONFIG", tokenConfigDir)
+			})
+
+			it.After(func() {
+				os.Setenv("DOCKER_CONFIG", dockerConfigDir)
+				tokenRegistry.Stop(t)
+				os.RemoveAll(tokenConfigDir)
+			})
+
+			it("saves and reads the image with a token from the token service", func() {
+				repoName := tokenRegistry.RepoName("pack-image-test-" + h.RandString(10))
+				transport := &urlRecordingTransport{}
+
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithTransport(transport))
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+				h.AssertNil(t, img.Save())
+
+				savedImg, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithTransport(transport),
+					remote.FromBaseImage(repoName),
+				)
+				h.AssertNil(t, err)
+				h.AssertEq(t, savedImg.Found(), true)
+
+				label, err := savedImg.Label("mykey")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "myvalue")
+				h.AssertEq(t, transport.sentTo(tokenRegistry.TokenURL()), true)
+			})
+		})
+	})
+
+	when("#Labels", func() {
+		when("image exists with labels", func() {
+			var repoName = newTestImageName()
+
+			it.Before(func() {
+				baseImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, baseImage.SetLabel("mykey", "myvalue"))
+				h.AssertNil(t, baseImage.SetLabel("other", "data"))
+				h.AssertNil(t, baseImage.Save())
+			})
+
+			it("returns all the labels", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+
+				labels, err := img.Labels()
+				h.AssertNil(t, err)
+				h.AssertEq(t, labels["mykey"], "myvalue")
+				h.AssertEq(t, labels["other"], "data")
+			})
+
+			it("returns a copy that does not affect the image", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+
+				labels, err := img.Labels()
+				h.AssertNil(t, err)
+				labels["mykey"] = "changed"
+
+				val, err := img.Label("mykey")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "myvalue")
+			})
+		})
+
+		when("image exists with no labels", func() {
+			var repoName = newTestImageName()
+
+			it.Before(func() {
+				baseImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, baseImage.Save())
+			})
+
+			it("returns nil", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+
+				labels, err := img.Labels()
+				h.AssertNil(t, err)
+				h.AssertEq(t, 0, len(labels))
+			})
+		})
+
+		when("image NOT exists", func() {
+			it("returns nil", func() {
+				img, err := remote.NewImage(newTestImageName(), authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				labels, err := img.Labels()
+				h.AssertNil(t, err)
+				h.AssertEq(t, 0, len(labels))
+			})
+		})
+	})
+
+	when("#Label", func() {
+		when("image exists", func() {
+			it.Before(func() {
+				baseImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, baseImage.SetLabel("mykey", "myvalue"))
+				h.AssertNil(t, baseImage.SetLabel("other", "data"))
+				h.AssertNil(t, baseImage.Save())
+			})
+
+			it("returns the label value", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+
+				label, err := img.Label("mykey")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "myvalue")
+			})
+
+			it("returns an empty string for a missing label", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+
+				label, err := img.Label("missing-label")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "")
+			})
+
+			it("returns a not found error when the registry does not have the config", func() {
+				img, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithTransport(missingBlobsTransport{}),
+					remote.FromBaseImage(repoName),
+				)
+				h.AssertNil(t, err)
+
+				_, err = img.Label("mykey")
+				h.AssertError(t, err, "BLOB_UNKNOWN")
+				h.AssertEq(t, errors.Is(err, imgutil.ErrImageNotFound), true)
+			})
+		})
+
+		when("image is empty", func() {
+			it("returns an empty label", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				label, err := img.Label("some-label")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "")
+			})
+		})
+	})
+
+	when("#Env", func() {
+		when("image exists", func() {
+			var baseImageName = newTestImageName()
+
+			it.Before(func() {
+				baseImage, err := remote.NewImage(baseImageName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, baseImage.SetEnv("MY_VAR", "my_val"))
+				h.AssertNil(t, baseImage.SetEnv("CONNECTION", "host=db;port=5"))
+				h.AssertNil(t, baseImage.Save())
+			})
+
+			it("returns the label value", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+				h.AssertNil(t, err)
+
+				val, err := img.Env("MY_VAR")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "my_val")
+			})
+
+			it("preserves values containing '='", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+				h.AssertNil(t, err)
+
+				val, err := img.Env("CONNECTION")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "host=db;port=5")
+			})
+
+			it("returns an empty string for a missing label", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+				h.AssertNil(t, err)
+
+				val, err := img.Env("MISSING_VAR")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "")
+			})
+
+			it("lists all variables", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+				h.AssertNil(t, err)
+
+				envVars, err := img.EnvVars()
+				h.AssertNil(t, err)
+				h.AssertEq(t, envVars, []string{"MY_VAR=my_val", "CONNECTION=host=db;port=5"})
+
+				envMap, err := img.EnvMap()
+				h.AssertNil(t, err)
+				h.AssertEq(t, envMap, map[string]string{"MY_VAR": "my_val", "CONNECTION": "host=db;port=5"})
+			})
+		})
+
+		when("image is empty", func() {
+			it("returns an empty string", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				val, err := img.Env("SOME_VAR")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "")
+			})
+		})
+	})
+
+	when("#Name", func() {
+		it("always returns the original name", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertEq(t, img.Name(), repoName)
+		})
+	})
+
+	when("#Registry, #Repository and #Tag", func() {
+		it("returns the components of the image name", func() {
+			img, err := remote.NewImage(dockerRegistry.RepoName("some-project/some-image:v1"), authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, img.Registry(), "localhost:"+dockerRegistry.Port)
+			h.AssertEq(t, img.Repository(), "some-project/some-image")
+			tag, err := img.Tag()
+			h.AssertNil(t, err)
+			h.AssertEq(t, tag, "v1")
+		})
+	})
+
+	when("#Clone", func() {
+		it("returns a copy that is modified and saved independently", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("mykey", "original"))
+
+			cloneName := newTestImageName()
+			clone, err := img.Clone(cloneName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, clone.Name(), cloneName)
+			h.AssertNil(t, clone.SetLabel("mykey", "clone"))
+
+			label, err := img.Label("mykey")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "original")
+
+			h.AssertNil(t, img.Save())
+			h.AssertNil(t, clone.Save())
+
+			h.AssertEq(t, h.FetchManifestImageConfigFile(t, repoName).Config.Labels["mykey"], "original")
+			h.AssertEq(t, h.FetchManifestImageConfigFile(t, cloneName).Config.Labels["mykey"], "clone")
+		})
+	})
+
+	when("#CreatedAt", func() {
+		const reference = "busybox@sha256:f79f7a10302c402c052973e3fa42be0344ae6453245669783a9e16da3d56d5b4"
+		it("returns the containers created at time", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(reference))
+			h.AssertNil(t, err)
+
+			expectedTime := time.Date(2019, 4, 2, 23, 32, 10, 727183061, time.UTC)
+
+			createdTime, err := img.CreatedAt()
+
+			h.AssertNil(t, err)
+			h.AssertEq(t, createdTime, expectedTime)
+		})
+
+		when("#WithCreatedAt", func() {
+			it("saves identical images with the provided creation time", func() {
+				createdAt := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+				var digests []string
+				for _, name := range []string{repoName, newTestImageName()} {
+					img, err := remote.NewImage(name, authn.DefaultKeychain, remote.WithCreatedAt(createdAt))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+					h.AssertNil(t, img.Save())
+
+					createdTime, err := img.CreatedAt()
+					h.AssertNil(t, err)
+					h.AssertEq(t, createdTime, createdAt)
+
+					id, err := img.Identifier()
+					h.AssertNil(t, err)
+					digests = append(digests, id.(remote.DigestIdentifier).Digest.DigestStr())
+				}
+
+				h.AssertEq(t, digests[0], digests[1])
+			})
+
+			it("saves images that differ only in creation time with different digests", func() {
+				saveAt := func(createdAt time.Time) imgutil.Image {
+					img, err := remote.NewImage(newTestImageName(), authn.DefaultKeychain, remote.WithCreatedAt(createdAt))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+					h.AssertNil(t, img.Save())
+					return img
+				}
+
+				createdAt := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+				img := saveAt(createdAt)
+				h.AssertImageDigestsEqual(t, img, saveAt(createdAt))
+				h.AssertImageDigestsNotEqual(t, img, saveAt(createdAt.Add(time.Second)))
+			})
+		})
+	})
+
+	when("#Identifier", func() {
+		it("returns a digest reference", func() {
+			img, err := remote.NewImage(
+				repoName+":some-tag",
+				authn.DefaultKeychain,
+				remote.FromBaseImage("busybox@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5"),
+			)
+			h.AssertNil(t, err)
+
+			identifier, err := img.Identifier()
+			h.AssertNil(t, err)
+			h.AssertEq(t, identifier.String(), repoName+"@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5")
+		})
+
+		it("accurately parses the reference for an image with a sha", func() {
+			img, err := remote.NewImage(
+				repoName+"@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5",
+				authn.DefaultKeychain,
+				remote.FromBaseImage("busybox@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5"),
+			)
+			h.AssertNil(t, err)
+
+			identifier, err := img.Identifier()
+			h.AssertNil(t, err)
+			h.AssertEq(t, identifier.String(), repoName+"@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5")
+		})
+
+		when("the image has been modified and saved", func() {
+			it("returns the new digest reference", func() {
+				img, err := remote.NewImage(
+					repoName+":some-tag",
+					authn.DefaultKeychain,
+					remote.FromBaseImage("busybox@sha256:915f390a8912e16d4beb8689720a17348f3f6d1a7b659697df850ab625ea29d5"),
+				)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.SetLabel("new", "label"))
+
+				h.AssertNil(t, img.Save())
+
+				id, err := img.Identifier()
+				h.AssertNil(t, err)
+
+				testImg, err := remote.NewImage(
+					"test",
+					authn.DefaultKeychain,
+					remote.FromBaseImage(id.String()),
+				)
+				h.AssertNil(t, err)
+
+				remoteLabel, err := testImg.Label("new")
+				h.AssertNil(t, err)
+
+				h.AssertEq(t, remoteLabel, "label")
+			})
+		})
+	})
+
+	when("#Commit", func() {
+		it("applies staged config changes to the image", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			origID, err := img.Identifier()
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetLabel("some-key", "some-val"))
+			h.AssertNil(t, img.SetEnv("SOME_KEY", "some-val"))
+			h.AssertNil(t, img.SetEntrypoint("some", "entrypoint"))
+
+			label, err := img.Label("some-key")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-val")
+
+			h.AssertNil(t, img.(*remote.Image).Commit())
+
+			newID, err := img.Identifier()
+			h.AssertNil(t, err)
+			h.AssertNotEq(t, newID.String(), origID.String())
+
+			env, err := img.Env("SOME_KEY")
+			h.AssertNil(t, err)
+			h.AssertEq(t, env, "some-val")
+		})
+	})
+
+	when("#SetAnnotation #Annotations", func() {
+		it("saves annotations on the manifest", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetAnnotation("org.opencontainers.image.source", "https://example.com/repo"))
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+			h.AssertNil(t, img.(*remote.Image).SetMediaType(types.OCIManifestSchema1))
+			h.AssertNil(t, img.Save())
+
+			savedImg, err := remote.NewImage("test", authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			annotations, err := savedImg.Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, annotations, map[string]string{"org.opencontainers.image.source": "https://example.com/repo"})
+
+			labels, err := savedImg.Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, labels["org.opencontainers.image.source"], "")
+		})
+	})
+
+	when("#MediaType #SetMediaType", func() {
+		it("defaults to the docker manifest media type", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			mediaType, err := img.(*remote.Image).MediaType()
+			h.AssertNil(t, err)
+			h.AssertEq(t, mediaType, types.DockerManifestSchema2)
+		})
+
+		it("saves the image with OCI media types", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			h.AssertNil(t, img.(*remote.Image).SetMediaType(types.OCIManifestSchema1))
+			h.AssertNil(t, img.Save())
+
+			savedImg, err := remote.NewImage("test", authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			mediaType, err := savedImg.(*remote.Image).MediaType()
+			h.AssertNil(t, err)
+			h.AssertEq(t, mediaType, types.OCIManifestSchema1)
+		})
+
+		it("mounts the layers of a base image in another repository", func() {
+			baseName := newTestImageName()
+			base, err := remote.NewImage(baseName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/base-layer.txt", "base-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, base.AddLayer(layerPath))
+			h.AssertNil(t, base.Save())
+
+			transport := &mountTrackingTransport{}
+			img, err := remote.NewImage(
+				repoName,
+				authn.DefaultKeychain,
+				remote.FromBaseImage(baseName),
+				remote.WithTransport(transport),
+			)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.(*remote.Image).SetMediaType(types.OCIManifestSchema1))
+			h.AssertNil(t, img.Save())
+			h.AssertEq(t, transport.mounts, 1)
+		})
+
+		it("rejects unsupported media types", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			err = img.(*remote.Image).SetMediaType(types.DockerManifestList)
+			h.AssertError(t, err, "unsupported manifest media type")
+		})
+	})
+
+	when("#WithFormat", func() {
+		var dockerImageName string
+
+		it.Before(func() {
+			dockerImageName = newTestImageName()
+			img, err := remote.NewImage(dockerImageName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+			h.AssertNil(t, img.Save())
+		})
+
+		it("converts a docker base image to OCI media types", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(dockerImageName), remote.WithFormat(remote.OCI))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.Save())
+
+			mediaType, err := img.(*remote.Image).MediaType()
+			h.AssertNil(t, err)
+			h.AssertEq(t, mediaType, types.OCIManifestSchema1)
+
+			manifest, err := img.(*remote.Image).Manifest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, manifest.Config.MediaType, types.OCIConfigJSON)
+			h.AssertEq(t, manifest.Layers[0].MediaType, types.OCILayer)
+		})
+
+		it("keeps the media types of the base image by default", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(dockerImageName))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.Save())
+
+			manifest, err := img.(*remote.Image).Manifest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, manifest.Config.MediaType, types.DockerConfigJSON)
+			h.AssertEq(t, manifest.Layers[0].MediaType, types.DockerLayer)
+		})
+
+		it("rejects unsupported formats", func() {
+			_, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithFormat("some-format"))
+			h.AssertError(t, err, "unsupported image format 'some-format'")
+		})
+	})
+
+	when("#SetLabel", func() {
+		when("image exists", func() {
+			it("sets label on img object", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.SetLabel("mykey", "new-val"))
+				label, err := img.Label("mykey")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "new-val")
+			})
+
+			it("saves label", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.SetLabel("mykey", "new-val"))
+
+				h.AssertNil(t, img.Save())
+
+				testImg, err := remote.NewImage(
+					"test",
+					authn.DefaultKeychain,
+					remote.FromBaseImage(repoName),
+				)
+				h.AssertNil(t, err)
+
+				remoteLabel, err := testImg.Label("mykey")
+				h.AssertNil(t, err)
+
+				h.AssertEq(t, remoteLabel, "new-val")
+			})
+		})
+	})
+
+	when("#SetLabels", func() {
+		it("merges all labels into the existing labels", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetLabel("existing-key", "existing-val"))
+			h.AssertNil(t, img.SetLabels(map[string]string{
+				"some-key":  "some-val",
+				"other-key": "other-val",
+			}))
+
+			labels, err := img.Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, labels, map[string]string{
+				"existing-key": "existing-val",
+				"some-key":     "some-val",
+				"other-key":    "other-val",
+			})
+		})
+	})
+
+	when("#SetLabelsFromFile", func() {
+		it("merges the labels in the file into the existing labels", func() {
+			labelsFile, err := ioutil.TempFile("", "labels")
+			h.AssertNil(t, err)
+			defer os.Remove(labelsFile.Name())
+			_, err = labelsFile.WriteString(`{"some-key": "some-val", "other-key": "other-val"}`)
+			h.AssertNil(t, err)
+			h.AssertNil(t, labelsFile.Close())
+
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetLabel("existing-key", "existing-val"))
+			h.AssertNil(t, img.SetLabelsFromFile(labelsFile.Name()))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.Labels, map[string]string{
+				"existing-key": "existing-val",
+				"some-key":     "some-val",
+				"other-key":    "other-val",
+			})
+		})
+	})
+
+	when("#RemoveLabel", func() {
+		when("image exists", func() {
+			var baseImageName = newTestImageName()
+
+			it.Before(func() {
+				baseImage, err := remote.NewImage(baseImageName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, baseImage.SetLabel("custom.label", "new-val"))
+				h.AssertNil(t, baseImage.Save())
+			})
+
+			it("removes label on img object", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.RemoveLabel("custom.label"))
+
+				labels, err := img.Labels()
+				h.AssertNil(t, err)
+				_, exists := labels["my.custom.label"]
+				h.AssertEq(t, exists, false)
+			})
+
+			it("saves removal of label", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.RemoveLabel("custom.label"))
+				h.AssertNil(t, img.Save())
+
+				testImg, err := remote.NewImage(
+					"test",
+					authn.DefaultKeychain,
+					remote.FromBaseImage(repoName),
+				)
+				h.AssertNil(t, err)
+
+				remoteLabel, err := testImg.Label("custom.label")
+				h.AssertNil(t, err)
+				h.AssertEq(t, remoteLabel, "")
+			})
+		})
+	})
+
+	when("#SetEnv", func() {
+		it("sets the environment", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			err = img.SetEnv("ENV_KEY", "ENV_VAL")
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertContains(t, configFile.Config.Env, "ENV_KEY=ENV_VAL")
+		})
+
+		when("the key already exists", func() {
+			it("overrides the existing key", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				err = img.SetEnv("ENV_KEY", "SOME_VAL")
+				h.AssertNil(t, err)
+
+				err = img.SetEnv("ENV_KEY", "SOME_OTHER_VAL")
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.Save())
+
+				configFile := h.FetchManifestImageConfigFile(t, repoName)
+				h.AssertContains(t, configFile.Config.Env, "ENV_KEY=SOME_OTHER_VAL")
+				h.AssertDoesNotContain(t, configFile.Config.Env, "ENV_KEY=SOME_VAL")
+			})
+		})
+
+		when("windows", func() {
+			it("ignores case", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				imgOS, err := img.OS()
+				h.AssertNil(t, err)
+
+				if imgOS != "windows" {
+					t.Skip("windows test")
+				}
+
+				err = img.SetEnv("ENV_KEY", "SOME_VAL")
+				h.AssertNil(t, err)
+
+				err = img.SetEnv("env_key", "SOME_OTHER_VAL")
+				h.AssertNil(t, err)
+
+				err = img.SetEnv("env_key2", "SOME_VAL")
+				h.AssertNil(t, err)
+
+				err = img.SetEnv("ENV_KEY2", "SOME_OTHER_VAL")
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.Save())
+
+				configFile := h.FetchManifestImageConfigFile(t, repoName)
+
+				h.AssertContains(t, configFile.Config.Env, "env_key=SOME_OTHER_VAL")
+				h.AssertDoesNotContain(t, configFile.Config.Env, "ENV_KEY=SOME_VAL")
+				h.AssertDoesNotContain(t, configFile.Config.Env, "ENV_KEY=SOME_OTHER_VAL")
+
+				h.AssertContains(t, configFile.Config.Env, "ENV_KEY2=SOME_OTHER_VAL")
+				h.AssertDoesNotContain(t, configFile.Config.Env, "env_key2=SOME_OTHER_VAL")
+				h.AssertDoesNotContain(t, configFile.Config.Env, "env_key2=SOME_VAL")
+			})
+		})
+	})
+
+	when("#RemoveEnv", func() {
+		it("removes the variable", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetEnv("ENV_KEY", "ENV_VAL"))
+			h.AssertNil(t, img.SetEnv("OTHER_KEY", "OTHER_VAL"))
+
+			h.AssertNil(t, img.RemoveEnv("ENV_KEY"))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.Env, []string{"OTHER_KEY=OTHER_VAL"})
+		})
+
+		when("the key does not exist", func() {
+			it("does nothing", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.SetEnv("OTHER_KEY", "OTHER_VAL"))
+
+				h.AssertNil(t, img.RemoveEnv("MISSING_KEY"))
+				h.AssertNil(t, img.Save())
+
+				configFile := h.FetchManifestImageConfigFile(t, repoName)
+				h.AssertEq(t, configFile.Config.Env, []string{"OTHER_KEY=OTHER_VAL"})
+			})
+		})
+	})
+
+	when("#SetWorkingDir", func() {
+		it("sets the environment", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			err = img.SetWorkingDir("/some/work/dir")
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.WorkingDir, "/some/work/dir")
+		})
+	})
+
+	when("#WorkingDir", func() {
+		it("returns the working dir", func() {
+			baseImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, baseImage.SetWorkingDir("/some/work/dir"))
+			h.AssertNil(t, baseImage.Save())
+
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			dir, err := img.WorkingDir()
+			h.AssertNil(t, err)
+			h.AssertEq(t, dir, "/some/work/dir")
+		})
+	})
+
+	when("#SetStopSignal #StopSignal", func() {
+		it("sets and returns the stop signal", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetStopSignal("SIGINT"))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.StopSignal, "SIGINT")
+
+			img, err = remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			sig, err := img.StopSignal()
+			h.AssertNil(t, err)
+			h.AssertEq(t, sig, "SIGINT")
+		})
+	})
+
+	when("#SetOnBuild #OnBuild #SetShell #Shell", func() {
+		it("sets and returns the onbuild triggers and shell", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetOnBuild("RUN echo some-trigger", "ENV SOME_KEY=some-val"))
+			h.AssertNil(t, img.SetShell("/bin/bash", "-c"))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.OnBuild, []string{"RUN echo some-trigger", "ENV SOME_KEY=some-val"})
+			h.AssertEq(t, configFile.Config.Shell, []string{"/bin/bash", "-c"})
+
+			img, err = remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			onBuild, err := img.OnBuild()
+			h.AssertNil(t, err)
+			h.AssertEq(t, onBuild, []string{"RUN echo some-trigger", "ENV SOME_KEY=some-val"})
+			shell, err := img.Shell()
+			h.AssertNil(t, err)
+			h.AssertEq(t, shell, []string{"/bin/bash", "-c"})
+		})
+	})
+
+	when("#SetUser #User", func() {
+		it("sets the user", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetUser("1000:1000"))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.User, "1000:1000")
+
+			savedImg, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			user, err := savedImg.User()
+			h.AssertNil(t, err)
+			h.AssertEq(t, user, "1000:1000")
+		})
+	})
+
+	when("#SetExposedPort #RemoveExposedPort #ExposedPorts", func() {
+		it("sets and removes exposed ports", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetExposedPort("8080/tcp"))
+			h.AssertNil(t, img.SetExposedPort("53/udp"))
+			h.AssertNil(t, img.RemoveExposedPort("53/udp"))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.ExposedPorts, map[string]struct{}{"8080/tcp": {}})
+
+			savedImg, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			ports, err := savedImg.ExposedPorts()
+			h.AssertNil(t, err)
+			h.AssertEq(t, ports, map[string]struct{}{"8080/tcp": {}})
+		})
+	})
+
+	when("#SetVolume #RemoveVolume #Volumes", func() {
+		it("sets and removes volumes", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetVolume("/data"))
+			h.AssertNil(t, img.SetVolume("/data"))
+			h.AssertNil(t, img.SetVolume("/cache"))
+			h.AssertNil(t, img.RemoveVolume("/cache"))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.Volumes, map[string]struct{}{"/data": {}})
+
+			savedImg, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			volumes, err := savedImg.Volumes()
+			h.AssertNil(t, err)
+			h.AssertEq(t, volumes, map[string]struct{}{"/data": {}})
+		})
+	})
+
+	when("#SetHealthcheck #Healthcheck", func() {
+		it("sets the healthcheck", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			healthcheck := &imgutil.HealthConfig{
+				Test:     []string{"CMD", "true"},
+				Interval: 30 * time.Second,
+				Timeout:  5 * time.Second,
+				Retries:  3,
+			}
+			h.AssertNil(t, img.SetHealthcheck(healthcheck))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.Healthcheck.Test, []string{"CMD", "true"})
+
+			savedImg, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			actual, err := savedImg.Healthcheck()
+			h.AssertNil(t, err)
+			h.AssertEq(t, actual, healthcheck)
+		})
+
+		it("clears the healthcheck when given nil", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetHealthcheck(&imgutil.HealthConfig{Test: []string{"CMD", "true"}}))
+			h.AssertNil(t, img.SetHealthcheck(nil))
+
+			actual, err := img.Healthcheck()
+			h.AssertNil(t, err)
+			h.AssertEq(t, actual == nil, true)
+		})
+	})
+
+	when("#SetEntrypoint", func() {
+		it("sets the entrypoint", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			err = img.SetEntrypoint("some", "entrypoint")
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.Entrypoint, []string{"some", "entrypoint"})
+		})
+	})
+
+	when("#SetCmd", func() {
+		it("sets the cmd", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			err = img.SetCmd("some", "cmd")
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.Cmd, []string{"some", "cmd"})
+		})
+	})
+
+	when("#ClearEntrypoint #ClearCmd", func() {
+		it("omits the entrypoint and cmd from the saved config", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetEntrypoint("some", "entrypoint"))
+			h.AssertNil(t, img.SetCmd("some", "cmd"))
+
+			h.AssertNil(t, img.ClearEntrypoint())
+			h.AssertNil(t, img.ClearCmd())
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, len(configFile.Config.Entrypoint), 0)
+			h.AssertEq(t, len(configFile.Config.Cmd), 0)
+		})
+	})
+
+	when("#SetConfig", func() {
+		it("replaces the config, including fields without a typed setter", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("some-label", "some-val"))
+
+			h.AssertNil(t, img.(*remote.Image).SetConfig(&v1.Config{
+				Cmd:         []string{"some", "cmd"},
+				OnBuild:     []string{"RUN echo some-trigger"},
+				ArgsEscaped: true,
+			}))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.Cmd, []string{"some", "cmd"})
+			h.AssertEq(t, configFile.Config.OnBuild, []string{"RUN echo some-trigger"})
+			h.AssertEq(t, configFile.Config.ArgsEscaped, true)
+			h.AssertEq(t, len(configFile.Config.Labels), 0)
+		})
+
+		it("returns an error when the config is nil", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertError(t, img.(*remote.Image).SetConfig(nil), "config must not be nil")
+		})
+	})
+
+	when("#SetOS #SetOSVersion #SetArchitecture", func() {
+		it("sets the os/arch", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			err = img.SetOS("foobaros")
+			h.AssertNil(t, err)
+			err = img.SetOSVersion("1.2.3.4")
+			h.AssertNil(t, err)
+			err = img.SetArchitecture("arm64")
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.OS, "foobaros")
+			h.AssertEq(t, configFile.OSVersion, "1.2.3.4")
+			h.AssertEq(t, configFile.Architecture, "arm64")
+		})
+	})
+
+	when("#Rebase", func() {
+		when("image exists", func() {
+			var oldBase, newBase, oldTopLayerDiffID string
+			var oldBaseLayers, newBaseLayers, repoTopLayers []string
+			it.Before(func() {
+				// new base
+				newBase = newTestImageName("pack-newbase-test")
+				newBaseLayer1Path, err := h.CreateSingleFileLayerTar("/new-base.txt", "new-base", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(newBaseLayer1Path)
+
+				newBaseLayer2Path, err := h.CreateSingleFileLayerTar("/otherfile.txt", "text-new-base", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(newBaseLayer2Path)
+
+				newBaseImage, err := remote.NewImage(newBase, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				err = newBaseImage.AddLayer(newBaseLayer1Path)
+				h.AssertNil(t, err)
+
+				err = newBaseImage.AddLayer(newBaseLayer2Path)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, newBaseImage.Save())
+
+				newBaseLayers = h.FetchManifestLayers(t, newBase)
+
+				// old base image
+				oldBase = newTestImageName("pack-oldbase-test")
+				oldBaseLayer1Path, err := h.CreateSingleFileLayerTar("/old-base.txt", "old-base", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(oldBaseLayer1Path)
+
+				oldBaseLayer2Path, err := h.CreateSingleFileLayerTar("/otherfile.txt", "text-old-base", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(oldBaseLayer2Path)
+
+				oldBaseImage, err := remote.NewImage(oldBase, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				err = oldBaseImage.AddLayer(oldBaseLayer1Path)
+				h.AssertNil(t, err)
+
+				err = oldBaseImage.AddLayer(oldBaseLayer2Path)
+				h.AssertNil(t, err)
+
+				oldTopLayerDiffID = h.FileDiffID(t, oldBaseLayer2Path)
+
+				h.AssertNil(t, oldBaseImage.Save())
+
+				oldBaseLayers = h.FetchManifestLayers(t, oldBase)
+
+				// original image
+				origLayer1Path, err := h.CreateSingleFileLayerTar("/bmyimage.txt", "text-from-image-1", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(origLayer1Path)
+
+				origLayer2Path, err := h.CreateSingleFileLayerTar("/myimage2.txt", "text-from-image-2", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(origLayer2Path)
+
+				origImage, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(oldBase))
+				h.AssertNil(t, err)
+
+				err = origImage.AddLayer(origLayer1Path)
+				h.AssertNil(t, err)
+
+				err = origImage.AddLayer(origLayer2Path)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, origImage.Save())
+
+				repoLayers := h.FetchManifestLayers(t, repoName)
+				repoTopLayers = repoLayers[len(oldBaseLayers):]
+			})
+
+			it("switches the base", func() {
+				// Before
+				h.AssertEq(t,
+					h.FetchManifestLayers(t, repoName),
+					append(oldBaseLayers, repoTopLayers...),
+				)
+
+				// Run rebase
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
 				h.AssertNil(t, err)
 
-				h.AssertNil(t, img.RemoveLabel("custom.label"))
+				newBaseImg, err := remote.NewImage(newBase, authn.DefaultKeychain, remote.FromBaseImage(newBase))
+				h.AssertNil(t, err)
 
-				labels, err := img.Labels()
+				err = img.Rebase(oldTopLayerDiffID, newBaseImg)
 				h.AssertNil(t, err)
-				_, exists := labels["my.custom.label"]
-				h.AssertEq(t, exists, false)
+				h.AssertNil(t, img.Save())
+
+				// After
+				h.AssertEq(t,
+					h.FetchManifestLayers(t, repoName),
+					append(newBaseLayers, repoTopLayers...),
+				)
+
+				newBaseConfig := h.FetchManifestImageConfigFile(t, newBase)
+				rebasedImgConfig := h.FetchManifestImageConfigFile(t, repoName)
+				h.AssertEq(t, rebasedImgConfig.OS, newBaseConfig.OS)
+				h.AssertEq(t, rebasedImgConfig.OSVersion, newBaseConfig.OSVersion)
+				h.AssertEq(t, rebasedImgConfig.Architecture, newBaseConfig.Architecture)
 			})
 
-			it("saves removal of label", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+			it("plans the rebase without changing the image", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+				newBaseImg, err := remote.NewImage(newBase, authn.DefaultKeychain, remote.FromBaseImage(newBase))
+				h.AssertNil(t, err)
+				diffIDs, err := img.LayerDiffIDs()
+				h.AssertNil(t, err)
+				newBaseDiffIDs, err := newBaseImg.LayerDiffIDs()
 				h.AssertNil(t, err)
 
-				h.AssertNil(t, img.RemoveLabel("custom.label"))
-				h.AssertNil(t, img.Save())
+				plan, err := img.RebasePlan(oldTopLayerDiffID, newBaseImg)
+				h.AssertNil(t, err)
+				h.AssertEq(t, plan.RemovedLayers, diffIDs[:len(oldBaseLayers)])
+				h.AssertEq(t, plan.KeptLayers, diffIDs[len(oldBaseLayers):])
+				h.AssertEq(t, plan.NewBaseLayers, newBaseDiffIDs)
 
-				testImg, err := remote.NewImage(
-					"test",
-					authn.DefaultKeychain,
-					remote.FromBaseImage(repoName),
+				afterDiffIDs, err := img.LayerDiffIDs()
+				h.AssertNil(t, err)
+				h.AssertEq(t, afterDiffIDs, diffIDs)
+			})
+
+			it("checks the image starts with the old base when it is given", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+				newBaseImg, err := remote.NewImage(newBase, authn.DefaultKeychain, remote.FromBaseImage(newBase))
+				h.AssertNil(t, err)
+
+				oldBaseImg, err := remote.NewImage(oldBase, authn.DefaultKeychain, remote.FromBaseImage(oldBase))
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.Rebase(oldTopLayerDiffID, newBaseImg, imgutil.WithOldBase(oldBaseImg)))
+				h.AssertNil(t, img.Save())
+				h.AssertEq(t,
+					h.FetchManifestLayers(t, repoName),
+					append(newBaseLayers, repoTopLayers...),
 				)
+
+				err = img.Rebase(oldTopLayerDiffID, newBaseImg, imgutil.WithOldBase(oldBaseImg))
+				h.AssertError(t, err, fmt.Sprintf("'%s' does not start with the layers of old base '%s'", repoName, oldBase))
+			})
+
+			it("switches to a base from another backend", func() {
+				foreignBaseLayerPath, err := h.CreateSingleFileLayerTar("/foreign-base.txt", "foreign-base", "linux")
 				h.AssertNil(t, err)
+				defer os.Remove(foreignBaseLayerPath)
 
-				remoteLabel, err := testImg.Label("custom.label")
+				foreignBase := fakes.NewImage("foreign-base", "", nil)
+				h.AssertNil(t, foreignBase.AddLayer(foreignBaseLayerPath))
+
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
 				h.AssertNil(t, err)
-				h.AssertEq(t, remoteLabel, "")
+
+				h.AssertNil(t, img.Rebase(oldTopLayerDiffID, foreignBase))
+				h.AssertNil(t, img.Save())
+
+				repoLayers := h.FetchManifestLayers(t, repoName)
+				h.AssertEq(t, len(repoLayers), 1+len(repoTopLayers))
+				h.AssertEq(t, repoLayers[1:], repoTopLayers)
+
+				rebasedImgConfig := h.FetchManifestImageConfigFile(t, repoName)
+				h.AssertEq(t, rebasedImgConfig.RootFS.DiffIDs[0].String(), h.FileDiffID(t, foreignBaseLayerPath))
 			})
 		})
 	})
 
-	when("#SetEnv", func() {
-		it("sets the environment", func() {
-			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
-			h.AssertNil(t, err)
+	when("#TopLayer", func() {
+		when("image exists", func() {
+			it("returns the digest for the top layer (useful for rebasing)", func() {
+				baseLayerPath, err := h.CreateSingleFileLayerTar("/old-base.txt", "old-base", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(baseLayerPath)
 
-			err = img.SetEnv("ENV_KEY", "ENV_VAL")
-			h.AssertNil(t, err)
+				topLayerPath, err := h.CreateSingleFileLayerTar("/top-layer.txt", "top-layer", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(topLayerPath)
 
-			h.AssertNil(t, img.Save())
+				expectedTopLayerDiffID := h.FileDiffID(t, topLayerPath)
 
-			configFile := h.FetchManifestImageConfigFile(t, repoName)
-			h.AssertContains(t, configFile.Config.Env, "ENV_KEY=ENV_VAL")
-		})
+				existingImage, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+				h.AssertNil(t, err)
 
-		when("the key already exists", func() {
-			it("overrides the existing key", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				err = existingImage.AddLayer(baseLayerPath)
 				h.AssertNil(t, err)
 
-				err = img.SetEnv("ENV_KEY", "SOME_VAL")
+				err = existingImage.AddLayer(topLayerPath)
 				h.AssertNil(t, err)
 
-				err = img.SetEnv("ENV_KEY", "SOME_OTHER_VAL")
+				h.AssertNil(t, existingImage.Save())
+
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
 				h.AssertNil(t, err)
 
-				h.AssertNil(t, img.Save())
+				actualTopLayerDiffID, err := img.TopLayer()
+				h.AssertNil(t, err)
 
-				configFile := h.FetchManifestImageConfigFile(t, repoName)
-				h.AssertContains(t, configFile.Config.Env, "ENV_KEY=SOME_OTHER_VAL")
-				h.AssertDoesNotContain(t, configFile.Config.Env, "ENV_KEY=SOME_VAL")
+				h.AssertEq(t, actualTopLayerDiffID, expectedTopLayerDiffID)
 			})
 		})
 
-		when("windows", func() {
-			it("ignores case", func() {
+		when("the image has no layers", func() {
+			it("returns an error", func() {
 				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
 				h.AssertNil(t, err)
 
-				imgOS, err := img.OS()
+				_, err = img.TopLayer()
+				h.AssertError(t, err, "has no layers")
+			})
+		})
+	})
+
+	when("#GetLayer", func() {
+		when("the layer exists", func() {
+			it("returns the uncompressed layer tar", func() {
+				layerPath, err := h.CreateSingleFileLayerTar("/some-layer.txt", "some-layer", "linux")
 				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
 
-				if imgOS != "windows" {
-					t.Skip("windows test")
-				}
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.AddLayer(layerPath))
 
-				err = img.SetEnv("ENV_KEY", "SOME_VAL")
+				readCloser, err := img.GetLayer(h.FileDiffID(t, layerPath))
 				h.AssertNil(t, err)
+				defer readCloser.Close()
 
-				err = img.SetEnv("env_key", "SOME_OTHER_VAL")
+				tr := tar.NewReader(readCloser)
+				header, err := tr.Next()
 				h.AssertNil(t, err)
+				h.AssertEq(t, header.Name, "/some-layer.txt")
 
-				err = img.SetEnv("env_key2", "SOME_VAL")
+				contents, err := ioutil.ReadAll(tr)
 				h.AssertNil(t, err)
+				h.AssertEq(t, string(contents), "some-layer")
+			})
 
-				err = img.SetEnv("ENV_KEY2", "SOME_OTHER_VAL")
+			it("accepts a diff ID without the sha256 prefix", func() {
+				layerPath, err := h.CreateSingleFileLayerTar("/some-layer.txt", "some-layer", "linux")
 				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
 
-				h.AssertNil(t, img.Save())
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.AddLayer(layerPath))
 
-				configFile := h.FetchManifestImageConfigFile(t, repoName)
+				readCloser, err := img.GetLayer(strings.TrimPrefix(h.FileDiffID(t, layerPath), "sha256:"))
+				h.AssertNil(t, err)
+				h.AssertNil(t, readCloser.Close())
+			})
+		})
 
-				h.AssertContains(t, configFile.Config.Env, "env_key=SOME_OTHER_VAL")
-				h.AssertDoesNotContain(t, configFile.Config.Env, "ENV_KEY=SOME_VAL")
-				h.AssertDoesNotContain(t, configFile.Config.Env, "ENV_KEY=SOME_OTHER_VAL")
+		when("the layer does not exist", func() {
+			it("returns an error", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
 
-				h.AssertContains(t, configFile.Config.Env, "ENV_KEY2=SOME_OTHER_VAL")
-				h.AssertDoesNotContain(t, configFile.Config.Env, "env_key2=SOME_OTHER_VAL")
-				h.AssertDoesNotContain(t, configFile.Config.Env, "env_key2=SOME_VAL")
+				_, err = img.GetLayer("sha256:not-exist")
+				h.AssertError(
+					t,
+					err,
+					fmt.Sprintf("image '%s' does not contain layer with diff ID 'sha256:not-exist'", repoName),
+				)
 			})
 		})
 	})
 
-	when("#SetWorkingDir", func() {
-		it("sets the environment", func() {
+	when("#GetCompressedLayer", func() {
+		it("returns the gzipped layer tar", func() {
+			layerPath, layerDiffID := h.SingleFileLayerTar(t, "/some-layer.txt", "some-layer", "linux")
+			defer os.Remove(layerPath)
+
 			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
 			h.AssertNil(t, err)
+			h.AssertNil(t, img.AddLayer(layerPath))
 
-			err = img.SetWorkingDir("/some/work/dir")
+			readCloser, err := img.GetCompressedLayer(layerDiffID)
 			h.AssertNil(t, err)
+			defer readCloser.Close()
 
-			h.AssertNil(t, img.Save())
+			gzr, err := gzip.NewReader(readCloser)
+			h.AssertNil(t, err)
+			tr := tar.NewReader(gzr)
+			header, err := tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, header.Name, "/some-layer.txt")
 
-			configFile := h.FetchManifestImageConfigFile(t, repoName)
-			h.AssertEq(t, configFile.Config.WorkingDir, "/some/work/dir")
+			contents, err := ioutil.ReadAll(tr)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "some-layer")
+		})
+
+		it("returns an error when the layer does not exist", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			_, err = img.GetCompressedLayer("sha256:not-exist")
+			h.AssertError(t, err, fmt.Sprintf("image '%s' does not contain layer with diff ID 'sha256:not-exist'", repoName))
 		})
 	})
 
-	when("#SetEntrypoint", func() {
-		it("sets the entrypoint", func() {
+	when("#NumberOfLayers", func() {
+		it("returns the number of layers", func() {
 			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
 			h.AssertNil(t, err)
 
-			err = img.SetEntrypoint("some", "entrypoint")
+			count, err := img.NumberOfLayers()
 			h.AssertNil(t, err)
+			h.AssertEq(t, count, 0)
 
-			h.AssertNil(t, img.Save())
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
 
-			configFile := h.FetchManifestImageConfigFile(t, repoName)
-			h.AssertEq(t, configFile.Config.Entrypoint, []string{"some", "entrypoint"})
+			count, err = img.NumberOfLayers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, count, 1)
 		})
 	})
 
-	when("#SetCmd", func() {
-		it("sets the cmd", func() {
+	when("#LayerDiffIDs #LayerDigests", func() {
+		it("lists every layer", func() {
 			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
 			h.AssertNil(t, err)
 
-			err = img.SetCmd("some", "cmd")
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
 			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
 
-			h.AssertNil(t, img.Save())
+			diffIDs, err := img.LayerDiffIDs()
+			h.AssertNil(t, err)
+			h.AssertEq(t, diffIDs, []string{h.FileDiffID(t, layerPath)})
 
-			configFile := h.FetchManifestImageConfigFile(t, repoName)
-			h.AssertEq(t, configFile.Config.Cmd, []string{"some", "cmd"})
+			layer, err := tarball.LayerFromFile(layerPath)
+			h.AssertNil(t, err)
+			digest, err := layer.Digest()
+			h.AssertNil(t, err)
+
+			digests, err := img.(*remote.Image).LayerDigests()
+			h.AssertNil(t, err)
+			h.AssertEq(t, digests, []string{digest.String()})
 		})
 	})
 
-	when("#SetOS #SetOSVersion #SetArchitecture", func() {
-		it("sets the os/arch", func() {
+	when("#GetLayerByDigest", func() {
+		it("returns the uncompressed layer with the compressed digest", func() {
 			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
 			h.AssertNil(t, err)
 
-			err = img.SetOS("foobaros")
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
 			h.AssertNil(t, err)
-			err = img.SetOSVersion("1.2.3.4")
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+			h.AssertNil(t, img.Save())
+
+			img, err = remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
 			h.AssertNil(t, err)
-			err = img.SetArchitecture("arm64")
+			digests, err := img.(*remote.Image).LayerDigests()
 			h.AssertNil(t, err)
 
-			h.AssertNil(t, img.Save())
+			rc, err := img.(*remote.Image).GetLayerByDigest(digests[0])
+			h.AssertNil(t, err)
+			defer rc.Close()
+			contents, err := ioutil.ReadAll(rc)
+			h.AssertNil(t, err)
 
-			configFile := h.FetchManifestImageConfigFile(t, repoName)
-			h.AssertEq(t, configFile.OS, "foobaros")
-			h.AssertEq(t, configFile.OSVersion, "1.2.3.4")
-			h.AssertEq(t, configFile.Architecture, "arm64")
+			expected, err := ioutil.ReadFile(layerPath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, contents, expected)
 		})
-	})
-
-	when("#Rebase", func() {
-		when("image exists", func() {
-			var oldBase, newBase, oldTopLayerDiffID string
-			var oldBaseLayers, newBaseLayers, repoTopLayers []string
-			it.Before(func() {
-				// new base
-				newBase = newTestImageName("pack-newbase-test")
-				newBaseLayer1Path, err := h.CreateSingleFileLayerTar("/new-base.txt", "new-base", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(newBaseLayer1Path)
-
-				newBaseLayer2Path, err := h.CreateSingleFileLayerTar("/otherfile.txt", "text-new-base", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(newBaseLayer2Path)
 
-				newBaseImage, err := remote.NewImage(newBase, authn.DefaultKeychain)
-				h.AssertNil(t, err)
+		it("returns an error for unknown digests", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
 
-				err = newBaseImage.AddLayer(newBaseLayer1Path)
-				h.AssertNil(t, err)
+			digest := "sha256:" + strings.Repeat("0", 64)
+			_, err = img.(*remote.Image).GetLayerByDigest(digest)
+			h.AssertError(t, err, fmt.Sprintf("does not contain layer with digest '%s'", digest))
+		})
+	})
 
-				err = newBaseImage.AddLayer(newBaseLayer2Path)
-				h.AssertNil(t, err)
+	when("#Size", func() {
+		it("returns the sum of the layer and config sizes", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
 
-				h.AssertNil(t, newBaseImage.Save())
+			emptySize, err := img.Size()
+			h.AssertNil(t, err)
+			h.AssertEq(t, emptySize > 0, true)
 
-				newBaseLayers = h.FetchManifestLayers(t, newBase)
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
 
-				// old base image
-				oldBase = newTestImageName("pack-oldbase-test")
-				oldBaseLayer1Path, err := h.CreateSingleFileLayerTar("/old-base.txt", "old-base", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(oldBaseLayer1Path)
+			layer, err := tarball.LayerFromFile(layerPath)
+			h.AssertNil(t, err)
+			layerSize, err := layer.Size()
+			h.AssertNil(t, err)
 
-				oldBaseLayer2Path, err := h.CreateSingleFileLayerTar("/otherfile.txt", "text-old-base", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(oldBaseLayer2Path)
+			size, err := img.Size()
+			h.AssertNil(t, err)
+			h.AssertEq(t, size > emptySize+layerSize-1, true)
+		})
+	})
 
-				oldBaseImage, err := remote.NewImage(oldBase, authn.DefaultKeychain)
-				h.AssertNil(t, err)
+	when("#RawConfig", func() {
+		it("returns the config the image is saved with", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
 
-				err = oldBaseImage.AddLayer(oldBaseLayer1Path)
-				h.AssertNil(t, err)
+			rawConfig, err := img.RawConfig()
+			h.AssertNil(t, err)
 
-				err = oldBaseImage.AddLayer(oldBaseLayer2Path)
-				h.AssertNil(t, err)
+			var configFile v1.ConfigFile
+			h.AssertNil(t, json.Unmarshal(rawConfig, &configFile))
+			h.AssertEq(t, configFile.Config.Labels["mykey"], "myvalue")
+		})
+	})
 
-				oldTopLayerDiffID = h.FileDiffID(t, oldBaseLayer2Path)
+	when("#ConfigFile", func() {
+		it("returns the config the image is saved with, unaffected by later changes", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
 
-				h.AssertNil(t, oldBaseImage.Save())
+			layerPath, layerDiffID := h.SingleFileLayerTar(t, "/new-layer.txt", "new-layer", "linux")
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
 
-				oldBaseLayers = h.FetchManifestLayers(t, oldBase)
+			configFile, err := img.ConfigFile()
+			h.AssertNil(t, err)
+			h.AssertEq(t, configFile.Config.Labels["mykey"], "myvalue")
+			h.AssertEq(t, configFile.RootFS.DiffIDs[len(configFile.RootFS.DiffIDs)-1].String(), layerDiffID)
 
-				// original image
-				origLayer1Path, err := h.CreateSingleFileLayerTar("/bmyimage.txt", "text-from-image-1", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(origLayer1Path)
+			configFile.Config.Labels["mykey"] = "othervalue"
+			label, err := img.Label("mykey")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "myvalue")
+		})
+	})
 
-				origLayer2Path, err := h.CreateSingleFileLayerTar("/myimage2.txt", "text-from-image-2", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(origLayer2Path)
+	when("#Manifest", func() {
+		it("returns the manifest the image is saved with", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
 
-				origImage, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(oldBase))
-				h.AssertNil(t, err)
+			tarPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(tarPath)
+			h.AssertNil(t, img.AddLayer(tarPath))
+			h.AssertNil(t, img.SetAnnotation("mykey", "myvalue"))
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
 
-				err = origImage.AddLayer(origLayer1Path)
-				h.AssertNil(t, err)
+			manifest, err := img.(*remote.Image).Manifest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(manifest.Layers), 1)
+			h.AssertEq(t, manifest.Annotations["mykey"], "myvalue")
 
-				err = origImage.AddLayer(origLayer2Path)
-				h.AssertNil(t, err)
+			rawConfig, err := img.RawConfig()
+			h.AssertNil(t, err)
+			h.AssertEq(t, manifest.Config.Size, int64(len(rawConfig)))
+		})
+	})
 
-				h.AssertNil(t, origImage.Save())
+	when("#Squash", func() {
+		it("replaces the layers with a single layer of the resulting filesystem", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
 
-				repoLayers := h.FetchManifestLayers(t, repoName)
-				repoTopLayers = repoLayers[len(oldBaseLayers):]
-			})
+			for _, layer := range []struct{ path, contents string }{
+				{"/file.txt", "file"},
+				{"/keep.txt", "keep"},
+				{"/.wh.file.txt", ""},
+			} {
+				layerPath, err := h.CreateSingleFileLayerTar(layer.path, layer.contents, "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+				h.AssertNil(t, img.AddLayer(layerPath))
+			}
+			h.AssertNil(t, img.(*remote.Image).SetAnnotation("some-key", "some-val"))
 
-			it("switches the base", func() {
-				// Before
-				h.AssertEq(t,
-					h.FetchManifestLayers(t, repoName),
-					append(oldBaseLayers, repoTopLayers...),
-				)
+			h.AssertNil(t, img.Squash())
 
-				// Run rebase
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
-				h.AssertNil(t, err)
+			count, err := img.NumberOfLayers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, count, 1)
 
-				newBaseImg, err := remote.NewImage(newBase, authn.DefaultKeychain, remote.FromBaseImage(newBase))
-				h.AssertNil(t, err)
+			annotations, err := img.(*remote.Image).Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, annotations, map[string]string{"some-key": "some-val"})
 
-				err = img.Rebase(oldTopLayerDiffID, newBaseImg)
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			rc, err := img.GetLayer(topLayer)
+			h.AssertNil(t, err)
+			defer rc.Close()
+
+			var names []string
+			tr := tar.NewReader(rc)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
 				h.AssertNil(t, err)
-				h.AssertNil(t, img.Save())
+				names = append(names, hdr.Name)
+			}
+			h.AssertEq(t, names, []string{"/keep.txt"})
 
-				// After
-				h.AssertEq(t,
-					h.FetchManifestLayers(t, repoName),
-					append(newBaseLayers, repoTopLayers...),
-				)
+			h.AssertNil(t, img.Save())
 
-				newBaseConfig := h.FetchManifestImageConfigFile(t, newBase)
-				rebasedImgConfig := h.FetchManifestImageConfigFile(t, repoName)
-				h.AssertEq(t, rebasedImgConfig.OS, newBaseConfig.OS)
-				h.AssertEq(t, rebasedImgConfig.OSVersion, newBaseConfig.OSVersion)
-				h.AssertEq(t, rebasedImgConfig.Architecture, newBaseConfig.Architecture)
-			})
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, len(configFile.History), 1)
+			h.AssertEq(t, configFile.History[0].CreatedBy, imgutil.SquashedCreatedBy)
 		})
-	})
-
-	when("#TopLayer", func() {
-		when("image exists", func() {
-			it("returns the digest for the top layer (useful for rebasing)", func() {
-				baseLayerPath, err := h.CreateSingleFileLayerTar("/old-base.txt", "old-base", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(baseLayerPath)
 
-				topLayerPath, err := h.CreateSingleFileLayerTar("/top-layer.txt", "top-layer", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(topLayerPath)
+		it("reads the squashed layer from a temp file that Close removes", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
 
-				expectedTopLayerDiffID := h.FileDiffID(t, topLayerPath)
+			layerPath, err := h.CreateSingleFileLayerTar("/file.txt", "file", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
 
-				existingImage, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
-				h.AssertNil(t, err)
+			h.AssertNil(t, img.Squash())
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
 
-				err = existingImage.AddLayer(baseLayerPath)
-				h.AssertNil(t, err)
+			h.AssertNil(t, img.(*remote.Image).Close())
+			_, err = img.GetLayer(topLayer)
+			h.AssertError(t, err, "no such file or directory")
+		})
+	})
 
-				err = existingImage.AddLayer(topLayerPath)
-				h.AssertNil(t, err)
+	when("#NormalizeHistory", func() {
+		it("trims the oldest entries, keeping entries for instructions that did not create a layer", func() {
+			layerPath, err := h.CreateSingleFileLayerTar("/some-layer.txt", "some-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
 
-				h.AssertNil(t, existingImage.Save())
+			layer, err := tarball.LayerFromFile(layerPath)
+			h.AssertNil(t, err)
+			v1Image, err := mutate.AppendLayers(empty.Image, layer)
+			h.AssertNil(t, err)
+			cfg, err := v1Image.ConfigFile()
+			h.AssertNil(t, err)
+			cfg = cfg.DeepCopy()
+			cfg.History = []v1.History{
+				{CreatedBy: "some-trimmed-layer"},
+				{CreatedBy: "some-layer"},
+				{CreatedBy: "some-env", EmptyLayer: true},
+			}
+			v1Image, err = mutate.ConfigFile(v1Image, cfg)
+			h.AssertNil(t, err)
 
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
-				h.AssertNil(t, err)
+			tag, err := name.NewTag(repoName, name.WeakValidation)
+			h.AssertNil(t, err)
+			tarFile, err := ioutil.TempFile("", "remote-image-tarball")
+			h.AssertNil(t, err)
+			tarFile.Close()
+			defer os.Remove(tarFile.Name())
+			h.AssertNil(t, tarball.WriteToFile(tarFile.Name(), tag, v1Image))
 
-				actualTopLayerDiffID, err := img.TopLayer()
-				h.AssertNil(t, err)
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromTarball(tarFile.Name()))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.NormalizeHistory())
 
-				h.AssertEq(t, actualTopLayerDiffID, expectedTopLayerDiffID)
+			var configFile v1.ConfigFile
+			rawConfig, err := img.RawConfig()
+			h.AssertNil(t, err)
+			h.AssertNil(t, json.Unmarshal(rawConfig, &configFile))
+			h.AssertEq(t, configFile.History, []v1.History{
+				{CreatedBy: "some-layer"},
+				{CreatedBy: "some-env", EmptyLayer: true},
 			})
 		})
 
-		when("the image has no layers", func() {
-			it("returns an error", func() {
-				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
-				h.AssertNil(t, err)
+		it("is only saved for the layers added to the image", func() {
+			layerPath, err := h.CreateSingleFileLayerTar("/some-layer.txt", "some-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
 
-				_, err = img.TopLayer()
-				h.AssertError(t, err, "has no layers")
-			})
+			baseImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, baseImage.AddLayer(layerPath, imgutil.WithCreatedBy("some-base-layer")))
+			h.AssertNil(t, baseImage.Save())
+
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.AddLayer(layerPath, imgutil.WithCreatedBy("some-added-layer")))
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, len(configFile.History), 2)
+			h.AssertEq(t, configFile.History[0].CreatedBy, "")
+			h.AssertEq(t, configFile.History[1].CreatedBy, "some-added-layer")
 		})
 	})
 
@@ -828,6 +2442,74 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 			h.AssertEq(t, oldLayerDiffID, manifestLayerDiffIDs[len(manifestLayerDiffIDs)-2])
 			h.AssertEq(t, newLayerDiffID, manifestLayerDiffIDs[len(manifestLayerDiffIDs)-1])
 		})
+
+		when("layer options are provided", func() {
+			it("records them in the history of the layer", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				layerPath, _ := h.SingleFileLayerTar(t, "/new-layer.txt", "new-layer", "linux")
+				defer os.Remove(layerPath)
+
+				createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+				h.AssertNil(t, img.AddLayer(layerPath, imgutil.WithCreatedBy("some-command"), imgutil.WithLayerCreatedAt(createdAt)))
+				h.AssertNil(t, img.AddLayer(layerPath))
+
+				h.AssertNil(t, img.Save())
+
+				configFile := h.FetchManifestImageConfigFile(t, repoName)
+				h.AssertEq(t, len(configFile.History), 2)
+				h.AssertEq(t, configFile.History[0].CreatedBy, "some-command")
+				h.AssertEq(t, configFile.History[0].Created.UTC(), createdAt)
+				h.AssertEq(t, configFile.History[1].CreatedBy, "")
+				h.AssertEq(t, configFile.History[1].Created.UTC(), imgutil.NormalizedDateTime)
+			})
+		})
+	})
+
+	when("#AddLayerFromReader", func() {
+		it("appends a layer", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+
+			f, err := os.Open(layerPath)
+			h.AssertNil(t, err)
+			defer f.Close()
+
+			h.AssertNil(t, img.AddLayerFromReader(f))
+			h.AssertNil(t, img.Save())
+
+			manifestLayerDiffIDs := h.FetchManifestLayers(t, repoName)
+			h.AssertEq(t, manifestLayerDiffIDs[len(manifestLayerDiffIDs)-1], h.FileDiffID(t, layerPath))
+		})
+
+		it("reads the layer from a temp file that Close removes", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+
+			f, err := os.Open(layerPath)
+			h.AssertNil(t, err)
+			defer f.Close()
+
+			h.AssertNil(t, img.AddLayerFromReader(f))
+
+			diffID := h.FileDiffID(t, layerPath)
+			rc, err := img.GetLayer(diffID)
+			h.AssertNil(t, err)
+			h.AssertNil(t, rc.Close())
+
+			h.AssertNil(t, img.(*remote.Image).Close())
+			_, err = img.GetLayer(diffID)
+			h.AssertError(t, err, "no such file or directory")
+		})
 	})
 
 	when("#AddLayerWithDiffID", func() {
@@ -888,66 +2570,228 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				)
 				h.AssertNil(t, err)
 
-				layer1Path, err := h.CreateSingleFileLayerTar("/layer-1.txt", "old-layer-1", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(layer1Path)
+				layer1Path, err := h.CreateSingleFileLayerTar("/layer-1.txt", "old-layer-1", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(layer1Path)
+
+				prevLayer1SHA = h.FileDiffID(t, layer1Path)
+
+				layer2Path, err := h.CreateSingleFileLayerTar("/layer-2.txt", "old-layer-2", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(layer2Path)
+
+				prevLayer2SHA = h.FileDiffID(t, layer2Path)
+
+				h.AssertNil(t, prevImage.AddLayer(layer1Path))
+				h.AssertNil(t, prevImage.AddLayer(layer2Path))
+
+				h.AssertNil(t, prevImage.Save())
+			})
+
+			it("reuses a layer", func() {
+				img, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithPreviousImage(prevImageName),
+				)
+				h.AssertNil(t, err)
+
+				newBaseLayerPath, err := h.CreateSingleFileLayerTar("/new-base.txt", "base-content", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(newBaseLayerPath)
+
+				h.AssertNil(t, img.AddLayer(newBaseLayerPath))
+
+				err = img.ReuseLayer(prevLayer2SHA)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.Save())
+
+				manifestLayers := h.FetchManifestLayers(t, repoName)
+
+				newLayer1SHA := manifestLayers[len(manifestLayers)-2]
+				reusedLayer2SHA := manifestLayers[len(manifestLayers)-1]
+
+				h.AssertNotEq(t, prevLayer1SHA, newLayer1SHA)
+				h.AssertEq(t, prevLayer2SHA, reusedLayer2SHA)
+			})
+
+			it("reuses a layer given by its diff ID with or without the algorithm prefix", func() {
+				img, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithPreviousImage(prevImageName),
+				)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.ReuseLayer(strings.TrimPrefix(prevLayer1SHA, "sha256:")))
+				h.AssertNil(t, img.ReuseLayer(prevLayer2SHA))
+
+				h.AssertNil(t, img.Save())
+
+				h.AssertEq(t, h.FetchManifestLayers(t, repoName), []string{prevLayer1SHA, prevLayer2SHA})
+			})
+
+			it("returns error on nonexistent layer", func() {
+				img, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithPreviousImage(prevImageName),
+				)
+				h.AssertNil(t, err)
+
+				img.Rename(repoName)
+
+				err = img.ReuseLayer("some-bad-sha")
+
+				h.AssertError(t, err, "previous image did not have layer with diff id 'some-bad-sha'")
+			})
+
+			when("#WithLayerCache", func() {
+				var cacheDir string
+
+				it.Before(func() {
+					var err error
+					cacheDir, err = ioutil.TempDir("", "layer-cache")
+					h.AssertNil(t, err)
+				})
+
+				it.After(func() {
+					h.AssertNil(t, os.RemoveAll(cacheDir))
+				})
+
+				it("reuses cached layers without the previous image", func() {
+					img, err := remote.NewImage(
+						newTestImageName(),
+						authn.DefaultKeychain,
+						remote.WithPreviousImage(prevImageName),
+						remote.WithLayerCache(cacheDir),
+					)
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.ReuseLayer(prevLayer2SHA))
+
+					img, err = remote.NewImage(repoName, authn.DefaultKeychain, remote.WithLayerCache(cacheDir))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.ReuseLayer(prevLayer2SHA))
+					h.AssertNil(t, img.Save())
+
+					h.AssertEq(t, h.FetchManifestLayers(t, repoName), []string{prevLayer2SHA})
+				})
+
+				it("discards cached layers that do not match their diff ID", func() {
+					cachePath := filepath.Join(cacheDir, strings.Replace(prevLayer2SHA, ":", "-", 1)+".tar.gz")
+					h.AssertNil(t, ioutil.WriteFile(cachePath, []byte("corrupt"), 0644))
+
+					img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithLayerCache(cacheDir))
+					h.AssertNil(t, err)
+
+					err = img.ReuseLayer(prevLayer2SHA)
+					h.AssertError(t, err, fmt.Sprintf("previous image did not have layer with diff id '%s'", prevLayer2SHA))
+					_, err = os.Stat(cachePath)
+					h.AssertEq(t, os.IsNotExist(err), true)
+				})
+			})
+		})
+	})
+
+	when("#ReuseLayerOrAdd", func() {
+		var (
+			prevImageName string
+			prevLayerSHA  string
+		)
+
+		it.Before(func() {
+			prevImageName = newTestImageName()
+			prevImage, err := remote.NewImage(prevImageName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			var layerPath string
+			layerPath, prevLayerSHA = h.SingleFileLayerTar(t, "/layer.txt", "old-layer", "linux")
+			defer os.Remove(layerPath)
+			h.AssertNil(t, prevImage.AddLayer(layerPath))
+			h.AssertNil(t, prevImage.Save())
+		})
 
-				prevLayer1SHA = h.FileDiffID(t, layer1Path)
+		it("reuses the layer when the previous image has it", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithPreviousImage(prevImageName))
+			h.AssertNil(t, err)
 
-				layer2Path, err := h.CreateSingleFileLayerTar("/layer-2.txt", "old-layer-2", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(layer2Path)
+			h.AssertNil(t, img.ReuseLayerOrAdd(prevLayerSHA, "/does-not-exist.tar"))
 
-				prevLayer2SHA = h.FileDiffID(t, layer2Path)
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, prevLayerSHA)
+		})
 
-				h.AssertNil(t, prevImage.AddLayer(layer1Path))
-				h.AssertNil(t, prevImage.AddLayer(layer2Path))
+		it("adds the layer when the previous image does not have it", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithPreviousImage(prevImageName))
+			h.AssertNil(t, err)
 
-				h.AssertNil(t, prevImage.Save())
-			})
+			layerPath, layerDiffID := h.SingleFileLayerTar(t, "/layer.txt", "new-layer", "linux")
+			defer os.Remove(layerPath)
 
-			it("reuses a layer", func() {
-				img, err := remote.NewImage(
-					repoName,
-					authn.DefaultKeychain,
-					remote.WithPreviousImage(prevImageName),
-				)
-				h.AssertNil(t, err)
+			h.AssertNil(t, img.ReuseLayerOrAdd(layerDiffID, layerPath))
 
-				newBaseLayerPath, err := h.CreateSingleFileLayerTar("/new-base.txt", "base-content", "linux")
-				h.AssertNil(t, err)
-				defer os.Remove(newBaseLayerPath)
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, layerDiffID)
+		})
+	})
 
-				h.AssertNil(t, img.AddLayer(newBaseLayerPath))
+	when("#AddLayerFromImage", func() {
+		var (
+			layerPath   string
+			layerDiffID string
+		)
 
-				err = img.ReuseLayer(prevLayer2SHA)
-				h.AssertNil(t, err)
+		it.Before(func() {
+			layerPath, layerDiffID = h.SingleFileLayerTar(t, "/layer.txt", "transplanted", "linux")
+		})
 
-				h.AssertNil(t, img.Save())
+		it.After(func() {
+			os.Remove(layerPath)
+		})
 
-				manifestLayers := h.FetchManifestLayers(t, repoName)
+		it("adds the layer from an image of another backend", func() {
+			src := fakes.NewImage("some-image", "", nil)
+			h.AssertNil(t, src.AddLayer(layerPath))
 
-				newLayer1SHA := manifestLayers[len(manifestLayers)-2]
-				reusedLayer2SHA := manifestLayers[len(manifestLayers)-1]
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.AddLayerFromImage(src, layerDiffID))
+			h.AssertNil(t, img.Save())
 
-				h.AssertNotEq(t, prevLayer1SHA, newLayer1SHA)
-				h.AssertEq(t, prevLayer2SHA, reusedLayer2SHA)
-			})
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, layerDiffID)
+		})
 
-			it("returns error on nonexistent layer", func() {
-				img, err := remote.NewImage(
-					repoName,
-					authn.DefaultKeychain,
-					remote.WithPreviousImage(prevImageName),
-				)
-				h.AssertNil(t, err)
+		it("adds the layer from another remote image", func() {
+			srcName := newTestImageName()
+			src, err := remote.NewImage(srcName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, src.AddLayer(layerPath))
+			h.AssertNil(t, src.Save())
 
-				img.Rename(repoName)
+			src, err = remote.NewImage(srcName, authn.DefaultKeychain, remote.FromBaseImage(srcName))
+			h.AssertNil(t, err)
 
-				err = img.ReuseLayer("some-bad-sha")
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.AddLayerFromImage(src, layerDiffID))
+			h.AssertNil(t, img.Save())
 
-				h.AssertError(t, err, "previous image did not have layer with diff id 'some-bad-sha'")
-			})
+			rc, err := img.GetLayer(layerDiffID)
+			h.AssertNil(t, err)
+			defer rc.Close()
+		})
+
+		it("returns an error when the source image does not have the layer", func() {
+			src := fakes.NewImage("some-image", "", nil)
+
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertError(t, img.AddLayerFromImage(src, layerDiffID), fmt.Sprintf("get layer '%s' from image 'some-image'", layerDiffID))
 		})
 	})
 
@@ -1053,6 +2897,42 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#SaveByDigest", func() {
+		it("saves the image by digest and returns the digest reference", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+
+			digestName, err := img.(*remote.Image).SaveByDigest()
+			h.AssertNil(t, err)
+
+			identifier, err := img.Identifier()
+			h.AssertNil(t, err)
+			h.AssertEq(t, digestName, identifier.String())
+
+			savedImg, err := remote.NewImage(
+				newTestImageName(),
+				authn.DefaultKeychain,
+				remote.FromBaseImage(digestName),
+			)
+			h.AssertNil(t, err)
+
+			label, err := savedImg.Label("mykey")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "myvalue")
+		})
+
+		it("does not push the tag", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			_, err = img.(*remote.Image).SaveByDigest()
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, img.Found(), false)
+		})
+	})
+
 	when("#Found", func() {
 		when("it exists", func() {
 			it("returns true, nil", func() {
@@ -1077,6 +2957,152 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#CheckFound", func() {
+		it("returns true for an existing image", func() {
+			origImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, origImage.Save())
+
+			image, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			found, err := image.(*remote.Image).CheckFound()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, true)
+		})
+
+		it("returns false without an error for a missing image", func() {
+			image, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			found, err := image.(*remote.Image).CheckFound()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, false)
+		})
+
+		it("returns ErrUnauthorized when the registry denies access", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v2/" {
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`))
+			}))
+			defer server.Close()
+
+			image, err := remote.NewImage(
+				strings.TrimPrefix(server.URL, "http://")+"/some/image",
+				authn.DefaultKeychain,
+				remote.WithInsecure(),
+			)
+			h.AssertNil(t, err)
+
+			found, err := image.(*remote.Image).CheckFound()
+			h.AssertEq(t, found, false)
+			h.AssertEq(t, errors.Cause(err) == remote.ErrUnauthorized, true)
+			h.AssertEq(t, image.Found(), false)
+		})
+	})
+
+	when("#Exists", func() {
+		it("returns true for an existing image", func() {
+			origImage, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, origImage.Save())
+
+			image, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			exists, err := image.(*remote.Image).Exists()
+			h.AssertNil(t, err)
+			h.AssertEq(t, exists, true)
+		})
+
+		it("returns false without an error for a missing image", func() {
+			image, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			exists, err := image.(*remote.Image).Exists()
+			h.AssertNil(t, err)
+			h.AssertEq(t, exists, false)
+		})
+
+		it("only requests the manifest headers", func() {
+			var methods []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v2/" {
+					return
+				}
+				methods = append(methods, r.Method)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			image, err := remote.NewImage(
+				strings.TrimPrefix(server.URL, "http://")+"/some/image",
+				authn.DefaultKeychain,
+				remote.WithInsecure(),
+			)
+			h.AssertNil(t, err)
+
+			exists, err := image.(*remote.Image).Exists()
+			h.AssertNil(t, err)
+			h.AssertEq(t, exists, true)
+			h.AssertEq(t, methods, []string{http.MethodHead})
+		})
+
+		it("returns ErrUnauthorized when the registry denies access", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v2/" {
+					return
+				}
+				w.WriteHeader(http.StatusUnauthorized)
+			}))
+			defer server.Close()
+
+			image, err := remote.NewImage(
+				strings.TrimPrefix(server.URL, "http://")+"/some/image",
+				authn.DefaultKeychain,
+				remote.WithInsecure(),
+			)
+			h.AssertNil(t, err)
+
+			exists, err := image.(*remote.Image).Exists()
+			h.AssertEq(t, exists, false)
+			h.AssertEq(t, errors.Cause(err) == remote.ErrUnauthorized, true)
+		})
+	})
+
+	when("#CopyToDaemon", func() {
+		it("loads the image into the daemon", func() {
+			dockerClient := h.DockerCli(t)
+
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+			h.AssertNil(t, img.SetLabel("mykey", "myvalue"))
+
+			localImg, err := img.(*remote.Image).CopyToDaemon(dockerClient)
+			h.AssertNil(t, err)
+			defer h.DockerRmi(dockerClient, repoName)
+
+			h.AssertEq(t, localImg.Found(), true)
+
+			label, err := localImg.Label("mykey")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "myvalue")
+
+			topLayer, err := localImg.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, h.FileDiffID(t, layerPath))
+		})
+	})
+
 	when("#Delete", func() {
 		when("it exists", func() {
 			var img imgutil.Image
@@ -1107,8 +3133,348 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertNil(t, err)
 
 				h.AssertEq(t, img.Found(), false)
-				h.AssertError(t, img.Delete(), "MANIFEST_UNKNOWN")
+				err = img.Delete()
+				h.AssertError(t, err, "MANIFEST_UNKNOWN")
+				h.AssertEq(t, errors.Is(err, imgutil.ErrImageNotFound), true)
+			})
+		})
+
+		when("the registry does not support deletes", func() {
+			it("returns ErrDeleteUnsupported", func() {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method == http.MethodDelete {
+						w.WriteHeader(http.StatusMethodNotAllowed)
+						return
+					}
+					w.WriteHeader(http.StatusOK)
+				}))
+				defer server.Close()
+
+				img, err := remote.NewImage(strings.TrimPrefix(server.URL, "http://")+"/some-repo", authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				err = img.Delete()
+				h.AssertEq(t, errors.Cause(err) == remote.ErrDeleteUnsupported, true)
 			})
 		})
 	})
+
+	when("#ListTags", func() {
+		it("returns the tags in the repository", func() {
+			for _, tag := range []string{"some-tag", "other-tag"} {
+				img, err := remote.NewImage(repoName+":"+tag, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.Save())
+			}
+
+			tags, err := remote.ListTags(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(tags), 2)
+			h.AssertContains(t, tags, "some-tag", "other-tag")
+		})
+
+		it("reads every page of a paginated tag list", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v2/" {
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Query().Get("last") == "" {
+					w.Header().Set("Link", `</v2/some/repo/tags/list?n=2&last=b>; rel="next"`)
+					_, _ = w.Write([]byte(`{"name":"some/repo","tags":["a","b"]}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"name":"some/repo","tags":["c"]}`))
+			}))
+			defer server.Close()
+
+			tags, err := remote.ListTags(strings.TrimPrefix(server.URL, "http://")+"/some/repo", authn.DefaultKeychain, remote.WithInsecure())
+			h.AssertNil(t, err)
+			h.AssertEq(t, tags, []string{"a", "b", "c"})
+		})
+	})
+}
+
+// recordingKeychain records the registries it resolves, always returning the credentials for target
+type recordingKeychain struct {
+	target   name.Registry
+	resolved []string
+}
+
+func (k *recordingKeychain) Resolve(r authn.Resource) (authn.Authenticator, error) {
+	k.resolved = append(k.resolved, r.RegistryStr())
+	return authn.DefaultKeychain.Resolve(k.target)
+}
+
+// staticKeychain always resolves to auth
+type staticKeychain struct {
+	auth authn.Authenticator
+}
+
+func (k *staticKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return k.auth, nil
+}
+
+// rejectCredentialsTransport challenges for basic auth on ping so that credentials are sent, responds to requests
+// that carry credentials with 401 Unauthorized and sends anonymous requests using http.DefaultTransport
+type rejectCredentialsTransport struct{}
+
+func (rejectCredentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == "/v2/" {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.StatusCode = http.StatusUnauthorized
+		resp.Header.Set("Www-Authenticate", `Basic realm="test"`)
+		return resp, nil
+	}
+	if req.Header.Get("Authorization") == "" {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	return &http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`)),
+		Request:    req,
+	}, nil
+}
+
+// mountTrackingTransport counts the blobs the registry mounted rather than accepting an upload for, and sends all
+// requests using http.DefaultTransport
+type mountTrackingTransport struct {
+	mu     sync.Mutex
+	mounts int
+}
+
+func (m *mountTrackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost || req.URL.Query().Get("mount") == "" {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusCreated {
+		m.mu.Lock()
+		m.mounts++
+		m.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// countingTransport counts the requests it sends using http.DefaultTransport
+type countingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// urlRecordingTransport records the URLs of the requests it sends using http.DefaultTransport
+type urlRecordingTransport struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+func (u *urlRecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u.mu.Lock()
+	u.urls = append(u.urls, req.URL.String())
+	u.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// sentTo reports whether a request was sent to a URL starting with prefix
+func (u *urlRecordingTransport) sentTo(prefix string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, url := range u.urls {
+		if strings.HasPrefix(url, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loopbackTransport sends all requests to localhost, whatever their host, trusting the certificates in its pool, and
+// records the schemes of the requests it sent
+type loopbackTransport struct {
+	mu        sync.Mutex
+	sent      map[string]bool
+	transport http.RoundTripper
+}
+
+func newLoopbackTransport(certPool *x509.CertPool) *loopbackTransport {
+	dialer := &net.Dialer{}
+	return &loopbackTransport{
+		sent: map[string]bool{},
+		transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				_, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				return dialer.DialContext(ctx, network, net.JoinHostPort("localhost", port))
+			},
+			TLSClientConfig: &tls.Config{RootCAs: certPool, ServerName: "localhost"},
+		},
+	}
+}
+
+func (l *loopbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l.mu.Lock()
+	l.sent[req.URL.Scheme] = true
+	l.mu.Unlock()
+	return l.transport.RoundTrip(req)
+}
+
+func (l *loopbackTransport) schemes() map[string]bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	schemes := map[string]bool{}
+	for scheme := range l.sent {
+		schemes[scheme] = true
+	}
+	return schemes
+}
+
+// uploadTrackingTransport records the most blob uploads it sent at once using http.DefaultTransport
+type uploadTrackingTransport struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (u *uploadTrackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPatch || !strings.Contains(req.URL.Path, "/blobs/uploads/") {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+
+	u.mu.Lock()
+	u.inFlight++
+	if u.inFlight > u.maxInFlight {
+		u.maxInFlight = u.inFlight
+	}
+	u.mu.Unlock()
+
+	defer func() {
+		u.mu.Lock()
+		u.inFlight--
+		u.mu.Unlock()
+	}()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// missingBlobsTransport responds to blob downloads with 404 Not Found and sends all other requests using
+// http.DefaultTransport
+type missingBlobsTransport struct{}
+
+func (missingBlobsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || !strings.Contains(req.URL.Path, "/blobs/sha256:") {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	return &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"errors":[{"code":"BLOB_UNKNOWN","message":"blob unknown to registry"}]}`)),
+		Request:    req,
+	}, nil
+}
+
+// BenchmarkUploadConcurrency compares uploading the layers of a 12 layer image one at a time with uploading them all
+// at once, over a link that adds latency to each request
+func BenchmarkUploadConcurrency(b *testing.B) {
+	var paths []string
+	for idx := 0; idx < 12; idx++ {
+		layerPath, err := h.CreateSingleFileLayerTar("/layer.txt", strings.Repeat(strconv.Itoa(idx), 1024*1024), "linux")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer os.Remove(layerPath)
+		paths = append(paths, layerPath)
+	}
+	transport := &latencyTransport{latency: 50 * time.Millisecond}
+
+	for _, concurrency := range []int{1, len(paths)} {
+		b.Run(fmt.Sprintf("WithUploadConcurrency(%d)", concurrency), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				// a new registry each time, so that no layer already exists in it
+				server := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+				img, err := remote.NewImage(
+					strings.TrimPrefix(server.URL, "http://")+"/benchmark-upload-concurrency",
+					authn.DefaultKeychain,
+					remote.WithTransport(transport),
+					remote.WithUploadConcurrency(concurrency),
+				)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, path := range paths {
+					if err := img.AddLayer(path); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StartTimer()
+
+				if err := img.Save(); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				server.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// latencyTransport waits for latency before sending each request using http.DefaultTransport
+type latencyTransport struct {
+	latency time.Duration
+}
+
+func (l *latencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	time.Sleep(l.latency)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// alterManifestTransport appends a newline to the manifests it pulls by digest and sends all requests using
+// http.DefaultTransport
+type alterManifestTransport struct{}
+
+func (alterManifestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet || !strings.Contains(req.URL.Path, "/manifests/sha256:") {
+		return resp, err
+	}
+	defer resp.Body.Close()
+	manifest, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(strings.NewReader(string(manifest) + "\n"))
+	resp.ContentLength = int64(len(manifest) + 1)
+	return resp, nil
+}
+
+// rejectFirstManifestTransport responds to the first manifest upload with 401 Unauthorized and sends all other
+// requests using http.DefaultTransport
+type rejectFirstManifestTransport struct {
+	rejected bool
+}
+
+func (r *rejectFirstManifestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.rejected || req.Method != http.MethodPut || !strings.Contains(req.URL.Path, "/manifests/") {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	r.rejected = true
+	return &http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"errors":[{"code":"UNAUTHORIZED","message":"token expired"}]}`)),
+		Request:    req,
+	}, nil
 }
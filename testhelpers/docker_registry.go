@@ -2,10 +2,21 @@ package testhelpers
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"testing"
@@ -23,6 +34,24 @@ type DockerRegistry struct {
 	DockerDirectory string
 	username        string
 	password        string
+	tls             bool
+	certPool        *x509.CertPool
+	tokenAuth       bool
+	tokenServer     *tokenServer
+	failFirst       int
+	failStatus      int
+	failingProxy    *failingProxy
+}
+
+type RegistryOption func(*DockerRegistry)
+
+// WithTLS serves the registry over HTTPS with a self-signed certificate for localhost, which clients can trust
+// using CertPool. go-containerregistry talks plain HTTP to localhost, so clients must also upgrade their requests
+// to HTTPS, for example with a custom transport.
+func WithTLS() RegistryOption {
+	return func(r *DockerRegistry) {
+		r.tls = true
+	}
 }
 
 var registryImageNames = map[string]string{
@@ -30,10 +59,35 @@ var registryImageNames = map[string]string{
 	"windows": "stefanscherer/registry-windows:2.6.2",
 }
 
-func NewDockerRegistry() *DockerRegistry {
-	return &DockerRegistry{
+// WithTokenAuth requires clients to authenticate with bearer tokens, issued by a token service that accepts the
+// registry's credentials over basic auth, as most hosted registries do. The credentials are written to the docker
+// config in dockerConfigDir.
+func WithTokenAuth(dockerConfigDir string) RegistryOption {
+	return func(r *DockerRegistry) {
+		r.tokenAuth = true
+		r.username = RandString(10)
+		r.password = RandString(10)
+		r.DockerDirectory = dockerConfigDir
+	}
+}
+
+// WithFailFirst responds to the first n write requests to the registry with status, to simulate transient failures.
+// Requests are sent through a proxy in front of the registry, so Port is the port of the proxy.
+func WithFailFirst(n int, status int) RegistryOption {
+	return func(r *DockerRegistry) {
+		r.failFirst = n
+		r.failStatus = status
+	}
+}
+
+func NewDockerRegistry(ops ...RegistryOption) *DockerRegistry {
+	registry := &DockerRegistry{
 		Name: "test-registry-" + RandString(10),
 	}
+	for _, op := range ops {
+		op(registry)
+	}
+	return registry
 }
 
 func NewDockerRegistryWithAuth(dockerConfigDir string) *DockerRegistry {
@@ -58,7 +112,7 @@ func (r *DockerRegistry) Start(t *testing.T) {
 
 	var htpasswdTar io.ReadCloser
 	registryEnv := []string{"REGISTRY_STORAGE_DELETE_ENABLED=true"}
-	if r.username != "" {
+	if r.username != "" && !r.tokenAuth {
 		// Create htpasswdTar and configure registry env
 		tempDir, err := ioutil.TempDir("", "test.registry")
 		AssertNil(t, err)
@@ -75,6 +129,34 @@ func (r *DockerRegistry) Start(t *testing.T) {
 		registryEnv = append(registryEnv, otherEnvs...)
 	}
 
+	var tokenCertPEM string
+	if r.tokenAuth {
+		// Start token server and configure registry env to trust the tokens it signs
+		key, certDER := selfSignedCertificate(t)
+		r.tokenServer = newTokenServer(key, certDER, r.username, r.password)
+		tokenCertPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
+
+		registryEnv = append(registryEnv,
+			"REGISTRY_AUTH=token",
+			"REGISTRY_AUTH_TOKEN_REALM="+r.tokenServer.url(),
+			"REGISTRY_AUTH_TOKEN_SERVICE="+tokenService,
+			"REGISTRY_AUTH_TOKEN_ISSUER="+tokenIssuer,
+			"REGISTRY_AUTH_TOKEN_ROOTCERTBUNDLE=/registry_test_token_cert.pem",
+		)
+	}
+
+	var certPEM, keyPEM string
+	if r.tls {
+		certPEM, keyPEM = generateCertificate(t)
+		r.certPool = x509.NewCertPool()
+		r.certPool.AppendCertsFromPEM([]byte(certPEM))
+
+		registryEnv = append(registryEnv,
+			"REGISTRY_HTTP_TLS_CERTIFICATE=/registry_test_cert.pem",
+			"REGISTRY_HTTP_TLS_KEY=/registry_test_key.pem",
+		)
+	}
+
 	// Create container
 	ctr, err := DockerCli(t).ContainerCreate(ctx, &container.Config{
 		Image: registryImageName,
@@ -92,6 +174,22 @@ func (r *DockerRegistry) Start(t *testing.T) {
 		AssertNil(t, DockerCli(t).CopyToContainer(ctx, ctr.ID, "/", htpasswdTar, types.CopyToContainerOptions{}))
 	}
 
+	if r.tokenAuth {
+		// Copy token signing certificate to container
+		certTar := CreateSingleFileTarReader("/registry_test_token_cert.pem", tokenCertPEM)
+		AssertNil(t, DockerCli(t).CopyToContainer(ctx, ctr.ID, "/", certTar, types.CopyToContainerOptions{}))
+		certTar.Close()
+	}
+
+	if r.tls {
+		// Copy certificate and key to container
+		for path, contents := range map[string]string{"/registry_test_cert.pem": certPEM, "/registry_test_key.pem": keyPEM} {
+			fileTar := CreateSingleFileTarReader(path, contents)
+			AssertNil(t, DockerCli(t).CopyToContainer(ctx, ctr.ID, "/", fileTar, types.CopyToContainerOptions{}))
+			fileTar.Close()
+		}
+	}
+
 	// Start container
 	AssertNil(t, DockerCli(t).ContainerStart(ctx, ctr.ID, types.ContainerStartOptions{}))
 
@@ -100,20 +198,50 @@ func (r *DockerRegistry) Start(t *testing.T) {
 	AssertNil(t, err)
 	r.Port = inspect.NetworkSettings.Ports["5000/tcp"][0].HostPort
 
+	scheme, client := "http", http.DefaultClient
+	if r.tls {
+		scheme = "https"
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: r.certPool}}}
+	}
+
+	if r.failFirst > 0 {
+		// Start proxy to fail the first write requests
+		var cert *tls.Certificate
+		if r.tls {
+			keyPair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+			AssertNil(t, err)
+			cert = &keyPair
+		}
+		target := &url.URL{Scheme: scheme, Host: "localhost:" + r.Port}
+		r.failingProxy = newFailingProxy(target, client.Transport, r.failFirst, r.failStatus, cert)
+		r.Port = r.failingProxy.port()
+	}
+
 	var authHeaders map[string]string
 	if r.username != "" {
 		// Write Docker config and configure auth headers
 		writeDockerConfig(t, r.DockerDirectory, r.Port, r.encodedAuth())
 		authHeaders = map[string]string{"Authorization": "Basic " + r.encodedAuth()}
 	}
+	if r.tokenAuth {
+		token, err := r.tokenServer.token("registry:catalog:*")
+		AssertNil(t, err)
+		authHeaders = map[string]string{"Authorization": "Bearer " + token}
+	}
 
 	// Wait for registry to be ready
 	Eventually(t, func() bool {
-		txt, err := HTTPGetE(fmt.Sprintf("http://localhost:%s/v2/_catalog", r.Port), authHeaders)
+		txt, err := httpGetE(client, fmt.Sprintf("%s://localhost:%s/v2/_catalog", scheme, r.Port), authHeaders)
 		return err == nil && txt != ""
 	}, 100*time.Millisecond, 10*time.Second)
 }
 
+// CertPool returns a pool containing the certificate the registry serves when it is started with WithTLS,
+// or nil otherwise.
+func (r *DockerRegistry) CertPool() *x509.CertPool {
+	return r.certPool
+}
+
 func (r *DockerRegistry) Stop(t *testing.T) {
 	t.Log("stop registry")
 	t.Helper()
@@ -121,6 +249,21 @@ func (r *DockerRegistry) Stop(t *testing.T) {
 		DockerCli(t).ContainerKill(context.Background(), r.Name, "SIGKILL")
 		DockerCli(t).ContainerRemove(context.TODO(), r.Name, types.ContainerRemoveOptions{Force: true})
 	}
+	if r.tokenServer != nil {
+		r.tokenServer.close()
+	}
+	if r.failingProxy != nil {
+		r.failingProxy.close()
+	}
+}
+
+// TokenURL returns the URL of the token service when the registry is started with WithTokenAuth, or an empty
+// string otherwise.
+func (r *DockerRegistry) TokenURL() string {
+	if r.tokenServer == nil {
+		return ""
+	}
+	return r.tokenServer.url()
 }
 
 func (r *DockerRegistry) RepoName(name string) string {
@@ -157,3 +300,37 @@ func writeDockerConfig(t *testing.T, configDir, port, auth string) {
 		0666,
 	))
 }
+
+// generateCertificate returns a PEM encoded self-signed certificate for localhost and its private key
+func generateCertificate(t *testing.T) (string, string) {
+	key, der := selfSignedCertificate(t)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	AssertNil(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
+
+// selfSignedCertificate returns a private key and a DER encoded certificate for localhost signed by that key
+func selfSignedCertificate(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	AssertNil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	AssertNil(t, err)
+	return key, der
+}
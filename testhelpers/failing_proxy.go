@@ -0,0 +1,69 @@
+package testhelpers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+)
+
+// failingProxy forwards requests to a registry, except that it responds to the first write requests with an error
+type failingProxy struct {
+	server    *httptest.Server
+	proxy     *httputil.ReverseProxy
+	mu        sync.Mutex
+	remaining int
+	status    int
+}
+
+// newFailingProxy starts a proxy to target that fails the first n write requests with status. It serves TLS with
+// cert when cert is not nil.
+func newFailingProxy(target *url.URL, transport http.RoundTripper, n, status int, cert *tls.Certificate) *failingProxy {
+	p := &failingProxy{remaining: n, status: status}
+	p.proxy = httputil.NewSingleHostReverseProxy(target)
+	p.proxy.Transport = transport
+
+	p.server = httptest.NewUnstartedServer(http.HandlerFunc(p.serve))
+	if cert != nil {
+		p.server.TLS = &tls.Config{Certificates: []tls.Certificate{*cert}}
+		p.server.StartTLS()
+	} else {
+		p.server.Start()
+	}
+	return p
+}
+
+func (p *failingProxy) serve(w http.ResponseWriter, req *http.Request) {
+	if p.shouldFail(req) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(p.status)
+		fmt.Fprintf(w, `{"errors":[{"code":"UNKNOWN","message":"simulated failure with status %d"}]}`, p.status)
+		return
+	}
+	p.proxy.ServeHTTP(w, req)
+}
+
+func (p *failingProxy) shouldFail(req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.remaining == 0 {
+		return false
+	}
+	p.remaining--
+	return true
+}
+
+func (p *failingProxy) port() string {
+	u, _ := url.Parse(p.server.URL)
+	return u.Port()
+}
+
+func (p *failingProxy) close() {
+	p.server.Close()
+}
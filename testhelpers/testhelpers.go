@@ -17,6 +17,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/buildpacks/imgutil"
 	"github.com/buildpacks/imgutil/layer"
 
 	dockertypes "github.com/docker/docker/api/types"
@@ -109,6 +110,41 @@ func AssertNil(t *testing.T, actual interface{}) {
 	}
 }
 
+// AssertImageDigestsEqual fails unless the identifiers of a and b refer to the same digest.
+// Only the digest is compared, so images saved under different names can be compared.
+func AssertImageDigestsEqual(t *testing.T, a, b imgutil.Image) {
+	t.Helper()
+
+	digestA, digestB := imageDigest(t, a), imageDigest(t, b)
+	if digestA != digestB {
+		t.Fatalf("Expected images to have the same digest, '%s' has '%s' and '%s' has '%s'", a.Name(), digestA, b.Name(), digestB)
+	}
+}
+
+// AssertImageDigestsNotEqual fails if the identifiers of a and b refer to the same digest
+func AssertImageDigestsNotEqual(t *testing.T, a, b imgutil.Image) {
+	t.Helper()
+
+	digestA, digestB := imageDigest(t, a), imageDigest(t, b)
+	if digestA == digestB {
+		t.Fatalf("Expected images to have different digests, '%s' and '%s' both have '%s'", a.Name(), b.Name(), digestA)
+	}
+}
+
+// imageDigest returns the digest part of the image identifier, dropping any repository name
+func imageDigest(t *testing.T, img imgutil.Image) string {
+	t.Helper()
+
+	id, err := img.Identifier()
+	AssertNil(t, err)
+
+	s := id.String()
+	if idx := strings.LastIndex(s, "@"); idx != -1 {
+		s = s[idx+1:]
+	}
+	return strings.TrimPrefix(s, "sha256:")
+}
+
 var dockerCliVal dockercli.CommonAPIClient
 var dockerCliOnce sync.Once
 
@@ -184,6 +220,10 @@ func PushImage(dockerCli dockercli.CommonAPIClient, ref string) error {
 }
 
 func HTTPGetE(url string, headers map[string]string) (string, error) {
+	return httpGetE(http.DefaultClient, url, headers)
+}
+
+func httpGetE(client *http.Client, url string, headers map[string]string) (string, error) {
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return "", errors.Wrap(err, "making new request")
@@ -193,7 +233,7 @@ func HTTPGetE(url string, headers map[string]string) (string, error) {
 		request.Header.Set(key, val)
 	}
 
-	resp, err := http.DefaultClient.Do(request)
+	resp, err := client.Do(request)
 	if err != nil {
 		return "", errors.Wrap(err, "doing request")
 	}
@@ -280,6 +320,17 @@ func CreateSingleFileLayerTar(layerPath, txt, osType string) (string, error) {
 	return tarFile.Name(), nil
 }
 
+// SingleFileLayerTar writes a layer tar containing a single file at layerPath with contents txt, and returns the
+// path to the tar along with its expected diff ID. Callers are responsible for removing the tar.
+func SingleFileLayerTar(t *testing.T, layerPath, txt, osType string) (tarPath string, diffID string) {
+	t.Helper()
+
+	tarPath, err := CreateSingleFileLayerTar(layerPath, txt, osType)
+	AssertNil(t, err)
+
+	return tarPath, FileDiffID(t, tarPath)
+}
+
 func WindowsBaseLayer(t *testing.T) string {
 	tarFile, err := ioutil.TempFile("", "windows-base-layer.tar")
 	AssertNil(t, err)
@@ -0,0 +1,128 @@
+package testhelpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+const (
+	tokenService = "imgutil-test-registry"
+	tokenIssuer  = "imgutil-test-token-issuer"
+)
+
+// tokenServer issues the bearer tokens a registry configured for token auth accepts, following
+// https://docs.docker.com/registry/spec/auth/token/
+type tokenServer struct {
+	server   *httptest.Server
+	key      *ecdsa.PrivateKey
+	certDER  []byte
+	username string
+	password string
+}
+
+func newTokenServer(key *ecdsa.PrivateKey, certDER []byte, username, password string) *tokenServer {
+	ts := &tokenServer{key: key, certDER: certDER, username: username, password: password}
+	ts.server = httptest.NewServer(http.HandlerFunc(ts.serveToken))
+	return ts
+}
+
+func (ts *tokenServer) url() string {
+	return ts.server.URL + "/token"
+}
+
+func (ts *tokenServer) close() {
+	ts.server.Close()
+}
+
+func (ts *tokenServer) serveToken(w http.ResponseWriter, req *http.Request) {
+	if username, password, ok := req.BasicAuth(); !ok || username != ts.username || password != ts.password {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	// every requested scope is granted, as scopes are only checked by the registry
+	var scopes []string
+	for _, scope := range req.URL.Query()["scope"] {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+	token, err := ts.token(scopes...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   3600,
+	})
+}
+
+type tokenAccess struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// token returns a JWT granting scopes, each of the form `type:name:action[,action...]`
+func (ts *tokenServer) token(scopes ...string) (string, error) {
+	access := []tokenAccess{}
+	for _, scope := range scopes {
+		first, last := strings.Index(scope, ":"), strings.LastIndex(scope, ":")
+		if first == -1 || first == last {
+			return "", fmt.Errorf("invalid scope '%s'", scope)
+		}
+		access = append(access, tokenAccess{
+			Type:    scope[:first],
+			Name:    scope[first+1 : last],
+			Actions: strings.Split(scope[last+1:], ","),
+		})
+	}
+
+	now := time.Now()
+	header, err := json.Marshal(map[string]interface{}{
+		"typ": "JWT",
+		"alg": "ES256",
+		"x5c": []string{base64.StdEncoding.EncodeToString(ts.certDER)},
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":    tokenIssuer,
+		"sub":    ts.username,
+		"aud":    tokenService,
+		"exp":    now.Add(time.Hour).Unix(),
+		"nbf":    now.Add(-time.Minute).Unix(),
+		"iat":    now.Unix(),
+		"jti":    RandString(16),
+		"access": access,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(payload))
+	r, s, err := ecdsa.Sign(rand.Reader, ts.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// ES256 signatures are the big-endian bytes of r and s, each padded to the size of the curve
+	size := (ts.key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(signature[size-len(rBytes):size], rBytes)
+	copy(signature[2*size-len(sBytes):], sBytes)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}